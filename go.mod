@@ -15,6 +15,7 @@ require (
 	github.com/percona/go-mysql v0.0.0-20210427141028-73d29c6da78c
 	github.com/percona/kardianos-service v0.0.0-20190315212910-599703f26f3b
 	github.com/prometheus/client_golang v0.8.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.0.0-20180326160409-38c53a9f4bfc
 	github.com/shatteredsilicon/ssm v0.0.0-20240611172354-eb902b433914
 	github.com/spf13/cobra v1.5.0
@@ -70,7 +71,6 @@ require (
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/procfs v0.7.3 // indirect
 	github.com/sean-/seed v0.0.0-20170313163322-e2103e2c3529 // indirect
 	github.com/shirou/gopsutil v3.21.11+incompatible // indirect
@@ -1091,8 +1091,10 @@ Client Address | localhost
 
 * System Time
 NTP Server (0.pool.ntp.org)         | .*
+NTP Consensus                       | .*
 SSM Server                          | .*
 SSM Client                          | .*
+Time Sync Daemon                    | .*
 SSM Server Time Drift               | OK
 SSM Client Time Drift               | OK
 SSM Client to SSM Server Time Drift | OK
@@ -151,7 +151,7 @@ if [ $1 -gt 1 ] || [ -f /usr/local/percona/pmm-client/pmm.yml ]; then
     fi
 
     # backup ssm service files under /etc/systemd/system
-    for file in /etc/systemd/system/ssm-{linux,mysql,mongodb,postgresql,proxysql}-metrics.service /etc/systemd/system/ssm-{mysql,mongodb}-queries.service; do
+    for file in /etc/systemd/system/ssm-{linux,mysql,mongodb,postgresql,proxysql}-metrics.service /etc/systemd/system/ssm-{mysql,mongodb,postgresql}-queries.service; do
         if ! [ -f "$file" ]; then
             continue
         fi
@@ -164,7 +164,7 @@ if [ $1 -gt 1 ] || [ -f /usr/local/percona/pmm-client/pmm.yml ]; then
 
     # copy back ssm service file to /etc/systemd/system because
     # they are listed in old package's %files section
-    for file in /etc/systemd/system/ssm-{linux,mysql,mongodb,postgresql,proxysql}-metrics.service.rpmsave /etc/systemd/system/ssm-{mysql,mongodb}-queries.service.rpmsave; do
+    for file in /etc/systemd/system/ssm-{linux,mysql,mongodb,postgresql,proxysql}-metrics.service.rpmsave /etc/systemd/system/ssm-{mysql,mongodb,postgresql}-queries.service.rpmsave; do
         if ! [ -f "$file" ]; then
             continue
         fi
@@ -179,6 +179,7 @@ fi
 %systemd_post ssm-mongodb-metrics.service
 %systemd_post ssm-mongodb-queries.service
 %systemd_post ssm-postgresql-metrics.service
+%systemd_post ssm-postgresql-queries.service
 %systemd_post ssm-proxysql-metrics.service
 
 %preun
@@ -193,6 +194,7 @@ fi
 %systemd_preun ssm-mongodb-metrics.service
 %systemd_preun ssm-mongodb-queries.service
 %systemd_preun ssm-postgresql-metrics.service
+%systemd_preun ssm-postgresql-queries.service
 %systemd_preun ssm-proxysql-metrics.service
 
 %postun
@@ -201,7 +203,7 @@ if [ "$1" = "0" ]; then
     rm -rf /opt/ss/ssm-client
     rm -rf /opt/ss/qan-agent
     rm -f /etc/systemd/system/ssm-{linux,mysql,mongodb,postgresql,proxysql}-metrics.service.rpmsave
-    rm -f /etc/systemd/system/ssm-{mysql,mongodb}-queries.service.rpmsave
+    rm -f /etc/systemd/system/ssm-{mysql,mongodb,postgresql}-queries.service.rpmsave
     echo "Uninstall complete."
 fi
 
@@ -211,6 +213,7 @@ fi
 %systemd_postun ssm-mongodb-metrics.service
 %systemd_postun ssm-mongodb-queries.service
 %systemd_postun ssm-postgresql-metrics.service
+%systemd_postun ssm-postgresql-queries.service
 %systemd_postun ssm-proxysql-metrics.service
 
 %files
@@ -20,19 +20,336 @@ package ssm
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
 	"regexp"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/docker/cli/templates"
 	"github.com/shatteredsilicon/ssm-client/ssm/managed"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
 )
 
+// Prefixes of tags annotations are scoped to this client with.
+const (
+	AnnotationNodeTag    = "node:"
+	AnnotationServiceTag = "service:"
+)
+
+// AnnotationOptions are options of annotation to post.
+type AnnotationOptions struct {
+	// Tags separated by comma.
+	Tags string
+	// Start is time of annotation, now if zero.
+	Start time.Time
+	// End makes region annotation from Start till End.
+	End time.Time
+	// Services of this client to tag annotation with.
+	Services []string
+	// NoScope disables automatic node and service tags.
+	NoScope bool
+}
+
+// Annotation is an annotation at SSM server.
+type Annotation struct {
+	ID    int64     `json:"id"`
+	Text  string    `json:"text"`
+	Tags  []string  `json:"tags"`
+	Start time.Time `json:"start"`
+	// End is nil for point annotation.
+	End *time.Time `json:"end,omitempty"`
+}
+
+// AnnotationFilter filters annotations to list.
+type AnnotationFilter struct {
+	Start time.Time
+	End   time.Time
+	// Tags separated by comma.
+	Tags string
+	// All lists annotations of all clients, not only of this one.
+	All   bool
+	Limit int
+}
+
+// splitTags splits tags by comma and trims spaces.
+func splitTags(tags string) []string {
+	list := []string{}
+	for _, tag := range regexp.MustCompile(`\s*,\s*`).Split(strings.TrimSpace(tags), -1) {
+		if tag != "" {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
+
+// ReadAnnotationText reads annotation text from the file, "-" is stdin.
+func ReadAnnotationText(file string) (string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
 // AddAnnotation posts annotation to managed.
-func (a *Admin) AddAnnotation(ctx context.Context, text string, tags string) error {
+func (a *Admin) AddAnnotation(ctx context.Context, text string, opts AnnotationOptions) error {
 	if text == "" {
 		return errors.New("failed to save annotation (empty annotation is not allowed)")
 	}
+	if !opts.End.IsZero() {
+		if opts.Start.IsZero() {
+			return errors.New("failed to save annotation (end time requires start time)")
+		}
+		if !opts.End.After(opts.Start) {
+			return errors.New("failed to save annotation (end time must be after start time)")
+		}
+	}
+
+	tags := splitTags(opts.Tags)
+	if !opts.NoScope {
+		scope, err := a.annotationScope(opts.Services)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, scope...)
+	}
+
 	return a.managedAPI.AnnotationCreate(ctx, &managed.APIAnnotationCreateRequest{
-		Text: text,
-		// split by comma and trim spaces if they were after comma
-		Tags: regexp.MustCompile(`,\s*`).Split(tags, -1),
+		Text:    text,
+		Tags:    tags,
+		Time:    unixMilli(opts.Start),
+		TimeEnd: unixMilli(opts.End),
+	})
+}
+
+// annotationScope returns tags of this client and the services, services must be monitored on this client.
+func (a *Admin) annotationScope(services []string) ([]string, error) {
+	tags := []string{AnnotationNodeTag + a.Config.ClientName}
+	if len(services) == 0 {
+		return tags, nil
+	}
+
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	if node != nil {
+		for _, svc := range node.Services {
+			for _, tag := range svc.Tags {
+				if strings.HasPrefix(tag, "alias_") {
+					names[tag[6:]] = true
+				}
+			}
+		}
+	}
+	for _, name := range services {
+		if !names[name] {
+			return nil, fmt.Errorf("service %s: %w", name, ErrNoService)
+		}
+		if tag := AnnotationServiceTag + name; !utils.SliceContains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// ListAnnotations returns annotations at SSM server, of this client unless filter.All is set.
+func (a *Admin) ListAnnotations(ctx context.Context, filter AnnotationFilter) ([]Annotation, error) {
+	tags := splitTags(filter.Tags)
+	if !filter.All {
+		tags = append(tags, AnnotationNodeTag+a.Config.ClientName)
+	}
+	resp, err := a.managedAPI.AnnotationList(ctx, &managed.APIAnnotationListRequest{
+		From:  unixMilli(filter.Start),
+		To:    unixMilli(filter.End),
+		Tags:  tags,
+		Limit: filter.Limit,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Annotation{}
+	for _, an := range resp.Annotations {
+		item := Annotation{
+			ID:    an.ID,
+			Text:  an.Text,
+			Tags:  an.Tags,
+			Start: time.Unix(0, an.Time*int64(time.Millisecond)),
+		}
+		if an.TimeEnd != 0 && an.TimeEnd != an.Time {
+			end := time.Unix(0, an.TimeEnd*int64(time.Millisecond))
+			item.End = &end
+		}
+		if item.Tags == nil {
+			item.Tags = []string{}
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// DeleteAnnotation deletes annotation at SSM server.
+func (a *Admin) DeleteAnnotation(ctx context.Context, id int64) error {
+	return a.managedAPI.AnnotationDelete(ctx, id)
+}
+
+// PrintAnnotations prints annotations.
+func (a *Admin) PrintAnnotations(list []Annotation) {
+	if a.Format != "" {
+		tmpl, err := templates.Parse(a.Format)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := tmpl.Execute(os.Stdout, list); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println()
+		return
+	}
+
+	if len(list) == 0 {
+		fmt.Println("No annotations found.")
+		return
+	}
+	timeFormat := "2006-01-02 15:04:05"
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART\tEND\tTAGS\tTEXT")
+	for _, an := range list {
+		end := "-"
+		if an.End != nil {
+			end = an.End.Format(timeFormat)
+		}
+		// Keep multi-line text on one row.
+		text := strings.Join(strings.Fields(an.Text), " ")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", an.ID, an.Start.Format(timeFormat), end, strings.Join(an.Tags, ", "), text)
+	}
+	w.Flush()
+}
+
+// unixMilli returns milliseconds since epoch, zero for zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Tags of annotations posted by AnnotateExec.
+const (
+	AnnotationExecStarted  = "exec:started"
+	AnnotationExecFinished = "exec:finished"
+	AnnotationExecFailed   = "exec:failed"
+)
+
+// AnnotateExec runs the command between start and completion annotations and returns its exit code.
+// Stdout of the command is streamed to output, SIGINT and SIGTERM are forwarded to it.
+// Annotations which can not be posted are reported as warnings so the command runs regardless of SSM server.
+// timeout limits posting of each annotation.
+func (a *Admin) AnnotateExec(text string, command []string, opts AnnotationOptions, output io.Writer, timeout time.Duration) (int, error) {
+	if len(command) == 0 {
+		return ExitError, errors.New("command to run is required")
+	}
+	if text == "" {
+		text = strings.Join(command, " ")
+	}
+
+	post := func(text string, opts AnnotationOptions) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := a.AddAnnotation(ctx, text, opts); err != nil {
+			fmt.Printf("Warning: annotation %q could not be posted: %s\n", text, err)
+		}
+	}
+	withTag := func(tag string) AnnotationOptions {
+		o := opts
+		o.Tags = strings.Join(append(splitTags(opts.Tags), tag), ",")
+		return o
+	}
+
+	start := time.Now()
+	startOpts := withTag(AnnotationExecStarted)
+	startOpts.Start = start
+	post(fmt.Sprintf("%s: started", text), startOpts)
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, output, os.Stderr
+	code, err := runForwardingSignals(cmd)
+	end := time.Now()
+	duration := end.Sub(start).Round(time.Millisecond)
+
+	var endOpts AnnotationOptions
+	var endText string
+	switch {
+	case err != nil:
+		endOpts = withTag(AnnotationExecFailed)
+		endText = fmt.Sprintf("%s: failed to run after %s: %s", text, duration, err)
+	case code != 0:
+		endOpts = withTag(AnnotationExecFailed)
+		endText = fmt.Sprintf("%s: failed with exit code %d after %s", text, code, duration)
+	default:
+		endOpts = withTag(AnnotationExecFinished)
+		endText = fmt.Sprintf("%s: finished with exit code 0 in %s", text, duration)
+	}
+	// Region annotation over the whole run, a point one if it was too short to show as region.
+	endOpts.Start = start
+	if end.Sub(start) >= time.Millisecond {
+		endOpts.End = end
+	}
+	post(endText, endOpts)
+	return code, err
+}
+
+// runForwardingSignals runs the command forwarding SIGINT and SIGTERM to it and returns its exit code.
+// Like shells do, the code is 127 if the command can not be started and 128+N if it is killed by signal N.
+func runForwardingSignals(cmd *exec.Cmd) (int, error) {
+	if err := cmd.Start(); err != nil {
+		return 127, err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	if err == nil {
+		return 0, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	return ExitError, err
 }
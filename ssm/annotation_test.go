@@ -18,8 +18,13 @@
 package ssm
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -27,6 +32,7 @@ import (
 	"github.com/shatteredsilicon/ssm-client/ssm/managed"
 	"github.com/shatteredsilicon/ssm-client/tests/fakeapi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestAdmin_AddAnnotation tests add annotation to managed
@@ -40,12 +46,12 @@ func TestAdmin_AddAnnotation(t *testing.T) {
 
 	// create ssm-admin instance
 	admin := &Admin{}
-	insecureFlag := true
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
 	timeout := 1 * time.Second
 	debug := false
-	admin.qanAPI = NewAPI(insecureFlag, timeout, debug)
+	admin.qanAPI = NewAPI(tlsConfig, timeout, debug)
 	hostPort := fmt.Sprintf("%s:%s", host, port)
-	admin.managedAPI = managed.NewClient(hostPort, "managed", "http", &url.Userinfo{}, false, true)
+	admin.managedAPI = managed.NewClient(hostPort, "managed", "http", &url.Userinfo{}, nil, true)
 
 	// point ssm-admin to fake http api
 	admin.serverURL = hostPort
@@ -53,9 +59,84 @@ func TestAdmin_AddAnnotation(t *testing.T) {
 	authStr := ""
 	admin.serverURL = fmt.Sprintf("%s://%s%s", scheme, authStr, hostPort)
 
-	err := admin.AddAnnotation(context.TODO(), "Description", "tag1, tag2")
+	admin.Config = &Config{ClientName: "client1"}
+
+	err := admin.AddAnnotation(context.TODO(), "Description", AnnotationOptions{Tags: "tag1, tag2"})
 	assert.Nil(t, err)
 
-	err = admin.AddAnnotation(context.TODO(), "", "tag1, tag2")
+	err = admin.AddAnnotation(context.TODO(), "", AnnotationOptions{Tags: "tag1, tag2"})
 	assert.Equal(t, "failed to save annotation (empty annotation is not allowed)", err.Error())
+
+	start := time.Now()
+	err = admin.AddAnnotation(context.TODO(), "Description", AnnotationOptions{Start: start, End: start.Add(-time.Minute)})
+	assert.Equal(t, "failed to save annotation (end time must be after start time)", err.Error())
+	err = admin.AddAnnotation(context.TODO(), "Description", AnnotationOptions{End: start})
+	assert.Equal(t, "failed to save annotation (end time requires start time)", err.Error())
+
+	list, err := admin.ListAnnotations(context.TODO(), AnnotationFilter{})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, []string{"v1.2", "node:client1"}, list[0].Tags)
+	assert.Nil(t, list[0].End)
+	assert.Equal(t, []string{}, list[1].Tags)
+	require.NotNil(t, list[1].End)
+	assert.Equal(t, 90*time.Minute, list[1].End.Sub(list[1].Start))
+
+	assert.NoError(t, admin.DeleteAnnotation(context.TODO(), 1))
+	err = admin.DeleteAnnotation(context.TODO(), 2)
+	assert.Equal(t, ExitNotFound, ExitCode(err))
+}
+
+func TestSplitTags(t *testing.T) {
+	assert.Equal(t, []string{}, splitTags(""))
+	assert.Equal(t, []string{"UI", "v1.2"}, splitTags(" UI , v1.2,"))
+}
+
+func TestAdmin_AnnotateExec(t *testing.T) {
+	var requests []managed.APIAnnotationCreateRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req managed.APIAnnotationCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err == nil {
+			requests = append(requests, req)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	admin := &Admin{Config: &Config{ClientName: "client1"}}
+	admin.managedAPI = managed.NewClient(u.Host, "managed", "http", &url.Userinfo{}, nil, false)
+
+	var output bytes.Buffer
+	code, err := admin.AnnotateExec("Migration", []string{"sh", "-c", "sleep 0.01; echo migrated; exit 3"}, AnnotationOptions{Tags: "db"}, &output, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "migrated\n", output.String())
+	require.Len(t, requests, 2)
+	assert.Equal(t, "Migration: started", requests[0].Text)
+	assert.Equal(t, []string{"db", AnnotationExecStarted, "node:client1"}, requests[0].Tags)
+	assert.Regexp(t, `^Migration: failed with exit code 3 after \d+ms$`, requests[1].Text)
+	assert.Equal(t, []string{"db", AnnotationExecFailed, "node:client1"}, requests[1].Tags)
+	assert.Equal(t, requests[0].Time, requests[1].Time)
+	assert.True(t, requests[1].TimeEnd > requests[1].Time)
+
+	requests = nil
+	code, err = admin.AnnotateExec("", []string{"true"}, AnnotationOptions{NoScope: true}, &output, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	require.Len(t, requests, 2)
+	assert.Regexp(t, `^true: finished with exit code 0 in `, requests[1].Text)
+	assert.Equal(t, []string{AnnotationExecFinished}, requests[1].Tags)
+
+	requests = nil
+	code, err = admin.AnnotateExec("Deploy", []string{"/nonexistent/deploy.sh"}, AnnotationOptions{NoScope: true}, &output, time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, 127, code)
+	require.Len(t, requests, 2)
+	assert.Equal(t, []string{AnnotationExecFailed}, requests[1].Tags)
+
+	// Command runs even if SSM server is down.
+	ts.Close()
+	code, err = admin.AnnotateExec("Deploy", []string{"sh", "-c", "exit 2"}, AnnotationOptions{NoScope: true}, &output, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2, code)
 }
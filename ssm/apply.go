@@ -0,0 +1,581 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	linuxMetrics "github.com/shatteredsilicon/ssm-client/ssm/plugin/linux/metrics"
+	mongodbMetrics "github.com/shatteredsilicon/ssm-client/ssm/plugin/mongodb/metrics"
+	mongodbQueries "github.com/shatteredsilicon/ssm-client/ssm/plugin/mongodb/queries"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin/mysql"
+	mysqlMetrics "github.com/shatteredsilicon/ssm-client/ssm/plugin/mysql/metrics"
+	mysqlQueries "github.com/shatteredsilicon/ssm-client/ssm/plugin/mysql/queries"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin/postgresql"
+	postgresqlMetrics "github.com/shatteredsilicon/ssm-client/ssm/plugin/postgresql/metrics"
+	postgresqlQueries "github.com/shatteredsilicon/ssm-client/ssm/plugin/postgresql/queries"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin/proxysql"
+	proxysqlMetrics "github.com/shatteredsilicon/ssm-client/ssm/plugin/proxysql/metrics"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
+	pc "github.com/shatteredsilicon/ssm/proto/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Inventory describes the desired set of monitoring services for this client.
+type Inventory struct {
+	Services []InventoryService `yaml:"services"`
+}
+
+// InventoryService describes one desired monitoring service.
+type InventoryService struct {
+	Type string `yaml:"type"`
+	Name string `yaml:"name,omitempty"`
+
+	// Connection options.
+	User               string `yaml:"user,omitempty"`
+	Password           string `yaml:"password,omitempty"`
+	Host               string `yaml:"host,omitempty"`
+	Port               string `yaml:"port,omitempty"`
+	Socket             string `yaml:"socket,omitempty"`
+	DefaultsFile       string `yaml:"defaults_file,omitempty"`
+	SSLMode            string `yaml:"sslmode,omitempty"`
+	URI                string `yaml:"uri,omitempty"`
+	DSN                string `yaml:"dsn,omitempty"`
+	CreateUser         bool   `yaml:"create_user,omitempty"`
+	CreateUserPassword string `yaml:"create_user_password,omitempty"`
+	MaxUserConn        uint16 `yaml:"create_user_maxconn,omitempty"`
+
+	// Exporter options.
+	DisableSSL             *bool    `yaml:"disable_ssl,omitempty"`
+	Cluster                string   `yaml:"cluster,omitempty"`
+	DisableTableStats      bool     `yaml:"disable_tablestats,omitempty"`
+	DisableTableStatsLimit uint16   `yaml:"disable_tablestats_limit,omitempty"`
+	DisableUserStats       bool     `yaml:"disable_userstats,omitempty"`
+	DisableBinlogStats     bool     `yaml:"disable_binlogstats,omitempty"`
+	DisableProcesslist     bool     `yaml:"disable_processlist,omitempty"`
+	Args                   []string `yaml:"args,omitempty"`
+
+	// QAN options.
+	QuerySource          string   `yaml:"query_source,omitempty"`
+	DisableQueryExamples bool     `yaml:"disable_queryexamples,omitempty"`
+	SlowLogRotation      *bool    `yaml:"slow_log_rotation,omitempty"`
+	RetainSlowLogs       *int     `yaml:"retain_slow_logs,omitempty"`
+	FilterOmit           []string `yaml:"qan_filter_omit,omitempty"`
+	FilterAllow          []string `yaml:"qan_filter_allow,omitempty"`
+	CreateExtension      bool     `yaml:"create_extension,omitempty"`
+}
+
+// Plan actions.
+const (
+	PlanAdd     = "add"
+	PlanRemove  = "remove"
+	PlanReplace = "replace"
+)
+
+// PlanAction is a single step required to converge monitoring services to the inventory.
+type PlanAction struct {
+	Action  string
+	Type    string
+	Name    string
+	Changes []string
+
+	svc *InventoryService
+}
+
+// String returns human-readable representation of the action.
+func (p PlanAction) String() string {
+	sign := map[string]string{PlanAdd: "+", PlanRemove: "-", PlanReplace: "~"}[p.Action]
+	s := fmt.Sprintf("%s %s %s", sign, p.Type, p.Name)
+	if len(p.Changes) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(p.Changes, ", "))
+	}
+	return s
+}
+
+// querySources are query_source values by service type, other types have no query source to choose.
+var querySources = map[string][]string{
+	plugin.MySQLQueries:      {"auto", "slowlog", "perfschema"},
+	plugin.PostgreSQLQueries: {"pg_stat_statements"},
+}
+
+// LoadInventory reads and validates inventory file.
+func LoadInventory(file, defaultName string) (*Inventory, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{}
+	if err := yaml.Unmarshal(bytes, inv); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range inv.Services {
+		svc := &inv.Services[i]
+		if err := isValidSvcType(svc.Type); err != nil {
+			return nil, fmt.Errorf("service #%d: %s", i+1, err)
+		}
+		if svc.Name == "" {
+			svc.Name = defaultName
+		}
+		if match, _ := regexp.MatchString(NameRegex, svc.Name); !match {
+			return nil, fmt.Errorf("service #%d: name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :", i+1)
+		}
+		if svc.QuerySource != "" {
+			sources, ok := querySources[svc.Type]
+			if !ok {
+				return nil, fmt.Errorf("service #%d: %s has no query_source.", i+1, svc.Type)
+			}
+			if !utils.SliceContains(sources, svc.QuerySource) {
+				return nil, fmt.Errorf("service #%d: query_source of %s can take the following values: %s.",
+					i+1, svc.Type, strings.Join(sources, ", "))
+			}
+		}
+		if err := plugin.ValidateFilters(svc.FilterOmit); err != nil {
+			return nil, fmt.Errorf("service #%d: qan_filter_omit: %s", i+1, err)
+		}
+		if err := plugin.ValidateFilters(svc.FilterAllow); err != nil {
+			return nil, fmt.Errorf("service #%d: qan_filter_allow: %s", i+1, err)
+		}
+		key := svc.Type + "/" + svc.Name
+		if seen[key] {
+			return nil, fmt.Errorf("service #%d: %s %s is listed more than once.", i+1, svc.Type, svc.Name)
+		}
+		seen[key] = true
+	}
+
+	return inv, nil
+}
+
+// Plan compares inventory with services registered for this client and returns actions to converge them.
+func (a *Admin) Plan(inv *Inventory) ([]PlanAction, error) {
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var current []ServiceStatus
+	if node != nil {
+		current = a.getSVCTable(node)
+	}
+
+	return planInventory(inv, current), nil
+}
+
+// Apply executes plan actions. Removals run first so replaced services can be added back.
+// Replaced service is brought back with its old definition if the new one fails to add.
+func (a *Admin) Apply(ctx context.Context, actions []PlanAction) error {
+	for _, action := range actions {
+		if action.Action != PlanRemove {
+			continue
+		}
+		fmt.Println(action)
+		if err := a.applyRemove(action); err != nil {
+			return fmt.Errorf("%s %s: %s", action.Type, action.Name, err)
+		}
+	}
+
+	for _, action := range actions {
+		var err error
+		switch action.Action {
+		case PlanAdd:
+			fmt.Println(action)
+			err = a.applyAdd(ctx, action.svc)
+		case PlanReplace:
+			fmt.Println(action)
+			err = a.applyReplace(ctx, action)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", action.Type, action.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// applyReplace removes the service and adds it with the new definition,
+// the old one is restored if adding fails.
+func (a *Admin) applyReplace(ctx context.Context, action PlanAction) error {
+	backup, err := a.backupService(action.Type, action.Name)
+	if err != nil {
+		return err
+	}
+	if err := a.applyRemove(action); err != nil {
+		return err
+	}
+
+	addErr := a.applyAdd(ctx, action.svc)
+	if addErr == nil {
+		return nil
+	}
+	// Drop whatever the failed add has left before bringing the old service back.
+	if err := a.applyRemove(action); err != nil && !errors.Is(err, ErrNoService) {
+		return fmt.Errorf("%s, cleanup failed: %s", addErr, err)
+	}
+	if err := a.restoreService(backup); err != nil {
+		return fmt.Errorf("%s, restoring the old service failed: %s", addErr, err)
+	}
+	return fmt.Errorf("%s, the old service is restored", addErr)
+}
+
+// serviceBackup is the state of the service needed to bring it back after removal.
+type serviceBackup struct {
+	name  string
+	svc   *consul.AgentService
+	kv    consul.KVPairs
+	files map[string][]byte
+	// QAN config of queries service.
+	qan *pc.QAN
+}
+
+// backupService saves Consul registration, KV and local files of the service.
+func (a *Admin) backupService(serviceType, name string) (*serviceBackup, error) {
+	svc, err := a.getConsulService(serviceType, name)
+	if err != nil {
+		return nil, err
+	}
+	if svc == nil {
+		return nil, fmt.Errorf("service %s: %w", name, ErrNoService)
+	}
+	b := &serviceBackup{name: name, svc: svc, files: map[string][]byte{}}
+
+	queries := strings.HasSuffix(serviceType, ":queries")
+	prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, svc.ID)
+	if queries {
+		prefix = fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, svc.ID, name)
+	}
+	if b.kv, _, err = a.consulAPI.KV().List(prefix, nil); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if queries {
+		for _, kvp := range b.kv {
+			if !strings.HasPrefix(path.Base(kvp.Key), "qan_") || !strings.HasSuffix(kvp.Key, "_uuid") {
+				continue
+			}
+			uuid := string(kvp.Value)
+			config := fmt.Sprintf("%s/config/qan-%s.conf", AgentBaseDir, uuid)
+			if b.qan, err = getProtoQAN(config); err != nil {
+				return nil, err
+			}
+			files = append(files, config, fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, uuid))
+		}
+	} else {
+		files = append(files, instanceConfigPath(serviceType, serviceInstance(svc.ID)))
+	}
+	for _, file := range files {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		b.files[file] = bytes
+	}
+	return b, nil
+}
+
+// restoreService brings back the service saved by backupService.
+func (a *Admin) restoreService(b *serviceBackup) error {
+	for file, bytes := range b.files {
+		if err := ioutil.WriteFile(file, bytes, 0600); err != nil {
+			return err
+		}
+	}
+
+	serviceType := b.svc.Service
+	instance := serviceInstance(b.svc.ID)
+	if b.qan == nil {
+		// System services of exporter instances are removed along with them.
+		if instance != "" {
+			if err := installInstanceService(serviceType, instance); err != nil {
+				return err
+			}
+		}
+		if _, err := a.assignExporterPort(serviceType, instance, b.svc.Port); err != nil {
+			return err
+		}
+	}
+
+	reg := consul.CatalogRegistration{
+		Node:    a.Config.ClientName,
+		Address: a.Config.ClientAddress,
+		Service: b.svc,
+	}
+	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+		return err
+	}
+	for _, kvp := range b.kv {
+		if _, err := a.consulAPI.KV().Put(kvp, nil); err != nil {
+			return err
+		}
+	}
+
+	name := instanceServiceName(serviceType, instance)
+	if err := startService(name); err != nil {
+		return err
+	}
+	if err := enableService(name); err != nil {
+		return err
+	}
+	if b.qan == nil {
+		return nil
+	}
+
+	// QAN instance was deleted on server, un-delete it and start QAN on agent again.
+	if err := a.restoreInstance(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, b.qan.UUID), b.name, b.name); err != nil {
+		return err
+	}
+	agentID, err := getAgentID(fmt.Sprintf("%s/config/agent.conf", AgentBaseDir))
+	if err != nil {
+		return err
+	}
+	return a.startQAN(agentID, *b.qan)
+}
+
+func (a *Admin) applyRemove(action PlanAction) error {
+	a.ServiceName = action.Name
+	parts := strings.Split(action.Type, ":")
+	if parts[1] == plugin.TypeQueries {
+		return a.RemoveQueries(parts[0])
+	}
+	return a.RemoveMetrics(parts[0])
+}
+
+func (a *Admin) applyAdd(ctx context.Context, svc *InventoryService) error {
+	a.ServiceName = svc.Name
+	a.Args = svc.Args
+
+	disableSSL := svc.Type == plugin.LinuxMetrics
+	if svc.DisableSSL != nil {
+		disableSSL = *svc.DisableSSL
+	}
+
+	queriesFlags := plugin.QueriesFlags{
+		DisableQueryExamples: svc.DisableQueryExamples,
+	}
+	mysqlFlags := mysql.Flags{
+		DefaultsFile:       svc.DefaultsFile,
+		User:               svc.User,
+		Password:           svc.Password,
+		Host:               svc.Host,
+		Port:               svc.Port,
+		Socket:             svc.Socket,
+		CreateUser:         svc.CreateUser,
+		CreateUserPassword: svc.CreateUserPassword,
+		MaxUserConn:        svc.MaxUserConn,
+		FilterOmit:         svc.FilterOmit,
+		FilterAllow:        svc.FilterAllow,
+	}
+	if mysqlFlags.MaxUserConn == 0 {
+		mysqlFlags.MaxUserConn = 10
+	}
+	postgresqlFlags := postgresql.Flags{
+		DSN: postgresql.DSN{
+			User:     svc.User,
+			Password: svc.Password,
+			Host:     svc.Host,
+			Port:     svc.Port,
+			SSLMode:  svc.SSLMode,
+		},
+		CreateUser:         svc.CreateUser,
+		CreateUserPassword: svc.CreateUserPassword,
+	}
+	if postgresqlFlags.SSLMode == "" {
+		postgresqlFlags.SSLMode = "disable"
+	}
+	uri := svc.URI
+	if uri == "" {
+		uri = "127.0.0.1:27017"
+	}
+
+	var err error
+	switch svc.Type {
+	case plugin.LinuxMetrics:
+		_, err = a.AddMetrics(ctx, linuxMetrics.New(SSMBaseDir), true, disableSSL)
+	case plugin.MySQLMetrics:
+		flags := mysqlMetrics.Flags{
+			DisableTableStats:      svc.DisableTableStats,
+			DisableTableStatsLimit: svc.DisableTableStatsLimit,
+			DisableUserStats:       svc.DisableUserStats,
+			DisableBinlogStats:     svc.DisableBinlogStats,
+			DisableProcesslist:     svc.DisableProcesslist,
+		}
+		if flags.DisableTableStatsLimit == 0 {
+			flags.DisableTableStatsLimit = 1000
+		}
+		_, err = a.AddMetrics(ctx, mysqlMetrics.New(flags, mysqlFlags, SSMBaseDir), false, disableSSL)
+	case plugin.MySQLQueries:
+		flags := mysqlQueries.Flags{
+			QuerySource:     svc.QuerySource,
+			RetainSlowLogs:  1,
+			SlowLogRotation: true,
+		}
+		if flags.QuerySource == "" {
+			flags.QuerySource = "auto"
+		}
+		if svc.RetainSlowLogs != nil {
+			flags.RetainSlowLogs = *svc.RetainSlowLogs
+		}
+		if svc.SlowLogRotation != nil {
+			flags.SlowLogRotation = *svc.SlowLogRotation
+		}
+		_, err = a.AddQueries(ctx, mysqlQueries.New(queriesFlags, flags, mysqlFlags), nil)
+	case plugin.MongoDBMetrics:
+		_, err = a.AddMetrics(ctx, mongodbMetrics.New(uri, svc.Args, svc.Cluster, SSMBaseDir), false, disableSSL)
+	case plugin.MongoDBQueries:
+		_, err = a.AddQueries(ctx, mongodbQueries.New(queriesFlags, uri, svc.Args, SSMBaseDir), nil)
+	case plugin.PostgreSQLMetrics:
+		_, err = a.AddMetrics(ctx, postgresqlMetrics.New(postgresqlFlags, SSMBaseDir), false, disableSSL)
+	case plugin.PostgreSQLQueries:
+		flags := postgresqlQueries.Flags{
+			CreateExtension: svc.CreateExtension,
+		}
+		_, err = a.AddQueries(ctx, postgresqlQueries.New(queriesFlags, flags, postgresqlFlags), nil)
+	case plugin.ProxySQLMetrics:
+		dsn := svc.DSN
+		if dsn == "" {
+			dsn = "stats:stats@tcp(localhost:6032)/"
+		}
+		flags := proxysql.Flags{
+			DSN:                dsn,
+			CreateUser:         svc.CreateUser,
+			CreateUserPassword: svc.CreateUserPassword,
+		}
+		_, err = a.AddMetrics(ctx, proxysqlMetrics.New(flags, SSMBaseDir), false, disableSSL)
+	default:
+		err = fmt.Errorf("service type %s is not supported.", svc.Type)
+	}
+
+	return err
+}
+
+// planInventory diffs desired services against the current service table.
+func planInventory(inv *Inventory, current []ServiceStatus) []PlanAction {
+	existing := map[string]ServiceStatus{}
+	for _, row := range current {
+		existing[row.Type+"/"+row.Name] = row
+	}
+
+	var actions []PlanAction
+	desired := map[string]bool{}
+	for i := range inv.Services {
+		svc := &inv.Services[i]
+		key := svc.Type + "/" + svc.Name
+		desired[key] = true
+
+		row, ok := existing[key]
+		if !ok {
+			actions = append(actions, PlanAction{Action: PlanAdd, Type: svc.Type, Name: svc.Name, svc: svc})
+			continue
+		}
+		if changes := svc.changes(row); len(changes) > 0 {
+			actions = append(actions, PlanAction{Action: PlanReplace, Type: svc.Type, Name: svc.Name, Changes: changes, svc: svc})
+		}
+	}
+
+	var removals []PlanAction
+	for key, row := range existing {
+		if desired[key] {
+			continue
+		}
+		removals = append(removals, PlanAction{Action: PlanRemove, Type: row.Type, Name: row.Name})
+	}
+	sort.Slice(removals, func(i, j int) bool {
+		if removals[i].Type != removals[j].Type {
+			return removals[i].Type < removals[j].Type
+		}
+		return removals[i].Name < removals[j].Name
+	})
+
+	return append(removals, actions...)
+}
+
+// changes returns the list of options which differ between inventory and running service.
+// Only options that can be derived from the service table are compared.
+func (svc *InventoryService) changes(row ServiceStatus) []string {
+	opts := map[string]string{}
+	for _, opt := range strings.Split(row.Options, ", ") {
+		if kv := strings.SplitN(opt, "=", 2); len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+		}
+	}
+
+	var changes []string
+	compare := func(key, want string) {
+		if got := opts[key]; got != want {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", key, got, want))
+		}
+	}
+
+	if strings.HasSuffix(svc.Type, ":metrics") {
+		disableSSL := svc.Type == plugin.LinuxMetrics
+		if svc.DisableSSL != nil {
+			disableSSL = *svc.DisableSSL
+		}
+		scheme := ""
+		if disableSSL {
+			scheme = "http"
+		}
+		compare("scheme", scheme)
+	}
+
+	off := func(disabled bool) string {
+		if disabled {
+			return "OFF"
+		}
+		return ""
+	}
+	switch svc.Type {
+	case plugin.MySQLMetrics:
+		// Table stats may be disabled automatically, so compare them only when requested explicitly.
+		if svc.DisableTableStats {
+			compare("tablestats", "OFF")
+		}
+		compare("userstats", off(svc.DisableUserStats))
+		compare("binlogstats", off(svc.DisableBinlogStats))
+		compare("processlist", off(svc.DisableProcesslist))
+	case plugin.MongoDBMetrics:
+		compare("cluster", svc.Cluster)
+	case plugin.MySQLQueries, plugin.MongoDBQueries, plugin.PostgreSQLQueries:
+		if svc.QuerySource != "" && svc.QuerySource != "auto" {
+			compare("query_source", svc.QuerySource)
+		}
+		compare("query_examples", strconv.FormatBool(!svc.DisableQueryExamples))
+		if svc.Type == plugin.MySQLQueries && opts["query_source"] == "slowlog" {
+			if svc.SlowLogRotation != nil {
+				compare("slow_log_rotation", strconv.FormatBool(*svc.SlowLogRotation))
+			}
+			if svc.RetainSlowLogs != nil {
+				compare("retain_slow_logs", strconv.Itoa(*svc.RetainSlowLogs))
+			}
+		}
+	}
+
+	return changes
+}
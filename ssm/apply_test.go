@@ -0,0 +1,138 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanInventory(t *testing.T) {
+	inv := &Inventory{
+		Services: []InventoryService{
+			{Type: "linux:metrics", Name: "db1"},
+			{Type: "mysql:metrics", Name: "db1", DisableProcesslist: true},
+			{Type: "mysql:queries", Name: "db1", QuerySource: "perfschema"},
+			{Type: "mysql:metrics", Name: "db2"},
+		},
+	}
+	current := []ServiceStatus{
+		{Type: "linux:metrics", Name: "db1", Options: "scheme=http, region=client, distro=Linux"},
+		{Type: "mysql:metrics", Name: "db1", Options: "region=client, processlist=OFF"},
+		{Type: "mysql:queries", Name: "db1", Options: "query_source=slowlog, query_examples=true"},
+		{Type: "mongodb:metrics", Name: "old", Options: "region=client"},
+	}
+
+	actions := planInventory(inv, current)
+	var got []string
+	for _, action := range actions {
+		got = append(got, action.String())
+	}
+	expected := []string{
+		`- mongodb:metrics old`,
+		`~ mysql:queries db1 (query_source: "slowlog" -> "perfschema")`,
+		`+ mysql:metrics db2`,
+	}
+	assert.Equal(t, expected, got)
+}
+
+func TestPlanInventoryNoChanges(t *testing.T) {
+	disableSSL := false
+	inv := &Inventory{
+		Services: []InventoryService{
+			{Type: "linux:metrics", Name: "db1", DisableSSL: &disableSSL},
+			{Type: "mongodb:metrics", Name: "db1", Cluster: "rs"},
+		},
+	}
+	current := []ServiceStatus{
+		{Type: "linux:metrics", Name: "db1", Options: "region=client"},
+		{Type: "mongodb:metrics", Name: "db1", Options: "region=client, cluster=rs"},
+	}
+
+	assert.Empty(t, planInventory(inv, current))
+}
+
+func TestAdmin_BackupService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-apply")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(baseDir string) { SSMBaseDir = baseDir }(SSMBaseDir)
+	SSMBaseDir = dir
+	cfgPath := filepath.Join(dir, "mysqld_exporter-db02.conf")
+	require.NoError(t, ioutil.WriteFile(cfgPath, []byte("[web]\nlisten-address = 127.0.0.1:42003\n"), 0600))
+
+	fake := &fakeConsul{
+		node: "client1",
+		services: map[string]*consul.AgentService{
+			"mysql:metrics@db02": {ID: "mysql:metrics@db02", Service: "mysql:metrics", Port: 42003,
+				Tags: []string{"alias_db02", "scheme_https"}},
+		},
+		kv: map[string][]byte{
+			"client1/mysql:metrics@db02/dsn": []byte("root@tcp(localhost:3307)/"),
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	admin := &Admin{Config: &Config{ClientName: "client1"}}
+	admin.consulAPI, err = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+
+	b, err := admin.backupService("mysql:metrics", "db02")
+	require.NoError(t, err)
+	assert.Equal(t, 42003, b.svc.Port)
+	require.Len(t, b.kv, 1)
+	assert.Equal(t, "client1/mysql:metrics@db02/dsn", b.kv[0].Key)
+	assert.Equal(t, "[web]\nlisten-address = 127.0.0.1:42003\n", string(b.files[cfgPath]))
+	assert.Nil(t, b.qan)
+
+	_, err = admin.backupService("mysql:metrics", "db03")
+	assert.True(t, errors.Is(err, ErrNoService), "%v", err)
+}
+
+func TestLoadInventoryQuerySource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-inventory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	load := func(svc string) error {
+		file := filepath.Join(dir, "inventory.yml")
+		require.NoError(t, ioutil.WriteFile(file, []byte("services:\n  - "+svc+"\n"), 0600))
+		_, err := LoadInventory(file, "db01")
+		return err
+	}
+
+	assert.NoError(t, load("{type: mysql:queries, query_source: slowlog}"))
+	assert.NoError(t, load("{type: postgresql:queries, query_source: pg_stat_statements}"))
+	assert.NoError(t, load("{type: mongodb:queries}"))
+
+	err = load("{type: postgresql:queries, query_source: perfschema}")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pg_stat_statements")
+	assert.Error(t, load("{type: mysql:queries, query_source: pg_stat_statements}"))
+	assert.Error(t, load("{type: mongodb:queries, query_source: auto}"))
+	assert.Error(t, load("{type: mysql:metrics, query_source: slowlog}"))
+}
@@ -0,0 +1,447 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/cli/templates"
+	"github.com/fatih/color"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
+	"gopkg.in/ini.v1"
+)
+
+// Exporters serve metrics over TLS using the certificate SSLCertFile and the key SSLKeyFile.
+// The certificate is self-signed and generated by ssm-admin, unless one issued by another CA is imported.
+
+const (
+	// certValidity is validity period of generated certificates.
+	certValidity = 2 * 365 * 24 * time.Hour
+
+	KeyTypeRSA   = "rsa"
+	KeyTypeECDSA = "ecdsa"
+)
+
+// CertInfo describes the exporter certificate.
+type CertInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	SelfSigned  bool      `json:"self_signed"`
+	KeyType     string    `json:"key_type"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	DaysLeft    int       `json:"days_left"`
+	DNSNames    []string  `json:"dns_names"`
+	IPAddresses []string  `json:"ip_addresses"`
+	// Missing are client and bind addresses the certificate is not valid for.
+	Missing []string `json:"missing"`
+}
+
+// checkSSLCertificate check if SSL cert and key files exist and generate them if not.
+func (a *Admin) checkSSLCertificate() error {
+	if FileExists(SSLCertFile) && FileExists(SSLKeyFile) {
+		return nil
+	}
+
+	// Generate SSL cert and key.
+	return generateSSLCertificate(a.certHosts(), KeyTypeRSA, SSLCertFile, SSLKeyFile)
+}
+
+// certHosts returns addresses the certificate should be valid for: the client address
+// Prometheus scrapes and the bind address unless exporters listen on all interfaces.
+func (a *Admin) certHosts() []string {
+	var hosts []string
+	for _, host := range []string{a.Config.ClientAddress, a.Config.BindAddress} {
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		if !utils.SliceContains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// generateSSLCertificate generate self-signed SSL certificate for the hosts and its key and write them into the files.
+func generateSSLCertificate(hosts []string, keyType, certFile, keyFile string) error {
+	// Generate key.
+	var privKey crypto.Signer
+	var keyBlock *pem.Block
+	switch keyType {
+	case KeyTypeECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate private key: %s", err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to encode private key: %s", err)
+		}
+		privKey, keyBlock = key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case KeyTypeRSA, "":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return fmt.Errorf("failed to generate private key: %s", err)
+		}
+		privKey, keyBlock = key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	default:
+		return fmt.Errorf("unsupported key type %q, expected %s or %s.", keyType, KeyTypeRSA, KeyTypeECDSA)
+	}
+
+	// Generate cert.
+	// Start validity a bit earlier to tolerate clock skew between client and server.
+	notBefore := time.Now().Add(-time.Hour)
+	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	cert := x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"SSM Client"}},
+		SerialNumber:          serialNumber,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if keyType != KeyTypeECDSA {
+		cert.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		} else {
+			cert.DNSNames = append(cert.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		cert.Subject.CommonName = hosts[0]
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, &cert, &cert, privKey.Public(), privKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate: %s", err)
+	}
+
+	return writeKeyPair(certFile, keyFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), pem.EncodeToMemory(keyBlock))
+}
+
+// writeKeyPair replaces cert and key files, so exporters never read half-written files.
+func writeKeyPair(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{keyFile, keyPEM}, {certFile, certPEM}} {
+		tmp := f.name + ".tmp"
+		if err := ioutil.WriteFile(tmp, f.data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %s", f.name, err)
+		}
+		if err := os.Rename(tmp, f.name); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to write %s: %s", f.name, err)
+		}
+	}
+	return nil
+}
+
+// loadCertificate reads the exporter certificate.
+func loadCertificate(certFile, keyFile string) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// certKeyType returns key type of the certificate.
+func certKeyType(cert *x509.Certificate) string {
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return KeyTypeECDSA
+	case x509.RSA:
+		return KeyTypeRSA
+	default:
+		return strings.ToLower(cert.PublicKeyAlgorithm.String())
+	}
+}
+
+// isSelfSigned checks if the certificate is signed by its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// certInfo returns CertInfo of the certificate, checking it is valid for the hosts.
+func certInfo(cert *x509.Certificate, hosts []string) *CertInfo {
+	info := &CertInfo{
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		SelfSigned: isSelfSigned(cert),
+		KeyType:    certKeyType(cert),
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		DaysLeft:   int(time.Until(cert.NotAfter).Hours() / 24),
+		DNSNames:   cert.DNSNames,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			info.Missing = append(info.Missing, host)
+		}
+	}
+	return info
+}
+
+// CertInfo returns details of the exporter certificate.
+func (a *Admin) CertInfo() (*CertInfo, error) {
+	if !FileExists(SSLCertFile) {
+		return nil, fmt.Errorf("%s does not exist, it is generated with the first exporter using SSL.", SSLCertFile)
+	}
+	cert, err := loadCertificate(SSLCertFile, SSLKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return certInfo(cert, a.certHosts()), nil
+}
+
+// RenewCertificate generates a new self-signed certificate and key for the client and bind addresses
+// and restarts exporters using them. Empty keyType keeps the key type of the current certificate.
+// Certificates issued by another CA are not renewed, a new one should be imported instead.
+func (a *Admin) RenewCertificate(keyType string) (*CertInfo, []string, error) {
+	hosts := a.certHosts()
+	if FileExists(SSLCertFile) {
+		cert, err := loadCertificate(SSLCertFile, SSLKeyFile)
+		if err == nil {
+			if !isSelfSigned(cert) {
+				return nil, nil, fmt.Errorf("certificate is issued by %s, import a renewed one with 'ssm-admin cert import'.", cert.Issuer)
+			}
+			if keyType == "" {
+				keyType = certKeyType(cert)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("client address is not set, run 'ssm-admin config' first.")
+	}
+
+	if err := generateSSLCertificate(hosts, keyType, SSLCertFile, SSLKeyFile); err != nil {
+		return nil, nil, err
+	}
+	// CA of the previously imported certificate is not relevant anymore.
+	os.Remove(SSLCAFile)
+
+	info, err := a.CertInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	restarted, err := restartTLSExporters()
+	return info, restarted, err
+}
+
+// ImportCertificate replaces the exporter certificate and key with the given ones,
+// checking they match and, if caFile is set, that the certificate is issued by that CA.
+// The CA certificate is appended to the certificate file, so exporters serve the full chain.
+func (a *Admin) ImportCertificate(certFile, keyFile, caFile string) (*CertInfo, []string, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s and %s do not match: %s", certFile, keyFile, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse %s: %s", certFile, err)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, nil, fmt.Errorf("certificate %s expired on %s.", certFile, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	var caPEM []byte
+	if caFile != "" {
+		if caPEM, err = ioutil.ReadFile(caFile); err != nil {
+			return nil, nil, err
+		}
+		if err := verifyCertificate(pair, caPEM); err != nil {
+			return nil, nil, fmt.Errorf("certificate %s is not issued by %s: %s", certFile, caFile, err)
+		}
+		if !bytes.HasSuffix(certPEM, []byte("\n")) {
+			certPEM = append(certPEM, '\n')
+		}
+		certPEM = append(certPEM, caPEM...)
+	}
+
+	if err := writeKeyPair(SSLCertFile, SSLKeyFile, certPEM, keyPEM); err != nil {
+		return nil, nil, err
+	}
+	if caFile != "" {
+		if err := ioutil.WriteFile(SSLCAFile, caPEM, 0600); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		os.Remove(SSLCAFile)
+	}
+
+	restarted, err := restartTLSExporters()
+	return certInfo(cert, a.certHosts()), restarted, err
+}
+
+// verifyCertificate checks the leaf certificate of pair chains to CA bundle caPEM.
+// Intermediate certificates may come either with the leaf one or in the bundle.
+func verifyCertificate(pair tls.Certificate, caPEM []byte) error {
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificates found in CA file")
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range pair.Certificate[1:] {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// renewExpiringCertificate renews self-signed certificate expiring within certExpiryWarning.
+// It returns true if the certificate was renewed, exporters are not restarted.
+func (a *Admin) renewExpiringCertificate() (bool, error) {
+	if !FileExists(SSLCertFile) || !FileExists(SSLKeyFile) {
+		return false, nil
+	}
+	cert, err := loadCertificate(SSLCertFile, SSLKeyFile)
+	if err != nil {
+		return false, err
+	}
+	if !isSelfSigned(cert) || time.Until(cert.NotAfter) > certExpiryWarning {
+		return false, nil
+	}
+
+	// Keep names of the current certificate, config may be not available during package upgrade.
+	hosts := a.certHosts()
+	for _, name := range cert.DNSNames {
+		if !utils.SliceContains(hosts, name) {
+			hosts = append(hosts, name)
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if !utils.SliceContains(hosts, ip.String()) {
+			hosts = append(hosts, ip.String())
+		}
+	}
+	if err := generateSSLCertificate(hosts, certKeyType(cert), SSLCertFile, SSLKeyFile); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// restartTLSExporters restarts running exporters configured with the exporter certificate.
+func restartTLSExporters() ([]string, error) {
+	var restarted []string
+	for _, svc := range GetLocalServices() {
+		if _, ok := serviceExporters[svc.serviceType]; !ok {
+			continue
+		}
+		cfgFile, err := ini.Load(instanceConfigPath(svc.serviceType, svc.instance))
+		if err != nil || cfgFile.Section("web").Key("ssl-cert-file").Value() != SSLCertFile {
+			continue
+		}
+		name := instanceServiceName(svc.serviceType, svc.instance)
+		if !getServiceStatus(name) {
+			continue
+		}
+		if err := restartService(name); err != nil {
+			return restarted, err
+		}
+		restarted = append(restarted, name)
+	}
+	return restarted, nil
+}
+
+// PrintCertInfo prints details of the exporter certificate.
+func (a *Admin) PrintCertInfo(info *CertInfo) {
+	if a.Format != "" {
+		tmpl, err := templates.Parse(a.Format)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := tmpl.Execute(os.Stdout, info); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println()
+		return
+	}
+
+	red := color.New(color.FgRed, color.Bold).SprintFunc()
+	expiry := fmt.Sprintf("%s (%d days left)", info.NotAfter.Format(time.RFC3339), info.DaysLeft)
+	if time.Now().After(info.NotAfter) {
+		expiry = red(info.NotAfter.Format(time.RFC3339) + " (expired)")
+	} else if time.Until(info.NotAfter) < certExpiryWarning {
+		expiry = red(expiry)
+	}
+	names := append(append([]string{}, info.DNSNames...), info.IPAddresses...)
+	missing := "-"
+	if len(info.Missing) > 0 {
+		missing = red(strings.Join(info.Missing, ", "))
+	}
+	issuer := info.Issuer
+	if info.SelfSigned {
+		issuer = "self-signed"
+	}
+
+	linefmt := "%-15s | %s\n"
+	fmt.Printf(linefmt, "Certificate", SSLCertFile)
+	fmt.Printf(linefmt, "Subject", info.Subject)
+	fmt.Printf(linefmt, "Issuer", issuer)
+	fmt.Printf(linefmt, "Key type", info.KeyType)
+	fmt.Printf(linefmt, "Valid from", info.NotBefore.Format(time.RFC3339))
+	fmt.Printf(linefmt, "Expires", expiry)
+	fmt.Printf(linefmt, "SANs", strings.Join(names, ", "))
+	fmt.Printf(linefmt, "Not valid for", missing)
+}
@@ -0,0 +1,119 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertHosts(t *testing.T) {
+	a := &Admin{Config: &Config{ClientAddress: "10.0.0.1", BindAddress: "0.0.0.0"}}
+	assert.Equal(t, []string{"10.0.0.1"}, a.certHosts())
+
+	a.Config.BindAddress = "192.168.1.1"
+	assert.Equal(t, []string{"10.0.0.1", "192.168.1.1"}, a.certHosts())
+
+	a.Config.BindAddress = "10.0.0.1"
+	assert.Equal(t, []string{"10.0.0.1"}, a.certHosts())
+}
+
+func TestGenerateSSLCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeECDSA} {
+		t.Run(keyType, func(t *testing.T) {
+			require.NoError(t, generateSSLCertificate([]string{"10.0.0.1", "db01.example.com"}, keyType, certFile, keyFile))
+
+			cert, err := loadCertificate(certFile, keyFile)
+			require.NoError(t, err)
+			assert.True(t, isSelfSigned(cert))
+			assert.Equal(t, keyType, certKeyType(cert))
+			assert.True(t, cert.NotAfter.After(time.Now().Add(certValidity-2*time.Hour)))
+
+			info := certInfo(cert, []string{"10.0.0.1", "db01.example.com", "10.0.0.2"})
+			assert.Equal(t, []string{"db01.example.com"}, info.DNSNames)
+			assert.Equal(t, []string{"10.0.0.1"}, info.IPAddresses)
+			assert.Equal(t, []string{"10.0.0.2"}, info.Missing)
+		})
+	}
+
+	assert.Error(t, generateSSLCertificate(nil, "dsa", certFile, keyFile))
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "db01"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"db01"},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caCert, key.Public(), caKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+	assert.False(t, isSelfSigned(leaf))
+
+	pair := tls.Certificate{Certificate: [][]byte{leafDER}, PrivateKey: key}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	assert.NoError(t, verifyCertificate(pair, caPEM))
+
+	// Self-signed CA of another certificate does not verify it.
+	otherDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, leafTmpl, key.Public(), key)
+	require.NoError(t, err)
+	assert.Error(t, verifyCertificate(pair, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherDER})))
+	assert.Error(t, verifyCertificate(pair, []byte("garbage")))
+}
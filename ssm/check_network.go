@@ -19,220 +19,285 @@ package ssm
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"math"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/beevik/ntp"
+	"github.com/docker/cli/templates"
 	"github.com/fatih/color"
 	"golang.org/x/net/context"
 )
 
-const (
-	defaultNTPHost = "0.pool.ntp.org"
-)
+// NetworkStatus is the result of check-network.
+type NetworkStatus struct {
+	ServerAddress string      `json:"server_address"`
+	ClientAddress string      `json:"client_address"`
+	BindAddress   string      `json:"bind_address"`
+	Time          *TimeReport `json:"time"`
+	ConsulAPI     bool        `json:"consul_api"`
+	PrometheusAPI bool        `json:"prometheus_api"`
+	QANAPI        bool        `json:"qan_api"`
+	// Connection is the performance of a request to SSM server, nil if it failed.
+	Connection *ConnectionStats `json:"connection"`
+	// Monitored is false if this client is not registered on SSM server.
+	Monitored bool             `json:"monitored"`
+	Exporters []ExporterStatus `json:"exporters"`
+	// Endpoints are exporters as Prometheus sees them, empty if Prometheus is down.
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// ConnectionStats is the performance of a request to SSM server, durations are in seconds.
+type ConnectionStats struct {
+	Connect   float64 `json:"connect"`
+	Request   float64 `json:"request"`
+	RoundTrip float64 `json:"round_trip"`
+}
+
+// EndpointStatus is the state of exporter endpoint as SSM server sees it.
+type EndpointStatus struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Up       bool   `json:"up"`
+	// SSL and Password are nil if not known, e.g. exporter is not running.
+	SSL      *bool `json:"ssl"`
+	Password *bool `json:"password"`
+	// Reachable is false if the exporter works from the client side but Prometheus can not scrape it.
+	Reachable bool `json:"reachable"`
+}
 
 // CheckNetwork check connectivity between client and server.
-func (a *Admin) CheckNetwork(ntpHost string) error {
+// ntpHost is a comma separated list of NTP servers, maxDrift is allowed time drift, the config ones are used if not set.
+func (a *Admin) CheckNetwork(ntpHost string, maxDrift time.Duration) error {
+	if maxDrift < 0 {
+		return errors.New("Flag --max-time-drift can't be negative.")
+	}
+	s := a.networkStatus(ntpHost, maxDrift)
+	if a.Format != "" {
+		tmpl, err := templates.Parse(a.Format)
+		if err != nil {
+			return err
+		}
+		if err := tmpl.Execute(os.Stdout, s); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+	a.printNetworkStatus(s)
+	return nil
+}
+
+// networkStatus runs the checks of check-network.
+func (a *Admin) networkStatus(ntpHost string, maxDrift time.Duration) *NetworkStatus {
+	s := &NetworkStatus{
+		ServerAddress: a.Config.ServerAddress,
+		ClientAddress: a.Config.ClientAddress,
+		BindAddress:   a.Config.BindAddress,
+		// Consul is always alive if we are at this point.
+		ConsulAPI: true,
+		Exporters: []ExporterStatus{},
+		Endpoints: []EndpointStatus{},
+	}
+
 	// Check QAN API health.
-	qanStatus := false
 	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "ping")
 	if resp, _, err := a.qanAPI.Get(url); err == nil {
 		if resp.StatusCode == http.StatusOK && resp.Header.Get("X-Percona-Qan-Api-Version") != "" {
-			qanStatus = true
+			s.QANAPI = true
 		}
 	}
 
 	// Check Prometheus API by retrieving all "up" time series.
-	promStatus := true
+	s.PrometheusAPI = true
 	promData, err := a.promQueryAPI.Query(context.Background(), "up", time.Now())
 	if err != nil {
-		promStatus = false
+		s.PrometheusAPI = false
 	}
 
-	bindAddress := ""
-	if a.Config.ClientAddress != a.Config.BindAddress {
-		bindAddress = fmt.Sprintf("(%s)", a.Config.BindAddress)
-	}
-
-	fmt.Print("SSM Network Status\n\n")
-	fmt.Printf("%-14s | %s\n", "Server Address", a.Config.ServerAddress)
-	fmt.Printf("%-14s | %s %s\n\n", "Client Address", a.Config.ClientAddress, bindAddress)
-
-	t := a.getNginxHeader("X-Server-Time")
-	if t != "" {
-		timeFormat := "2006-01-02 15:04:05 -0700 MST"
-
-		// Real time (ntp server time)
-		if ntpHost == "" {
-			ntpHost = a.Config.NTPHost
-		}
-		if ntpHost == "" {
-			ntpHost = defaultNTPHost
-		}
-
-		var ntpResponse *ntp.Response
-		var ntpTimeErr error
-		// ntp.Time() has default timeout of 5s, which should be enough,
-		// but still ntp.Time() fails to get time too often.
-		// Let's try smaller timeouts (1s) but try several times (5 times * 1 second = default 5s).
-		for i := 0; i <= 5; i++ {
-			ntpResponse, ntpTimeErr = ntp.QueryWithOptions(ntpHost, ntp.QueryOptions{
-				Timeout: 1 * time.Second,
-			})
-			if ntpTimeErr == nil {
-				break
-			}
-			time.Sleep(1 * time.Second)
-		}
-		ntpTimeText := ""
-		if ntpTimeErr != nil {
-			ntpTimeText = fmt.Sprintf("unable to get ntp time: %s", ntpTimeErr)
-		} else {
-			ntpTimeText = ntpResponse.Time.Format(timeFormat)
-		}
-
-		// Server time
-		color.New(color.Bold).Println("* System Time")
-		var serverTime time.Time
-		if s, err := strconv.ParseInt(t, 10, 64); err == nil {
-			serverTime = time.Unix(s, 0)
+	// System time.
+	var serverTime time.Time
+	if t := a.getNginxHeader("X-Server-Time"); t != "" {
+		if sec, err := strconv.ParseInt(t, 10, 64); err == nil {
+			serverTime = time.Unix(sec, 0)
 		} else {
 			serverTime, _ = time.Parse("Monday, 02-Jan-2006 15:04:05 MST", t)
 		}
-
-		// Client Time
-		clientTime := time.Now()
-
-		// Print times
-		fmt.Printf("%-35s | %s\n", fmt.Sprintf("NTP Server (%s)", ntpHost), ntpTimeText)
-		fmt.Printf("%-35s | %s\n", "SSM Server", serverTime.Format(timeFormat))
-		fmt.Printf("%-35s | %s\n", "SSM Client", clientTime.Format(timeFormat))
-
-		allowedDriftTime := float64(60) // seconds
-		if ntpTimeErr == nil {
-			// Calculate time drift between NTP Server and SSM Server
-			drift := math.Abs(float64(serverTime.Unix()) - float64(ntpResponse.Time.Unix()))
-			fmt.Printf("%-35s | %s\n", "SSM Server Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", drift), drift <= allowedDriftTime))
-			if drift > allowedDriftTime {
-				fmt.Print("Time is out of sync. Please make sure the server time is correct to see the metrics.\n")
-			}
-			// Calculate time drift between NTP Server and SSM Client
-			drift = math.Abs(float64(clientTime.Unix()) - float64(ntpResponse.Time.Unix()))
-			fmt.Printf("%-35s | %s\n", "SSM Client Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", drift), drift <= allowedDriftTime))
-			if drift > allowedDriftTime {
-				fmt.Print("Time is out of sync. Please make sure the client time is correct to see the metrics.\n")
-			}
-		}
-
-		// Calculate time drift between server and client
-		drift := math.Abs(float64(serverTime.Unix()) - float64(clientTime.Unix()))
-		fmt.Printf("%-35s | %s\n", "SSM Client to SSM Server Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", drift), drift <= allowedDriftTime))
-		if drift > allowedDriftTime {
-			fmt.Print("Time is out of sync. Please make sure the server time is correct to see the metrics.\n")
-		}
 	}
+	if maxDrift == 0 {
+		maxDrift = a.Config.MaxTimeDrift
+	}
+	if maxDrift == 0 {
+		maxDrift = defaultMaxTimeDrift
+	}
+	s.Time = checkTime(ntpHosts(ntpHost, a.Config.NTPHost), serverTime, maxDrift)
 
-	fmt.Println()
-	color.New(color.Bold).Println("* Connection: Client --> Server")
-	fmt.Printf("%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
-	fmt.Printf("%-20s %-13s\n", "SERVER SERVICE", "STATUS")
-	fmt.Printf("%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
-	// Consul is always alive if we are at this point.
-	fmt.Printf("%-20s %-13s\n", "Consul API", colorStatus("OK", "", true))
-	fmt.Printf("%-20s %-13s\n", "Prometheus API", colorStatus("OK", "DOWN", promStatus))
-	fmt.Printf("%-20s %-13s\n\n", "Query Analytics API", colorStatus("OK", "DOWN", qanStatus))
-
-	a.testNetwork()
-	fmt.Println()
+	s.Connection = a.testNetwork()
 
 	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
 	if err != nil || node == nil {
-		fmt.Printf("%s '%s'.\n\n", noMonitoring, a.Config.ClientName)
-		return nil
+		return s
 	}
+	s.Monitored = true
 
-	if !promStatus {
-		fmt.Print("Prometheus is down. Please check if SSM server container runs properly.\n\n")
-		return nil
+	// Scrape exporters the way Prometheus does to tell a broken exporter
+	// from the server which can not reach this client.
+	scrapes := map[string]*ScrapeResult{}
+	for _, svc := range node.Services {
+		if !strings.HasSuffix(svc.Service, ":metrics") {
+			continue
+		}
+		row := ExporterStatus{Type: svc.Service, Name: "-"}
+		for _, tag := range svc.Tags {
+			if strings.HasPrefix(tag, "alias_") {
+				row.Name = tag[6:]
+			}
+		}
+		row.Running = getServiceStatus(fmt.Sprintf("ssm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)) || getServiceStatus(instanceServiceName(svc.Service, serviceInstance(svc.ID)))
+		if row.Running {
+			row.ScrapeResult = a.scrapeExporter(svc)
+			scrapes[svc.ID] = row.ScrapeResult
+		}
+		s.Exporters = append(s.Exporters, row)
 	}
+	sort.Slice(s.Exporters, func(i, j int) bool {
+		if s.Exporters[i].Type != s.Exporters[j].Type {
+			return s.Exporters[i].Type < s.Exporters[j].Type
+		}
+		return s.Exporters[i].Name < s.Exporters[j].Name
+	})
 
-	fmt.Println()
-	color.New(color.Bold).Println("* Connection: Client <-- Server")
-	if len(node.Services) == 0 {
-		fmt.Print("No metric endpoints registered.\n\n")
-		return nil
+	if !s.PrometheusAPI {
+		return s
 	}
 
 	// Check Prometheus endpoint status.
-	svcTable := []ServiceStatus{}
-	errStatus := false
 	for _, svc := range node.Services {
 		if !strings.HasSuffix(svc.Service, ":metrics") {
 			continue
 		}
 
-		name := "-"
+		row := EndpointStatus{Type: svc.Service, Name: "-", Reachable: true}
 		for _, tag := range svc.Tags {
 			if strings.HasPrefix(tag, "alias_") {
-				name = tag[6:]
+				row.Name = tag[6:]
 				continue
 			}
 
 		}
+		if a.Config.ClientAddress != a.Config.BindAddress {
+			row.Endpoint = fmt.Sprintf("%s-->%s:%d", a.Config.ClientAddress, a.Config.BindAddress, svc.Port)
+		} else {
+			row.Endpoint = fmt.Sprintf("%s:%d", a.Config.ClientAddress, svc.Port)
+		}
 
-		running := checkPromTargetStatus(promData.String(), name, strings.Split(svc.Service, ":")[0])
-		if !running {
-			errStatus = true
+		row.Up = checkPromTargetStatus(promData.String(), row.Name, strings.Split(svc.Service, ":")[0])
+		res, localStatus := scrapes[svc.ID]
+		if !row.Up && localStatus && res.Error == "" {
+			row.Reachable = false
 		}
 
 		// Check protection status.
-		localStatus := getServiceStatus(fmt.Sprintf("ssm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)) || getServiceStatus(serviceName(svc.Service))
-		sslVal := "-"
-		protectedVal := "-"
 		if localStatus {
-			sslVal = colorStatus("YES", "NO", a.isSSLProtected(svc.Service, svc.Port))
+			ssl := a.isSSLProtected(svc.Service, svc.Port)
+			row.SSL = &ssl
 			if a.Config.ServerUser != "" {
-				protectedVal = colorStatus("YES", "NO", a.isPasswordProtected(svc.Service, svc.Port))
+				protected := a.isPasswordProtected(svc.Service, svc.Port)
+				row.Password = &protected
 			}
 		}
-
-		row := ServiceStatus{
-			Type:     svc.Service,
-			Name:     name,
-			Port:     fmt.Sprintf("%d", svc.Port),
-			Running:  running,
-			SSL:      sslVal,
-			Password: protectedVal,
+		s.Endpoints = append(s.Endpoints, row)
+	}
+	sort.Slice(s.Endpoints, func(i, j int) bool {
+		if s.Endpoints[i].Endpoint != s.Endpoints[j].Endpoint {
+			return s.Endpoints[i].Endpoint < s.Endpoints[j].Endpoint
 		}
-		svcTable = append(svcTable, row)
+		return s.Endpoints[i].Type < s.Endpoints[j].Type
+	})
+	return s
+}
+
+// printNetworkStatus prints the result of check-network.
+func (a *Admin) printNetworkStatus(s *NetworkStatus) {
+	bindAddress := ""
+	if s.ClientAddress != s.BindAddress {
+		bindAddress = fmt.Sprintf("(%s)", s.BindAddress)
+	}
+
+	fmt.Print("SSM Network Status\n\n")
+	fmt.Printf("%-14s | %s\n", "Server Address", s.ServerAddress)
+	fmt.Printf("%-14s | %s %s\n\n", "Client Address", s.ClientAddress, bindAddress)
+
+	printTimeReport(s.Time)
+
+	fmt.Println()
+	color.New(color.Bold).Println("* Connection: Client --> Server")
+	fmt.Printf("%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
+	fmt.Printf("%-20s %-13s\n", "SERVER SERVICE", "STATUS")
+	fmt.Printf("%-20s %-13s\n", strings.Repeat("-", 20), strings.Repeat("-", 7))
+	fmt.Printf("%-20s %-13s\n", "Consul API", colorStatus("OK", "", s.ConsulAPI))
+	fmt.Printf("%-20s %-13s\n", "Prometheus API", colorStatus("OK", "DOWN", s.PrometheusAPI))
+	fmt.Printf("%-20s %-13s\n\n", "Query Analytics API", colorStatus("OK", "DOWN", s.QANAPI))
+
+	if c := s.Connection; c != nil {
+		fmt.Printf("%-19s | %v\n", "Connection duration", secondsToDuration(c.Connect))
+		fmt.Printf("%-19s | %v\n", "Request duration", secondsToDuration(c.Request))
+		fmt.Printf("%-19s | %v\n", "Full round trip", secondsToDuration(c.RoundTrip))
+	} else {
+		fmt.Println("Unable to measure the connection performance.")
+	}
+	fmt.Println()
+
+	if !s.Monitored {
+		fmt.Printf("%s '%s'.\n\n", noMonitoring, a.Config.ClientName)
+		return
+	}
+
+	if len(s.Exporters) > 0 {
+		color.New(color.Bold).Println("* Exporters: Client --> Exporter")
+		printExporterStatus(s.Exporters)
+		fmt.Println()
+	}
+
+	if !s.PrometheusAPI {
+		fmt.Print("Prometheus is down. Please check if SSM server container runs properly.\n\n")
+		return
+	}
+
+	fmt.Println()
+	color.New(color.Bold).Println("* Connection: Client <-- Server")
+	if len(s.Endpoints) == 0 {
+		fmt.Print("No metric endpoints registered.\n\n")
+		return
 	}
 
 	maxTypeLen := len("SERVICE TYPE")
 	maxNameLen := len("NAME")
-	for _, in := range svcTable {
+	maxAddrLen := len("REMOTE ENDPOINT")
+	for _, in := range s.Endpoints {
 		if len(in.Type) > maxTypeLen {
 			maxTypeLen = len(in.Type)
 		}
 		if len(in.Name) > maxNameLen {
 			maxNameLen = len(in.Name)
 		}
+		if len(in.Endpoint) > maxAddrLen {
+			maxAddrLen = len(in.Endpoint)
+		}
 	}
 	maxTypeLen++
 	maxNameLen++
-	maxAddrLen := len(a.Config.ClientAddress) + 7
+	maxAddrLen++
 	maxStatusLen := 7
 	maxProtectedLen := 9
 	maxSSLLen := 10
-	if a.Config.ClientAddress != a.Config.BindAddress {
-		maxAddrLen = len(a.Config.ClientAddress) + len(a.Config.BindAddress) + 10
-	}
 
 	fmtPattern := "%%-%ds %%-%ds %%-%ds %%-%ds %%-%ds %%-%ds\n"
 	linefmt := fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, maxSSLLen, maxProtectedLen)
@@ -243,23 +308,35 @@ func (a *Admin) CheckNetwork(ntpHost string) error {
 	fmt.Printf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", maxAddrLen),
 		strings.Repeat("-", maxStatusLen), strings.Repeat("-", maxSSLLen), strings.Repeat("-", maxProtectedLen))
 
-	sort.Sort(sortOutput(svcTable))
+	errStatus := false
+	unreachable := false
 	maxStatusLen += 11
 	linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, maxSSLLen, maxProtectedLen)
-	for _, i := range svcTable {
-		if i.SSL != "-" {
+	for _, i := range s.Endpoints {
+		if !i.Up {
+			errStatus = true
+		}
+		if !i.Reachable {
+			unreachable = true
+		}
+		sslVal := "-"
+		if i.SSL != nil {
+			sslVal = colorStatus("YES", "NO", *i.SSL)
 			linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, maxAddrLen, maxStatusLen, maxSSLLen+11, maxProtectedLen)
 		}
-		if a.Config.ClientAddress != a.Config.BindAddress {
-			fmt.Printf(linefmt, i.Type, i.Name, a.Config.ClientAddress+"-->"+a.Config.BindAddress+":"+i.Port,
-				colorStatus("OK", "DOWN", i.Running), i.SSL, i.Password)
-		} else {
-			fmt.Printf(linefmt, i.Type, i.Name, a.Config.ClientAddress+":"+i.Port,
-				colorStatus("OK", "DOWN", i.Running), i.SSL, i.Password)
+		protectedVal := "-"
+		if i.Password != nil {
+			protectedVal = colorStatus("YES", "NO", *i.Password)
 		}
-
+		fmt.Printf(linefmt, i.Type, i.Name, i.Endpoint, colorStatus("OK", "DOWN", i.Up), sslVal, protectedVal)
 	}
 
+	if unreachable {
+		fmt.Print(`
+Some endpoints are down for Prometheus but exporters serve metrics when scraped from this system,
+so SSM server can not reach this client: check the firewall, NAT and the client address.
+`)
+	}
 	if errStatus {
 		scheme := "http"
 		if a.Config.ServerInsecureSSL || a.Config.ServerSSL {
@@ -281,14 +358,13 @@ IMPORTANT: client and bind addresses are not the same which means you need to co
 		}
 	}
 	fmt.Println()
-	return nil
 }
 
 // testNetwork measure round trip duration of server connection.
-func (a *Admin) testNetwork() {
-	insecureFlag := false
-	if a.Config.ServerInsecureSSL {
-		insecureFlag = true
+func (a *Admin) testNetwork() *ConnectionStats {
+	transport, err := a.Config.serverTransport()
+	if err != nil {
+		return nil
 	}
 
 	conn := &networkTransport{
@@ -297,23 +373,29 @@ func (a *Admin) testNetwork() {
 			KeepAlive: a.apiTimeout,
 		},
 	}
-	conn.rtp = &http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
-		Dial:            conn.dial,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureFlag},
-	}
+	// Same proxy and TLS as other requests, but dialing is measured.
+	transport.DialContext = nil
+	transport.Dial = conn.dial
+	conn.rtp = transport
 	client := &http.Client{Transport: conn}
 
 	resp, err := client.Get(a.serverURL)
 	if err != nil {
-		fmt.Println("Unable to measure the connection performance.")
-		return
+		return nil
 	}
 	defer resp.Body.Close()
 
-	fmt.Printf("%-19s | %v\n", "Connection duration", conn.connEnd.Sub(conn.connStart))
-	fmt.Printf("%-19s | %v\n", "Request duration", conn.reqEnd.Sub(conn.reqStart)-conn.connEnd.Sub(conn.connStart))
-	fmt.Printf("%-19s | %v\n", "Full round trip", conn.reqEnd.Sub(conn.reqStart))
+	connect := conn.connEnd.Sub(conn.connStart)
+	roundTrip := conn.reqEnd.Sub(conn.reqStart)
+	return &ConnectionStats{
+		Connect:   connect.Seconds(),
+		Request:   (roundTrip - connect).Seconds(),
+		RoundTrip: roundTrip.Seconds(),
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
 
 type networkTransport struct {
@@ -361,7 +443,7 @@ func (a *Admin) isPasswordProtected(svcType string, port int) bool {
 	if a.isSSLProtected(svcType, port) {
 		scheme = "https"
 		// Enforce InsecureSkipVerify true to bypass err and check http code.
-		api = NewAPI(true, apiTimeout, a.Verbose)
+		api = NewAPI(&tls.Config{InsecureSkipVerify: true}, apiTimeout, a.Verbose)
 	}
 	url := api.URL(fmt.Sprintf("%s://%s:%d", scheme, a.Config.BindAddress, port), urlPath)
 	if resp, _, err := api.Get(url); err == nil && resp.StatusCode == http.StatusUnauthorized {
@@ -18,6 +18,8 @@
 package ssm
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,35 +28,47 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"syscall"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
-	"github.com/shatteredsilicon/ssm/proto"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
 	protocfg "github.com/shatteredsilicon/ssm/proto/config"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	relabelKeyTpl = "%s/relabel"
+	// serviceRelabelKeyTpl is relabel key of the service, client name and service ID don't change on rename.
+	serviceRelabelKeyTpl = "%s/%s/relabel"
 )
 
 // Config ssm.yml config file.
 type Config struct {
-	ServerAddress     string    `yaml:"server_address"`
-	ClientAddress     string    `yaml:"client_address"`
-	BindAddress       string    `yaml:"bind_address"`
-	ClientName        string    `yaml:"client_name"`
-	MySQLPassword     string    `yaml:"mysql_password,omitempty"`
-	ServerUser        string    `yaml:"server_user,omitempty"`
-	ServerPassword    string    `yaml:"server_password,omitempty"`
-	ServerSSL         bool      `yaml:"server_ssl,omitempty"`
-	ServerInsecureSSL bool      `yaml:"server_insecure_ssl,omitempty"`
-	ManagedAPIPath    string    `yaml:"managed_api_path"`
-	NTPHost           string    `yaml:"ntp_host,omitempty"`
-	CTime             time.Time `yaml:"-"` // read from ctime
+	ServerAddress     string        `yaml:"server_address"`
+	ClientAddress     string        `yaml:"client_address"`
+	BindAddress       string        `yaml:"bind_address"`
+	ClientName        string        `yaml:"client_name"`
+	MySQLPassword     string        `yaml:"mysql_password,omitempty"`
+	ServerUser        string        `yaml:"server_user,omitempty"`
+	ServerPassword    string        `yaml:"server_password,omitempty"`
+	ServerSSL         bool          `yaml:"server_ssl,omitempty"`
+	ServerInsecureSSL bool          `yaml:"server_insecure_ssl,omitempty"`
+	ServerCAFile      string        `yaml:"server_ca_file,omitempty"`
+	ServerClientCert  string        `yaml:"server_client_cert,omitempty"`
+	ServerClientKey   string        `yaml:"server_client_key,omitempty"`
+	ProxyURL          string        `yaml:"proxy_url,omitempty"`
+	ConnectTimeout    time.Duration `yaml:"connect_timeout,omitempty"`
+	ReadTimeout       time.Duration `yaml:"read_timeout,omitempty"`
+	ManagedAPIPath    string        `yaml:"managed_api_path"`
+	NTPHost           string        `yaml:"ntp_host,omitempty"`
+	MaxTimeDrift      time.Duration `yaml:"max_time_drift,omitempty"`
+	ExporterPortRange string        `yaml:"exporter_port_range,omitempty"`
+	EncryptSecrets    bool          `yaml:"encrypt_secrets,omitempty"`
+	CTime             time.Time     `yaml:"-"` // read from ctime
 }
 
 // LoadConfig read SSM client config file.
@@ -70,6 +84,9 @@ func (a *Admin) LoadConfig() error {
 	if err := yaml.Unmarshal(bytes, a.Config); err != nil {
 		return err
 	}
+	if err := a.decryptConfig(); err != nil {
+		return err
+	}
 	if fh, err := os.Stat(ConfigFile); err != nil {
 		return err
 	} else {
@@ -95,12 +112,21 @@ func (a *Admin) SetConfig(cf Config, flagForce bool) error {
 	if cf.ServerSSL && cf.ServerInsecureSSL {
 		return errors.New("Flags --server-ssl and --server-insecure-ssl are mutually exclusive.")
 	}
+	if cf.ServerCAFile != "" && cf.ServerInsecureSSL {
+		return errors.New("Flags --server-ca-file and --server-insecure-ssl are mutually exclusive.")
+	}
+	if (cf.ServerClientCert == "") != (cf.ServerClientKey == "") {
+		return errors.New("Flags --server-client-cert and --server-client-key should be used together.")
+	}
 
 	if cf.ServerAddress != "" {
 		a.Config.ServerAddress = cf.ServerAddress
 		// Resetting server address clears up SSL and HTTP auth.
 		a.Config.ServerSSL = false
 		a.Config.ServerInsecureSSL = false
+		a.Config.ServerCAFile = ""
+		a.Config.ServerClientCert = ""
+		a.Config.ServerClientKey = ""
 		a.Config.ServerUser = ""
 		a.Config.ServerPassword = ""
 	}
@@ -119,11 +145,60 @@ func (a *Admin) SetConfig(cf Config, flagForce bool) error {
 	if cf.ServerInsecureSSL {
 		a.Config.ServerSSL = false
 		a.Config.ServerInsecureSSL = true
+		a.Config.ServerCAFile = ""
+	}
+	// Custom CA and client certificate imply SSL, files are referenced by services running elsewhere.
+	if cf.ServerCAFile != "" {
+		path, err := filepath.Abs(cf.ServerCAFile)
+		if err != nil {
+			return err
+		}
+		a.Config.ServerCAFile = path
+		a.Config.ServerSSL = true
+		a.Config.ServerInsecureSSL = false
+	}
+	if cf.ServerClientCert != "" {
+		cert, err1 := filepath.Abs(cf.ServerClientCert)
+		key, err2 := filepath.Abs(cf.ServerClientKey)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid client certificate path: %v %v", err1, err2)
+		}
+		a.Config.ServerClientCert, a.Config.ServerClientKey = cert, key
+		if !a.Config.ServerInsecureSSL {
+			a.Config.ServerSSL = true
+		}
 	}
 
 	if cf.NTPHost != "" {
 		a.Config.NTPHost = cf.NTPHost
 	}
+	if cf.MaxTimeDrift < 0 {
+		return errors.New("Flag --max-time-drift can't be negative.")
+	}
+	if cf.MaxTimeDrift != 0 {
+		a.Config.MaxTimeDrift = cf.MaxTimeDrift
+	}
+
+	// Transport options.
+	if cf.ConnectTimeout < 0 || cf.ReadTimeout < 0 {
+		return errors.New("Flags --connect-timeout and --read-timeout can't be negative.")
+	}
+	if cf.ProxyURL != "" {
+		a.Config.ProxyURL = cf.ProxyURL
+	}
+	if cf.ConnectTimeout != 0 {
+		a.Config.ConnectTimeout = cf.ConnectTimeout
+	}
+	if cf.ReadTimeout != 0 {
+		a.Config.ReadTimeout = cf.ReadTimeout
+	}
+
+	if cf.ExporterPortRange != "" {
+		if _, _, err := parsePortRange(cf.ExporterPortRange); err != nil {
+			return err
+		}
+		a.Config.ExporterPortRange = cf.ExporterPortRange
+	}
 
 	// Set APIs and check if server is alive.
 	if err := a.SetAPI(); err != nil {
@@ -299,6 +374,10 @@ What ports to map you can find from "ssm-admin check-network" output once you ad
 		if _, err := a.StartStopMonitoring("restart", "mongodb:queries"); err != nil && err != ErrNoService {
 			return fmt.Errorf("Unable to restart queries service for MongoDB: %s", err)
 		}
+		// Restart QAN agent for PostgreSQL.
+		if _, err := a.StartStopMonitoring("restart", "postgresql:queries"); err != nil && err != ErrNoService {
+			return fmt.Errorf("Unable to restart queries service for PostgreSQL: %s", err)
+		}
 	}
 
 	// Write the config.
@@ -320,7 +399,11 @@ What ports to map you can find from "ssm-admin check-network" output once you ad
 
 // writeConfig write config to the file.
 func (a *Admin) writeConfig() error {
-	bytes, _ := yaml.Marshal(a.Config)
+	c, err := a.encryptedConfig()
+	if err != nil {
+		return err
+	}
+	bytes, _ := yaml.Marshal(c)
 	return ioutil.WriteFile(ConfigFile, bytes, 0600)
 }
 
@@ -329,6 +412,80 @@ func (a *Admin) removeConfig() error {
 	return os.Remove(ConfigFile)
 }
 
+// serverTLSConfig returns TLS config for connections to SSM server, nil if SSL is off.
+func (c *Config) serverTLSConfig() (*tls.Config, error) {
+	if !c.ServerSSL && !c.ServerInsecureSSL {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: c.ServerInsecureSSL}
+	if c.ServerCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.ServerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read server CA file: %s", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in server CA file %s.", c.ServerCAFile)
+		}
+	}
+	if c.ServerClientCert != "" || c.ServerClientKey != "" {
+		pair, err := tls.LoadX509KeyPair(c.ServerClientCert, c.ServerClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load server client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// serverScheme returns URL scheme of SSM server and the config flag enabling it for help texts.
+func (c *Config) serverScheme() (scheme, flag string) {
+	switch {
+	case c.ServerSSL:
+		return "https", "--server-ssl"
+	case c.ServerInsecureSSL:
+		return "https", "--server-insecure-ssl"
+	}
+	return "http", ""
+}
+
+// serverTransport returns HTTP transport to SSM server shared by Consul, QAN, managed and Prometheus clients.
+func (c *Config) serverTransport() (*http.Transport, error) {
+	tlsConfig, err := c.serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewTransport(utils.TransportConfig{
+		TLSConfig:      tlsConfig,
+		ProxyURL:       c.ProxyURL,
+		ConnectTimeout: c.ConnectTimeout,
+		ReadTimeout:    c.ReadTimeout,
+	})
+}
+
+// QANUnsupportedTLS returns TLS options set which qan-agent config has no counterpart for yet,
+// Query Analytics data is not sent if SSM server can't be reached without them.
+func (c *Config) QANUnsupportedTLS() []string {
+	var opts []string
+	if c.ServerCAFile != "" {
+		opts = append(opts, "server_ca_file")
+	}
+	if c.ServerClientCert != "" {
+		opts = append(opts, "server_client_cert")
+	}
+	return opts
+}
+
+// QANTLSWarning returns warning about TLS options qan-agent does not use, empty if there are none.
+func (c *Config) QANTLSWarning() string {
+	opts := c.QANUnsupportedTLS()
+	if len(opts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("qan-agent does not support %s yet, Query Analytics data is not sent if SSM server requires them",
+		strings.Join(opts, ", "))
+}
+
 // syncAgentConfig sync agent config.
 func (a *Admin) syncAgentConfig(agentConfigFile string) error {
 	jsonData, err := ioutil.ReadFile(agentConfigFile)
@@ -465,13 +622,8 @@ func (a *Admin) deregisterNode(name string) error {
 
 // renameInstance renames instance with given uuid
 func (a *Admin) renameInstance(instanceUUID, oldName, newName string) error {
-	bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instanceUUID))
-	if err != nil {
-		return err
-	}
-
-	instance := proto.Instance{}
-	err = json.Unmarshal(bytes, &instance)
+	file := fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instanceUUID)
+	instance, err := a.readInstanceFile(file)
 	if err != nil {
 		return err
 	}
@@ -491,7 +643,7 @@ func (a *Admin) renameInstance(instanceUUID, oldName, newName string) error {
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instanceUUID), newBytes, 0600); err != nil {
+	if err := a.writeInstanceFile(file, instance); err != nil {
 		return err
 	}
 
@@ -519,12 +671,25 @@ type relabelConsulValue struct {
 }
 
 func (a *Admin) addRelabelKV(newName, oldName string) error {
-	data, _, err := a.consulAPI.KV().Get(fmt.Sprintf(relabelKeyTpl, oldName), nil)
+	return a.putRelabelKV(fmt.Sprintf(relabelKeyTpl, oldName), fmt.Sprintf(relabelKeyTpl, newName), oldName, a.Config.CTime.UnixMilli())
+}
+
+// addServiceRelabelKV lets SSM server relabel metrics of the service collected under the old name.
+// Metrics since the previous rename of the service are relabeled, all of them if it was never renamed.
+func (a *Admin) addServiceRelabelKV(svcID, oldName string) error {
+	key := fmt.Sprintf(serviceRelabelKeyTpl, a.Config.ClientName, svcID)
+	return a.putRelabelKV(key, key, oldName, 0)
+}
+
+// putRelabelKV reads relabel values from oldKey, appends the window of the old name
+// from startTime or the end of the last window till now and writes them to newKey.
+func (a *Admin) putRelabelKV(oldKey, newKey, oldName string, startTime int64) error {
+	data, _, err := a.consulAPI.KV().Get(oldKey, nil)
 	if err != nil {
 		return err
 	}
 
-	startTime, endTime := a.Config.CTime.UnixMilli(), time.Now().UnixMilli()
+	endTime := time.Now().UnixMilli()
 	var relabelValues []relabelConsulValue
 	if data != nil {
 		if err = json.Unmarshal(data.Value, &relabelValues); err != nil {
@@ -532,7 +697,7 @@ func (a *Admin) addRelabelKV(newName, oldName string) error {
 		}
 
 		for i := range relabelValues {
-			if relabelValues[i].End > int64(startTime) {
+			if relabelValues[i].End > startTime {
 				startTime = relabelValues[i].End
 			}
 		}
@@ -546,7 +711,7 @@ func (a *Admin) addRelabelKV(newName, oldName string) error {
 	b, _ := json.Marshal(relabelValues)
 
 	d := &consul.KVPair{
-		Key:   fmt.Sprintf(relabelKeyTpl, newName),
+		Key:   newKey,
 		Value: b,
 	}
 	_, err = a.consulAPI.KV().Put(d, nil)
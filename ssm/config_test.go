@@ -18,9 +18,14 @@
 package ssm
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsAddressLocal(t *testing.T) {
@@ -36,3 +41,64 @@ func TestIsAddressLocal(t *testing.T) {
 		assert.Equal(t, expected, isAddressLocal(ip), "ip = %s", ip)
 	}
 }
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.NoError(t, generateSSLCertificate([]string{"db01"}, KeyTypeECDSA, certFile, keyFile))
+
+	cfg, err := (&Config{}).serverTLSConfig()
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = (&Config{ServerInsecureSSL: true}).serverTLSConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.InsecureSkipVerify)
+	assert.Nil(t, cfg.RootCAs)
+
+	c := &Config{ServerSSL: true, ServerCAFile: certFile, ServerClientCert: certFile, ServerClientKey: keyFile}
+	cfg, err = c.serverTLSConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.InsecureSkipVerify)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Len(t, cfg.Certificates, 1)
+
+	_, err = (&Config{ServerSSL: true, ServerCAFile: keyFile}).serverTLSConfig()
+	assert.Error(t, err)
+	_, err = (&Config{ServerSSL: true, ServerClientCert: certFile}).serverTLSConfig()
+	assert.Error(t, err)
+}
+
+func TestSyncAgentConfigTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	agentConfigFile := filepath.Join(dir, "agent.conf")
+	require.NoError(t, ioutil.WriteFile(agentConfigFile, []byte(`{"UUID":"abc","ApiPath":"qan-api"}`), 0600))
+
+	a := &Admin{Config: &Config{
+		ServerAddress:    "ssm.example.com",
+		ServerSSL:        true,
+		ServerCAFile:     "/etc/pki/ca.crt",
+		ServerClientCert: "/etc/pki/db01.crt",
+		ServerClientKey:  "/etc/pki/db01.key",
+	}}
+	require.NoError(t, a.syncAgentConfig(agentConfigFile))
+
+	data, err := ioutil.ReadFile(agentConfigFile)
+	require.NoError(t, err)
+	conf := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &conf))
+	assert.Equal(t, "abc", conf["UUID"])
+	assert.Equal(t, "ssm.example.com", conf["ApiHostname"])
+	assert.Equal(t, true, conf["ServerSSL"])
+	// qan-agent has no such options, they are detected instead.
+	assert.NotContains(t, conf, "ServerCAFile")
+	assert.NotContains(t, conf, "ServerClientCert")
+	assert.Equal(t, []string{"server_ca_file", "server_client_cert"}, a.Config.QANUnsupportedTLS())
+	assert.Empty(t, (&Config{ServerSSL: true}).QANUnsupportedTLS())
+	assert.Contains(t, a.Config.QANTLSWarning(), "server_ca_file, server_client_cert")
+	assert.Empty(t, (&Config{ServerSSL: true}).QANTLSWarning())
+}
@@ -32,6 +32,8 @@ const (
 	noMonitoring       = "No monitoring registered for this node identified as"
 	apiTimeout         = 10 * time.Second
 	NameRegex          = `^[-\w:\.]{2,60}$`
+
+	defaultExporterPortRange = "42000-42999"
 )
 
 var (
@@ -50,6 +52,10 @@ var (
 	ConfigFile  = fmt.Sprintf("%s/ssm.yml", SSMBaseDir)
 	SSLCertFile = fmt.Sprintf("%s/server.crt", SSMBaseDir)
 	SSLKeyFile  = fmt.Sprintf("%s/server.key", SSMBaseDir)
+	SSLCAFile   = fmt.Sprintf("%s/ca.crt", SSMBaseDir)
+	PortsFile   = fmt.Sprintf("%s/ports.yml", SSMBaseDir)
+
+	SecretKeyFile = fmt.Sprintf("%s/ssm.key", SSMBaseDir)
 
 	ErrDuplicate  = errors.New("there is already one instance with this name under monitoring.")
 	ErrNoService  = errors.New("no service found.")
@@ -65,6 +71,15 @@ var (
 		plugin.ProxySQLExporter,
 	}
 
+	// serviceExporters maps metrics service types to their exporters.
+	serviceExporters = map[string]string{
+		plugin.LinuxMetrics:      plugin.NodeExporter,
+		plugin.MySQLMetrics:      plugin.MySQLExporter,
+		plugin.MongoDBMetrics:    plugin.MongoDBExporter,
+		plugin.PostgreSQLMetrics: plugin.PostgreSQLExporter,
+		plugin.ProxySQLMetrics:   plugin.ProxySQLExporter,
+	}
+
 	offlineActions = []string{"stop", "disable"}
 )
 
@@ -0,0 +1,421 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/cli/templates"
+	"github.com/fatih/color"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
+	"github.com/shatteredsilicon/ssm/proto"
+	"gopkg.in/ini.v1"
+)
+
+// Doctor check statuses.
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// certExpiryWarning is how long before expiration the certificate is reported.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// Check is a result of a single doctor check.
+type Check struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+}
+
+// DoctorReport is a result of all doctor checks.
+type DoctorReport struct {
+	Checks []Check `json:"checks"`
+	Pass   int     `json:"pass"`
+	Warn   int     `json:"warn"`
+	Fail   int     `json:"fail"`
+}
+
+func (r *DoctorReport) add(category, name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{
+		Category: category,
+		Name:     name,
+		Status:   status,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	switch status {
+	case CheckPass:
+		r.Pass++
+	case CheckWarn:
+		r.Warn++
+	case CheckFail:
+		r.Fail++
+	}
+}
+
+// ExitCode returns 2 if any check failed, 1 if there are warnings only, and 0 otherwise.
+func (r *DoctorReport) ExitCode() int {
+	switch {
+	case r.Fail > 0:
+		return 2
+	case r.Warn > 0:
+		return 1
+	}
+	return 0
+}
+
+// Doctor runs self-diagnosis of SSM client. It works without server connection,
+// checks requiring SSM server are reported as failed then.
+func (a *Admin) Doctor() *DoctorReport {
+	r := &DoctorReport{}
+	a.doctorConfig(r)
+	a.doctorBinaries(r)
+	a.doctorExporterConfigs(r)
+	a.doctorCertificate(r)
+
+	if err := a.SetAPI(); err != nil {
+		r.add("server", "connection", CheckFail, "%s", strings.TrimSpace(err.Error()))
+		return r
+	}
+	r.add("server", "connection", CheckPass, "SSM server %s is reachable", a.Config.ServerAddress)
+
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		r.add("server", "consul", CheckFail, "Unable to communicate with Consul: %s", err)
+		return r
+	}
+	var services []*consul.AgentService
+	if node != nil {
+		for _, svc := range node.Services {
+			if svc.Service != "consul" {
+				services = append(services, svc)
+			}
+		}
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+
+	a.doctorServices(r, services)
+	a.doctorQAN(r, services)
+	return r
+}
+
+func (a *Admin) doctorConfig(r *DoctorReport) {
+	if !FileExists(ConfigFile) {
+		r.add("config", "ssm.yml", CheckFail, "%s is missing, run 'ssm-admin config'", ConfigFile)
+	}
+	c := a.Config
+	switch {
+	case c.ServerAddress == "":
+		r.add("config", "server_address", CheckFail, "server_address is not set, run 'ssm-admin config --server'")
+	case strings.Contains(c.ServerAddress, "/"):
+		r.add("config", "server_address", CheckFail, "server_address %q should be host or host:port", c.ServerAddress)
+	default:
+		r.add("config", "server_address", CheckPass, "%s", c.ServerAddress)
+	}
+
+	if match, _ := regexp.MatchString(NameRegex, c.ClientName); !match {
+		r.add("config", "client_name", CheckFail, "client_name %q must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :", c.ClientName)
+	} else {
+		r.add("config", "client_name", CheckPass, "%s", c.ClientName)
+	}
+
+	if c.ClientAddress == "" {
+		r.add("config", "client_address", CheckFail, "client_address is not set")
+	} else {
+		r.add("config", "client_address", CheckPass, "%s", c.ClientAddress)
+	}
+
+	switch {
+	case c.BindAddress == "":
+		r.add("config", "bind_address", CheckFail, "bind_address is not set")
+	case !isAddressLocal(c.BindAddress):
+		r.add("config", "bind_address", CheckFail, "bind_address %s is not locally bound", c.BindAddress)
+	default:
+		r.add("config", "bind_address", CheckPass, "%s", c.BindAddress)
+	}
+
+	if c.ServerSSL && c.ServerInsecureSSL {
+		r.add("config", "server_ssl", CheckFail, "server_ssl and server_insecure_ssl are mutually exclusive")
+	}
+	if _, err := c.serverTLSConfig(); err != nil {
+		r.add("config", "server_tls", CheckFail, "%s", err)
+	}
+	if msg := c.QANTLSWarning(); msg != "" {
+		r.add("config", "server_tls", CheckWarn, "%s", msg)
+	}
+	if c.ProxyURL != "" {
+		if _, err := utils.NewTransport(utils.TransportConfig{ProxyURL: c.ProxyURL}); err != nil {
+			r.add("config", "proxy_url", CheckFail, "%s", err)
+		} else {
+			r.add("config", "proxy_url", CheckPass, "%s", c.ProxyURL)
+		}
+	}
+	if c.ServerUser != "" && c.ServerPassword == "" {
+		r.add("config", "server_password", CheckWarn, "server_user is set without server_password")
+	}
+
+	if _, _, err := parsePortRange(c.ExporterPortRange); err != nil {
+		r.add("config", "exporter_port_range", CheckFail, "%s", err)
+	}
+
+	if c.EncryptSecrets {
+		if _, err := loadSecretKey(); err != nil {
+			r.add("config", "encrypt_secrets", CheckFail, "%s", err)
+		} else {
+			r.add("config", "encrypt_secrets", CheckPass, "secrets are encrypted with %s", secretKeyPath())
+		}
+	}
+}
+
+// binaries returns paths of exporters and qan-agent binaries.
+func binaries() []string {
+	return []string{
+		fmt.Sprintf("%s/node_exporter", SSMBaseDir),
+		fmt.Sprintf("%s/mysqld_exporter", SSMBaseDir),
+		fmt.Sprintf("%s/mongodb_exporter", SSMBaseDir),
+		fmt.Sprintf("%s/proxysql_exporter", SSMBaseDir),
+		fmt.Sprintf("%s/postgres_exporter", SSMBaseDir),
+		fmt.Sprintf("%s/bin/ssm-qan-agent", AgentBaseDir),
+		fmt.Sprintf("%s/bin/ssm-qan-agent-installer", AgentBaseDir),
+	}
+}
+
+func (a *Admin) doctorBinaries(r *DoctorReport) {
+	for _, p := range binaries() {
+		fi, err := os.Stat(p)
+		switch {
+		case err != nil:
+			r.add("binaries", filepath.Base(p), CheckFail, "%s is missing", p)
+		case fi.Mode()&0111 == 0:
+			r.add("binaries", filepath.Base(p), CheckFail, "%s is not executable", p)
+		default:
+			r.add("binaries", filepath.Base(p), CheckPass, "%s", p)
+		}
+	}
+}
+
+func (a *Admin) doctorExporterConfigs(r *DoctorReport) {
+	files, _ := filepath.Glob(path.Join(SSMBaseDir, "*.conf"))
+	for _, f := range files {
+		name := filepath.Base(f)
+		cfgFile, err := ini.Load(f)
+		if err != nil {
+			r.add("exporter_config", name, CheckFail, "cannot parse: %s", err)
+			continue
+		}
+		if exporterPort(cfgFile) == 0 {
+			r.add("exporter_config", name, CheckFail, "web.listen-address %q is not valid", cfgFile.Section("web").Key("listen-address").Value())
+			continue
+		}
+		missing := []string{}
+		for _, key := range []string{"auth-file", "ssl-cert-file", "ssl-key-file"} {
+			if v := cfgFile.Section("web").Key(key).Value(); v != "" && !FileExists(v) {
+				missing = append(missing, fmt.Sprintf("web.%s %s", key, v))
+			}
+		}
+		if len(missing) > 0 {
+			r.add("exporter_config", name, CheckFail, "missing files: %s", strings.Join(missing, ", "))
+			continue
+		}
+		r.add("exporter_config", name, CheckPass, "listens on %s", cfgFile.Section("web").Key("listen-address").Value())
+	}
+}
+
+func (a *Admin) doctorCertificate(r *DoctorReport) {
+	if !FileExists(SSLCertFile) && !FileExists(SSLKeyFile) {
+		r.add("tls", "certificate", CheckPass, "no certificate yet, it is generated with the first exporter using SSL")
+		return
+	}
+	pair, err := tls.LoadX509KeyPair(SSLCertFile, SSLKeyFile)
+	if err != nil {
+		r.add("tls", "certificate", CheckFail, "%s and %s do not match: %s", SSLCertFile, SSLKeyFile, err)
+		return
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		r.add("tls", "certificate", CheckFail, "cannot parse %s: %s", SSLCertFile, err)
+		return
+	}
+	r.add("tls", "key_pair", CheckPass, "%s matches %s", SSLCertFile, SSLKeyFile)
+	switch left := time.Until(cert.NotAfter); {
+	case left <= 0:
+		r.add("tls", "expiry", CheckFail, "certificate expired on %s, run 'ssm-admin cert renew'", cert.NotAfter.Format(time.RFC3339))
+	case left < certExpiryWarning:
+		r.add("tls", "expiry", CheckWarn, "certificate expires on %s, run 'ssm-admin cert renew'", cert.NotAfter.Format(time.RFC3339))
+	default:
+		r.add("tls", "expiry", CheckPass, "certificate expires on %s", cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func (a *Admin) doctorServices(r *DoctorReport, services []*consul.AgentService) {
+	localServices := map[string]localService{}
+	for _, s := range GetLocalServices() {
+		localServices[serviceID(s.serviceType, s.instance)] = s
+	}
+	ports, err := loadPortRegistry()
+	if err != nil {
+		r.add("services", "ports", CheckFail, "%s", err)
+		ports = &PortRegistry{Ports: map[string]int{}}
+	}
+
+	registered := map[string]bool{}
+	for _, svc := range services {
+		registered[svc.ID] = true
+		local, ok := localServices[svc.ID]
+		if !ok {
+			r.add("services", svc.ID, CheckFail, "registered on SSM server but system service is missing, run 'ssm-admin repair'")
+			continue
+		}
+		if !getServiceStatus(local.serviceName) {
+			r.add("services", svc.ID, CheckFail, "%s is not running", local.serviceName)
+			continue
+		}
+		// Queries services have no port.
+		if svc.Port == 0 {
+			r.add("services", svc.ID, CheckPass, "%s is running", local.serviceName)
+			continue
+		}
+		if port, ok := ports.Ports[svc.ID]; ok && port != svc.Port {
+			r.add("services", svc.ID, CheckWarn, "registered with port %d but assigned port %d, run 'ssm-admin ports reassign'", svc.Port, port)
+		}
+		if portIsFree(a.Config.BindAddress, svc.Port) {
+			r.add("services", svc.ID, CheckFail, "%s is running but nothing listens on %s:%d", local.serviceName, a.Config.BindAddress, svc.Port)
+			continue
+		}
+		if res := a.scrapeExporter(svc); res.Error != "" {
+			r.add("services", svc.ID, CheckFail, "%s", res.Error)
+			continue
+		}
+		r.add("services", svc.ID, CheckPass, "serving metrics on %s:%d", a.Config.BindAddress, svc.Port)
+	}
+
+	for id, s := range localServices {
+		if !registered[id] && getServiceStatus(s.serviceName) {
+			r.add("services", id, CheckWarn, "%s is running but not registered on SSM server, run 'ssm-admin repair'", s.serviceName)
+		}
+	}
+}
+
+func (a *Admin) doctorQAN(r *DoctorReport, services []*consul.AgentService) {
+	known := map[string]bool{}
+	for _, svc := range services {
+		if !strings.HasSuffix(svc.Service, ":queries") {
+			continue
+		}
+		prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, svc.ID)
+		data, _, err := a.consulAPI.KV().List(prefix, nil)
+		if err != nil {
+			r.add("qan", svc.ID, CheckFail, "Unable to communicate with Consul: %s", err)
+			continue
+		}
+		for _, kvp := range data {
+			if !strings.HasPrefix(path.Base(kvp.Key), "qan_") || !strings.HasSuffix(kvp.Key, "_uuid") {
+				continue
+			}
+			uuid := string(kvp.Value)
+			name := strings.TrimPrefix(path.Dir(kvp.Key), strings.TrimSuffix(prefix, "/")+"/")
+			known[uuid] = true
+
+			instanceFile := fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, uuid)
+			instance, err := a.readInstanceFile(instanceFile)
+			if os.IsNotExist(err) {
+				r.add("qan", svc.ID+" "+name, CheckFail, "instance file %s is missing", instanceFile)
+				continue
+			} else if err != nil {
+				r.add("qan", svc.ID+" "+name, CheckFail, "%s: %s", instanceFile, err)
+				continue
+			}
+			if instance.DSN == "" {
+				r.add("qan", svc.ID+" "+name, CheckFail, "instance file %s has no DSN", instanceFile)
+				continue
+			}
+			configFile := fmt.Sprintf("%s/config/qan-%s.conf", AgentBaseDir, uuid)
+			if !FileExists(configFile) {
+				r.add("qan", svc.ID+" "+name, CheckFail, "QAN config %s is missing", configFile)
+				continue
+			}
+			r.add("qan", svc.ID+" "+name, CheckPass, "instance %s", uuid)
+		}
+	}
+
+	files, _ := filepath.Glob(path.Join(AgentBaseDir, "instance", "*.json"))
+	for _, f := range files {
+		bytes, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		instance := proto.Instance{}
+		if err := json.Unmarshal(bytes, &instance); err != nil {
+			r.add("qan", filepath.Base(f), CheckFail, "cannot parse: %s", err)
+			continue
+		}
+		// Files of OS and agent instances are kept by qan-agent.
+		if instance.Subsystem != plugin.NameMySQL && instance.Subsystem != plugin.NameMongoDB && instance.Subsystem != plugin.NamePostgreSQL {
+			continue
+		}
+		if !known[instance.UUID] {
+			r.add("qan", filepath.Base(f), CheckWarn, "instance %s (%s) is not registered on SSM server", instance.Name, instance.Subsystem)
+		}
+	}
+}
+
+// PrintDoctorReport prints report of doctor checks.
+func (a *Admin) PrintDoctorReport(r *DoctorReport) {
+	if a.Format != "" {
+		tmpl, err := templates.Parse(a.Format)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := tmpl.Execute(os.Stdout, r); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println()
+		return
+	}
+
+	statuses := map[string]string{
+		CheckPass: colorStatus("PASS", "", true),
+		CheckWarn: color.New(color.FgYellow, color.Bold).Sprint("WARN"),
+		CheckFail: colorStatus("", "FAIL", false),
+	}
+	category := ""
+	for _, c := range r.Checks {
+		if c.Category != category {
+			category = c.Category
+			fmt.Printf("\n%s\n", strings.ToUpper(strings.Replace(category, "_", " ", -1)))
+		}
+		fmt.Printf("  %s  %-30s %s\n", statuses[c.Status], c.Name, c.Message)
+	}
+	fmt.Printf("\n%d passed, %d warnings, %d failed\n", r.Pass, r.Warn, r.Fail)
+}
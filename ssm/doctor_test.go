@@ -0,0 +1,62 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoctorReportExitCode(t *testing.T) {
+	r := &DoctorReport{}
+	r.add("config", "client_name", CheckPass, "%s", "db01")
+	assert.Equal(t, 0, r.ExitCode())
+	r.add("services", "mysql:metrics", CheckWarn, "port mismatch")
+	assert.Equal(t, 1, r.ExitCode())
+	r.add("tls", "expiry", CheckFail, "expired")
+	assert.Equal(t, 2, r.ExitCode())
+	assert.Equal(t, 1, r.Pass)
+	assert.Equal(t, 1, r.Warn)
+	assert.Equal(t, 1, r.Fail)
+	assert.Len(t, r.Checks, 3)
+}
+
+func TestDoctorConfig(t *testing.T) {
+	a := &Admin{Config: &Config{
+		ServerAddress:     "http://127.0.0.1",
+		ClientName:        "x",
+		ClientAddress:     "127.0.0.1",
+		BindAddress:       "127.0.0.1",
+		ServerSSL:         true,
+		ServerInsecureSSL: true,
+		ExporterPortRange: "2-1",
+	}}
+	r := &DoctorReport{}
+	a.doctorConfig(r)
+
+	statuses := map[string]string{}
+	for _, c := range r.Checks {
+		statuses[c.Name] = c.Status
+	}
+	assert.Equal(t, CheckFail, statuses["server_address"])
+	assert.Equal(t, CheckFail, statuses["client_name"])
+	assert.Equal(t, CheckPass, statuses["client_address"])
+	assert.Equal(t, CheckFail, statuses["server_ssl"])
+	assert.Equal(t, CheckFail, statuses["exporter_port_range"])
+}
@@ -0,0 +1,465 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Archive layout of exported client state.
+const (
+	exportConfigFile   = "ssm.yml"
+	exportConsulFile   = "consul.json"
+	exportKeyFile      = "ssm.key"
+	exportExporterDir  = "ssm-client"
+	exportAgentConfDir = "qan-agent/config"
+	exportInstanceDir  = "qan-agent/instance"
+)
+
+// consulState is Consul catalog and KV data of this client node.
+type consulState struct {
+	Node     string                 `json:"node"`
+	Address  string                 `json:"address"`
+	Services []*consul.AgentService `json:"services"`
+	KV       consul.KVPairs         `json:"kv"`
+}
+
+// ImportOptions are the client settings to override when importing.
+type ImportOptions struct {
+	ClientName    string
+	ClientAddress string
+	BindAddress   string
+	// Force replaces the key file of this host if the archive has a different one.
+	Force bool
+}
+
+// Export writes client config, exporter and agent configs, and Consul state of this node into a tar.gz archive.
+// Host key is included only with includeKey, the archive would hold encrypted secrets along with their key otherwise.
+func (a *Admin) Export(file string, includeKey bool) error {
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return err
+	}
+	state := consulState{
+		Node:    a.Config.ClientName,
+		Address: a.Config.ClientAddress,
+	}
+	if node != nil {
+		for _, svc := range node.Services {
+			if svc.Service == "consul" {
+				continue
+			}
+			state.Services = append(state.Services, svc)
+		}
+	}
+	state.KV, _, err = a.consulAPI.KV().List(a.Config.ClientName+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	configBytes, err := ioutil.ReadFile(ConfigFile)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, exportConfigFile, configBytes); err != nil {
+		return err
+	}
+	if includeKey && FileExists(SecretKeyFile) {
+		keyBytes, err := ioutil.ReadFile(SecretKeyFile)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, exportKeyFile, keyBytes); err != nil {
+			return err
+		}
+	}
+	stateBytes, _ := json.MarshalIndent(state, "", "    ")
+	if err := writeTarFile(tw, exportConsulFile, stateBytes); err != nil {
+		return err
+	}
+
+	dirs := map[string]string{
+		path.Join(SSMBaseDir, "*.conf"):               exportExporterDir,
+		path.Join(AgentBaseDir, "config", "*.conf"):   exportAgentConfDir,
+		path.Join(AgentBaseDir, "instance", "*.json"): exportInstanceDir,
+	}
+	for pattern, dir := range dirs {
+		files, _ := filepath.Glob(pattern)
+		for _, f := range files {
+			bytes, err := ioutil.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			if err := writeTarFile(tw, path.Join(dir, filepath.Base(f)), bytes); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Import restores client state from the archive created by Export and re-registers it on SSM server.
+func (a *Admin) Import(file string, opts ImportOptions) error {
+	files, err := readTarGz(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := files[exportConfigFile]; !ok {
+		return fmt.Errorf("%s is missing in the archive, it was not created by 'ssm-admin export'.", exportConfigFile)
+	}
+	if _, ok := files[exportConsulFile]; !ok {
+		return fmt.Errorf("%s is missing in the archive, it was not created by 'ssm-admin export'.", exportConsulFile)
+	}
+
+	a.Config = &Config{}
+	if err := yaml.Unmarshal(files[exportConfigFile], a.Config); err != nil {
+		return err
+	}
+	if keyBytes, ok := files[exportKeyFile]; ok {
+		// Secrets encrypted with the key of this host would become unreadable.
+		if current, err := ioutil.ReadFile(SecretKeyFile); err == nil && string(current) != string(keyBytes) && !opts.Force {
+			return fmt.Errorf("Key file %s differs from the one in the archive. Use --force flag to replace it.", SecretKeyFile)
+		}
+		if err := os.MkdirAll(SSMBaseDir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(SecretKeyFile, keyBytes, 0400); err != nil {
+			return err
+		}
+	}
+	if err := a.decryptConfig(); err != nil {
+		return fmt.Errorf("cannot decrypt secrets of the archive: %s. Copy the key file %s from the exported host or export with --include-key.", err, SecretKeyFile)
+	}
+	var state consulState
+	if err := json.Unmarshal(files[exportConsulFile], &state); err != nil {
+		return err
+	}
+	oldName := state.Node
+
+	if opts.ClientName != "" {
+		a.Config.ClientName = opts.ClientName
+	}
+	if opts.ClientAddress != "" {
+		a.Config.ClientAddress = opts.ClientAddress
+		a.Config.BindAddress = opts.ClientAddress
+	}
+	if opts.BindAddress != "" {
+		a.Config.BindAddress = opts.BindAddress
+	}
+	if a.Config.ManagedAPIPath == "" {
+		a.Config.ManagedAPIPath = managedAPIBasePath
+	}
+
+	// Set APIs and check if server is alive.
+	if err := a.SetAPI(); err != nil {
+		return err
+	}
+
+	if !isAddressLocal(a.Config.BindAddress) {
+		if opts.ClientAddress != "" || opts.BindAddress != "" {
+			return fmt.Errorf("Bind address %s is not locally bound. Use --bind-address flag to set the local address.", a.Config.BindAddress)
+		}
+		// The archive comes from another host, detect the address of this one.
+		a.Config.ClientAddress = a.getNginxHeader("X-Remote-IP")
+		a.Config.BindAddress = a.Config.ClientAddress
+		if a.Config.ClientAddress == "" || !isAddressLocal(a.Config.BindAddress) {
+			return errors.New("Cannot detect client address. Use --client-address and --bind-address flags to set it.")
+		}
+	}
+
+	if a.Config.ClientName != oldName {
+		node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+		if err != nil {
+			return fmt.Errorf("Unable to communicate with Consul: %s", err)
+		}
+		if node != nil && len(node.Services) > 0 {
+			return fmt.Errorf(`Another client with the same name '%s' detected, its address is %s.
+It has the active services so this name is not available.`, a.Config.ClientName, node.Node.Address)
+		}
+	}
+
+	// Restore local files.
+	if err := os.MkdirAll(SSMBaseDir, 0755); err != nil {
+		return err
+	}
+	if err := a.writeConfig(); err != nil {
+		return fmt.Errorf("Unable to write config file %s: %s", ConfigFile, err)
+	}
+	var agentConfigFile string
+	var instances []string
+	for name, bytes := range files {
+		var dst string
+		switch path.Dir(name) {
+		case exportExporterDir:
+			dst = path.Join(SSMBaseDir, path.Base(name))
+			if bytes, err = rebindExporterConfig(bytes, a.Config.BindAddress); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		case exportAgentConfDir:
+			dst = path.Join(AgentBaseDir, "config", path.Base(name))
+			if path.Base(name) == "agent.conf" {
+				agentConfigFile = dst
+			}
+		case exportInstanceDir:
+			dst = path.Join(AgentBaseDir, "instance", path.Base(name))
+			instances = append(instances, dst)
+		default:
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, bytes, 0600); err != nil {
+			return err
+		}
+	}
+	if agentConfigFile != "" {
+		if err := a.syncAgentConfig(agentConfigFile); err != nil {
+			return fmt.Errorf("Unable to update agent config %s: %s", agentConfigFile, err)
+		}
+	}
+
+	// Re-register services and KV under this client.
+	var errs Errors
+	for _, svc := range state.Services {
+		for i := range svc.Tags {
+			if svc.Tags[i] == fmt.Sprintf("alias_%s", oldName) {
+				svc.Tags[i] = fmt.Sprintf("alias_%s", a.Config.ClientName)
+			}
+		}
+		if svc.Port != 0 {
+			for _, tag := range svc.Tags {
+				if tag == "scheme_https" {
+					if err := a.checkSSLCertificate(); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+		reg := consul.CatalogRegistration{
+			Node:    a.Config.ClientName,
+			Address: a.Config.ClientAddress,
+			Service: svc,
+		}
+		if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+			return err
+		}
+	}
+	for _, kvp := range state.KV {
+		kvp.Key = renameKVKey(kvp.Key, oldName, a.Config.ClientName)
+		if _, err := a.consulAPI.KV().Put(kvp, nil); err != nil {
+			return err
+		}
+	}
+	if a.Config.ClientName != oldName {
+		if err := a.addRelabelKV(a.Config.ClientName, oldName); err != nil {
+			errs = append(errs, err)
+		}
+		// Everything is registered under the new name, the old node would show services nobody runs.
+		if err := a.removeNode(oldName); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to deregister old node %s: %s", oldName, err))
+		}
+	}
+
+	// Bring back QAN instances, they keep their UUIDs.
+	for _, f := range instances {
+		if err := a.restoreInstance(f, oldName, a.Config.ClientName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", f, err))
+		}
+	}
+
+	// Start services.
+	started := map[string]bool{}
+	for _, svc := range state.Services {
+		instance := serviceInstance(svc.ID)
+		name := instanceServiceName(svc.Service, instance)
+		if name == "" || started[name] {
+			continue
+		}
+		started[name] = true
+		// System services of exporter instances are not shipped with the package.
+		if instance != "" {
+			if err := installInstanceService(svc.Service, instance); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if _, ok := serviceExporters[svc.Service]; ok {
+			if _, err := a.prepareExporterSecrets(svc.Service, instance); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if err := restartService(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := enableService(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// removeNode deregisters the node with its services and deletes its KV.
+func (a *Admin) removeNode(name string) error {
+	if _, err := a.consulAPI.KV().DeleteTree(name+"/", nil); err != nil {
+		return err
+	}
+	return a.deregisterNode(name)
+}
+
+// restoreInstance renames and un-deletes QAN instance stored in the given file.
+func (a *Admin) restoreInstance(file, oldName, newName string) error {
+	instance, err := a.readInstanceFile(file)
+	if err != nil {
+		return err
+	}
+	if instance.Name == oldName {
+		instance.Name = newName
+	}
+	instance.Deleted = time.Time{}
+
+	// Local file keeps the real DSN, QAN API gets a sanitized copy of it.
+	if err := a.writeInstanceFile(file, instance); err != nil {
+		return err
+	}
+	instance.DSN = utils.SanitizeDSN(instance.DSN)
+	apiBytes, _ := json.Marshal(instance)
+	return a.updateInstance(instance.UUID, apiBytes)
+}
+
+// rebindExporterConfig points web.listen-address of exporter config to the given bind address.
+func rebindExporterConfig(bytes []byte, bindAddress string) ([]byte, error) {
+	cfg, err := ini.Load(bytes)
+	if err != nil {
+		return nil, err
+	}
+	key := cfg.Section("web").Key("listen-address")
+	_, port, err := net.SplitHostPort(key.Value())
+	if err != nil {
+		// Leave config as is, exporter will report it.
+		return bytes, nil
+	}
+	key.SetValue(net.JoinHostPort(bindAddress, port))
+
+	var buf strings.Builder
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// renameKVKey replaces client name and alias segments of Consul KV key.
+func renameKVKey(key, oldName, newName string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		// Skip service ID segment, it never contains client name.
+		if i != 1 && parts[i] == oldName {
+			parts[i] = newName
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// writeTarFile adds file with given content to the archive.
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Size:    int64(len(data)),
+		Mode:    0600,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readTarGz reads all regular files of tar.gz archive into memory.
+func readTarGz(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(header.Name)] = data
+	}
+	return files, nil
+}
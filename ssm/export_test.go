@@ -0,0 +1,109 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestRenameKVKey(t *testing.T) {
+	keys := map[string]string{
+		"db01/mysql:metrics/dsn":                 "db02/mysql:metrics/dsn",
+		"db01/mysql:queries/db01/dsn":            "db02/mysql:queries/db02/dsn",
+		"db01/mysql:queries/other/dsn":           "db02/mysql:queries/other/dsn",
+		"db01/mysql:queries/db01/qan_mysql_uuid": "db02/mysql:queries/db02/qan_mysql_uuid",
+	}
+	for key, expected := range keys {
+		assert.Equal(t, expected, renameKVKey(key, "db01", "db02"))
+	}
+}
+
+func TestRebindExporterConfig(t *testing.T) {
+	bytes, err := rebindExporterConfig([]byte("[web]\nlisten-address = 10.0.0.1:42002\n"), "10.0.0.2")
+	assert.NoError(t, err)
+	cfg, err := ini.Load(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2:42002", cfg.Section("web").Key("listen-address").Value())
+}
+
+func TestImportKeyMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-import")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(keyFile string) { SecretKeyFile = keyFile }(SecretKeyFile)
+	SecretKeyFile = path.Join(dir, "ssm.key")
+	require.NoError(t, ioutil.WriteFile(SecretKeyFile, []byte("host key"), 0400))
+
+	archive := path.Join(dir, "ssm-client.tar.gz")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, writeTarFile(tw, exportConfigFile, []byte("client_name: db01\n")))
+	require.NoError(t, writeTarFile(tw, exportConsulFile, []byte(`{"node": "db01"}`)))
+	require.NoError(t, writeTarFile(tw, exportKeyFile, []byte("archive key")))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	require.NoError(t, f.Close())
+
+	err = (&Admin{}).Import(archive, ImportOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "differs from the one in the archive")
+	key, err := ioutil.ReadFile(SecretKeyFile)
+	require.NoError(t, err)
+	assert.Equal(t, "host key", string(key))
+}
+
+func TestAdmin_RemoveNode(t *testing.T) {
+	fake := &fakeConsul{
+		node: "db01",
+		services: map[string]*consul.AgentService{
+			"mysql:metrics": {ID: "mysql:metrics", Service: "mysql:metrics", Port: 42002},
+			"mysql:queries": {ID: "mysql:queries", Service: "mysql:queries"},
+		},
+		kv: map[string][]byte{
+			"db01/mysql:metrics/dsn": []byte("root@tcp(localhost:3306)/"),
+			"db01/relabel":           []byte("[]"),
+			"db02/mysql:metrics/dsn": []byte("root@tcp(localhost:3306)/"),
+			"db02/relabel":           []byte("[]"),
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	admin := &Admin{Config: &Config{ClientName: "db02"}}
+	var err error
+	admin.consulAPI, err = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+
+	require.NoError(t, admin.removeNode("db01"))
+	assert.Empty(t, fake.services)
+	assert.Equal(t, []string{"db02/mysql:metrics/dsn", "db02/relabel"}, fake.keys())
+}
@@ -0,0 +1,139 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+)
+
+// Every exporter type has one system service and config file shipped with the package.
+// Additional instances of the same type get a copy of both named after the instance (service alias):
+// ssm-mysql-metrics@db2 service running with mysqld_exporter-db2.conf config,
+// registered on Consul with mysql:metrics@db2 service ID.
+
+// instanceConfigPath returns path of exporter config of the given instance.
+func instanceConfigPath(serviceType, instance string) string {
+	return path.Join(SSMBaseDir, plugin.ConfigFile(serviceExporters[serviceType], instance))
+}
+
+// freeInstance returns exporter instance for the new service of the type named after the service.
+// Renamed services keep their instance, so another service or its config may already take the name,
+// a numeric suffix is added then.
+func (a *Admin) freeInstance(serviceType, name string) (string, error) {
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return "", err
+	}
+	taken := func(instance string) bool {
+		if node != nil {
+			if _, ok := node.Services[serviceID(serviceType, instance)]; ok {
+				return true
+			}
+		}
+		return FileExists(instanceConfigPath(serviceType, instance))
+	}
+
+	instance := name
+	for i := 2; taken(instance); i++ {
+		instance = fmt.Sprintf("%s-%d", name, i)
+	}
+	return instance, nil
+}
+
+// prepareInstance creates exporter config and system service for the new exporter instance.
+func prepareInstance(serviceType, instance string) error {
+	cfgPath := instanceConfigPath(serviceType, instance)
+	if !FileExists(cfgPath) {
+		bytes, err := ioutil.ReadFile(instanceConfigPath(serviceType, ""))
+		if err != nil {
+			return err
+		}
+		// Port is assigned later, on registration.
+		if err := ioutil.WriteFile(cfgPath, bytes, 0600); err != nil {
+			return err
+		}
+	}
+
+	return installInstanceService(serviceType, instance)
+}
+
+// removeInstance removes exporter config and system service of the exporter instance.
+func removeInstance(serviceType, instance string) error {
+	if err := uninstallService(instanceServiceName(serviceType, instance)); err != nil {
+		return err
+	}
+	if err := removeSecretsDropIn(serviceType, instance); err != nil {
+		return err
+	}
+	if service.Platform() == systemdPlatform {
+		exec.Command("systemctl", "daemon-reload").Run()
+	}
+
+	err := os.Remove(instanceConfigPath(serviceType, instance))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// installInstanceService creates system service of exporter instance from the packaged one.
+func installInstanceService(serviceType, instance string) error {
+	var packaged *localService
+	for _, svc := range GetLocalServices(serviceType) {
+		if svc.instance == "" {
+			packaged = &svc
+			break
+		}
+	}
+	if packaged == nil {
+		return fmt.Errorf("system service %s is not found.", serviceName(serviceType))
+	}
+
+	bytes, err := ioutil.ReadFile(packaged.filePath)
+	if err != nil {
+		return err
+	}
+	exporter := serviceExporters[serviceType]
+	content := strings.Replace(string(bytes),
+		plugin.ConfigFile(exporter, ""), plugin.ConfigFile(exporter, instance), -1)
+	content = strings.Replace(content,
+		packaged.serviceName, instanceServiceName(serviceType, instance), -1)
+
+	dir, extension := GetServiceDirAndExtension()
+	mode := os.FileMode(0644)
+	if service.Platform() == systemvPlatform {
+		mode = 0755
+	}
+	file := path.Join(dir, instanceServiceName(serviceType, instance)+extension)
+	if err := ioutil.WriteFile(file, []byte(content), mode); err != nil {
+		return err
+	}
+
+	if service.Platform() == systemdPlatform {
+		return exec.Command("systemctl", "daemon-reload").Run()
+	}
+	return nil
+}
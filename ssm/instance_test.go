@@ -0,0 +1,38 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceNames(t *testing.T) {
+	assert.Equal(t, "mysql:metrics", serviceID("mysql:metrics", ""))
+	assert.Equal(t, "mysql:metrics@db2", serviceID("mysql:metrics", "db2"))
+	assert.Equal(t, "", serviceInstance("mysql:metrics"))
+	assert.Equal(t, "db2", serviceInstance("mysql:metrics@db2"))
+
+	assert.Equal(t, "ssm-mysql-metrics", instanceServiceName("mysql:metrics", ""))
+	assert.Equal(t, "ssm-mysql-metrics@db2", instanceServiceName("mysql:metrics", "db2"))
+
+	assert.Equal(t, path.Join(SSMBaseDir, "mysqld_exporter.conf"), instanceConfigPath("mysql:metrics", ""))
+	assert.Equal(t, path.Join(SSMBaseDir, "postgres_exporter-db2.conf"), instanceConfigPath("postgresql:metrics", "db2"))
+}
@@ -221,6 +221,56 @@ func (a *Admin) List() error {
 	return nil
 }
 
+// metricsOptions returns name, DSN and options of metrics service from Consul and its exporter config.
+func (a *Admin) metricsOptions(svc *consul.AgentService) (name, dsn string, opts []string) {
+	opts = []string{}
+	name = "-"
+	dsn = "-"
+	// Get values for service from Consul KV.
+	prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, svc.ID)
+	if data, _, err := a.consulAPI.KV().List(prefix, nil); err == nil {
+		for _, kvp := range data {
+			key := kvp.Key[len(prefix):]
+			switch key {
+			case "dsn":
+				dsn = string(kvp.Value)
+			case "relabel":
+				// Names before rename are for SSM server only.
+			default:
+				opts = append(opts, fmt.Sprintf("%s=%s", key, kvp.Value))
+			}
+		}
+	}
+
+	// Parse Consul service tags.
+	for _, tag := range svc.Tags {
+		if strings.HasPrefix(tag, "alias_") {
+			name = tag[6:]
+			continue
+		}
+		if tag == "scheme_https" {
+			continue
+		}
+		tag := strings.Replace(tag, "_", "=", 1)
+		opts = append(opts, tag)
+	}
+
+	// Get custom options
+	if uninitMetrics := newUninitializedMetrics(svc.Service, serviceInstance(svc.ID)); uninitMetrics != nil {
+		if customOpts, err := uninitMetrics.CustomOptions(); err == nil {
+			keys := make([]string, 0, len(customOpts))
+			for k := range customOpts {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				opts = append(opts, fmt.Sprintf("%s=%s", k, customOpts[k]))
+			}
+		}
+	}
+	return name, dsn, opts
+}
+
 func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 	// Parse all services except mysql:queries.
 	var queryServices []*consul.AgentService
@@ -235,50 +285,11 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 			continue
 		}
 
-		uninitMetrics := uninitializedMetrics[svc.Service]
-
+		instance := serviceInstance(svc.ID)
 		typeInName := serviceTypeInName(svc.Service)
 		status := getServiceStatus(fmt.Sprintf("ssm-%s-%d", typeInName, svc.Port)) ||
-			getServiceStatus(serviceName(svc.Service))
-
-		opts := []string{}
-		name := "-"
-		dsn := "-"
-		// Get values for service from Consul KV.
-		prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, svc.ID)
-		if data, _, err := a.consulAPI.KV().List(prefix, nil); err == nil {
-			for _, kvp := range data {
-				key := kvp.Key[len(prefix):]
-				switch key {
-				case "dsn":
-					dsn = string(kvp.Value)
-				default:
-					opts = append(opts, fmt.Sprintf("%s=%s", key, kvp.Value))
-				}
-			}
-		}
-
-		// Parse Consul service tags.
-		for _, tag := range svc.Tags {
-			if strings.HasPrefix(tag, "alias_") {
-				name = tag[6:]
-				continue
-			}
-			if tag == "scheme_https" {
-				continue
-			}
-			tag := strings.Replace(tag, "_", "=", 1)
-			opts = append(opts, tag)
-		}
-
-		// Get custom options
-		if uninitMetrics != nil {
-			if customOpts, err := uninitMetrics.CustomOptions(); err == nil {
-				for k, v := range customOpts {
-					opts = append(opts, fmt.Sprintf("%s=%s", k, v))
-				}
-			}
-		}
+			getServiceStatus(instanceServiceName(svc.Service, instance))
+		name, dsn, opts := a.metricsOptions(svc)
 
 		row := ServiceStatus{
 			Type:    svc.Service,
@@ -316,17 +327,18 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 					switch key {
 					case "dsn":
 						dsn = string(kvp.Value)
-					case "qan_mysql_uuid", "qan_mongodb_uuid":
+					case "tags":
+						for _, tag := range strings.Split(string(kvp.Value), ",") {
+							opts = append(opts, strings.Replace(tag, "_", "=", 1))
+						}
+					case "qan_mysql_uuid", "qan_mongodb_uuid", "qan_postgresql_uuid":
 						f := fmt.Sprintf("%s/config/qan-%s.conf", AgentBaseDir, kvp.Value)
 						config, err := getProtoQAN(f)
 						if err != nil {
 							opts = append(opts, err.Error())
 							continue
 						}
-						opts = append(opts, getQueriesOptions(config)...)
-						if key == "qan_mysql_uuid" {
-							opts = append(opts, getMySQLQueriesOptions(config)...)
-						}
+						opts = append(opts, queriesOptions(strings.TrimSuffix(strings.TrimPrefix(key, "qan_"), "_uuid"), config)...)
 					}
 				}
 			}
@@ -351,9 +363,24 @@ func getQueriesOptions(config *pc.QAN) (opts []string) {
 		opts = append(opts, fmt.Sprintf("query_source=%s", config.CollectFrom))
 	}
 	opts = append(opts, fmt.Sprintf("query_examples=%t", boolValue(config.ExampleQueries)))
+	if len(config.FilterOmit) > 0 {
+		opts = append(opts, fmt.Sprintf("filter_omit=%s", formatFilters(config.FilterOmit)))
+	}
+	if len(config.FilterAllow) > 0 {
+		opts = append(opts, fmt.Sprintf("filter_allow=%s", formatFilters(config.FilterAllow)))
+	}
 	return opts
 }
 
+// formatFilters quotes QAN filter rules, they may contain commas and spaces.
+func formatFilters(rules []string) string {
+	quoted := make([]string, len(rules))
+	for i, rule := range rules {
+		quoted[i] = fmt.Sprintf("%q", rule)
+	}
+	return strings.Join(quoted, ";")
+}
+
 // getMySQLQueriesOptions reads Queries options from QAN config file.
 func getMySQLQueriesOptions(config *pc.QAN) (opts []string) {
 	if config.CollectFrom == "slowlog" {
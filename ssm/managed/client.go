@@ -20,7 +20,6 @@ package managed
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,6 +27,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/shatteredsilicon/ssm-client/ssm/utils"
@@ -67,14 +67,13 @@ type Client struct {
 	scheme   string
 	user     *url.Userinfo
 	basePath string
+	retry    utils.RetryPolicy
 }
 
-func NewClient(host, basePath, scheme string, user *url.Userinfo, insecureSSL bool, verbose bool) *Client {
-	transport := &http.Transport{}
-	if insecureSSL {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+// NewClient returns *Client sending requests with the transport, http.DefaultTransport if it is nil.
+func NewClient(host, basePath, scheme string, user *url.Userinfo, transport http.RoundTripper, verbose bool) *Client {
+	if transport == nil {
+		transport = http.DefaultTransport
 	}
 	client := &http.Client{
 		Transport: transport,
@@ -89,6 +88,7 @@ func NewClient(host, basePath, scheme string, user *url.Userinfo, insecureSSL bo
 		scheme:   scheme,
 		user:     user,
 		basePath: basePath,
+		retry:    utils.DefaultRetryPolicy,
 	}
 }
 
@@ -117,7 +117,7 @@ func (c *Client) do(ctx context.Context, method string, urlPath string, body int
 	}
 	req = req.WithContext(ctx)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.retry.Do(c.client, req)
 	if err != nil {
 		return err
 	}
@@ -184,6 +184,33 @@ func (c *Client) AnnotationCreate(ctx context.Context, req *APIAnnotationCreateR
 	return c.do(ctx, "POST", "/v0/annotations", req, nil)
 }
 
+// AnnotationList returns annotations matching the request from managed API.
+func (c *Client) AnnotationList(ctx context.Context, req *APIAnnotationListRequest) (*APIAnnotationListResponse, error) {
+	res := new(APIAnnotationListResponse)
+	query := url.Values{}
+	if req.From != 0 {
+		query.Set("from", strconv.FormatInt(req.From, 10))
+	}
+	if req.To != 0 {
+		query.Set("to", strconv.FormatInt(req.To, 10))
+	}
+	for _, tag := range req.Tags {
+		query.Add("tags[]", tag)
+	}
+	if req.Limit != 0 {
+		query.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if err := c.do(ctx, "GET", "/v0/annotations", nil, res, query.Encode()); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AnnotationDelete deletes annotation by id at managed API.
+func (c *Client) AnnotationDelete(ctx context.Context, id int64) error {
+	return c.do(ctx, "DELETE", "/v0/annotations/"+strconv.FormatInt(id, 10), nil, nil)
+}
+
 // VersionGet returns version of the managed API.
 func (c *Client) VersionGet(ctx context.Context) (*VersionResponse, error) {
 	res := new(VersionResponse)
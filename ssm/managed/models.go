@@ -140,6 +140,37 @@ type APIAnnotationCreateRequest struct {
 	Tags []string `json:"tags,omitempty"`
 	// description of annotation
 	Text string `json:"text"`
+	// time of annotation in milliseconds since epoch, server time if not set (optional)
+	Time int64 `json:"time,omitempty"`
+	// end time of region annotation in milliseconds since epoch (optional)
+	TimeEnd int64 `json:"time_end,omitempty"`
+}
+
+// APIAnnotation annotation at managed.
+type APIAnnotation struct {
+	ID   int64    `json:"id"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+	// time of annotation in milliseconds since epoch
+	Time int64 `json:"time"`
+	// end time of region annotation in milliseconds since epoch, zero for point annotation
+	TimeEnd int64 `json:"time_end,omitempty"`
+}
+
+// APIAnnotationListRequest filters annotations to list at managed.
+type APIAnnotationListRequest struct {
+	// time range in milliseconds since epoch (optional)
+	From int64
+	To   int64
+	// annotations having all the tags (optional)
+	Tags []string
+	// max number of annotations (optional)
+	Limit int
+}
+
+// APIAnnotationListResponse list of annotations at managed.
+type APIAnnotationListResponse struct {
+	Annotations []*APIAnnotation `json:"annotations"`
 }
 
 // VersionResponse response server version.
@@ -27,8 +27,24 @@ import (
 	mysqlMetrics "github.com/shatteredsilicon/ssm-client/ssm/plugin/mysql/metrics"
 )
 
-var uninitializedMetrics = map[string]plugin.Metrics{
-	plugin.MySQLMetrics: mysqlMetrics.New(mysqlMetrics.Flags{}, mysql.Flags{}, SSMBaseDir),
+var uninitializedMetrics = map[string]func() plugin.Metrics{
+	plugin.MySQLMetrics: func() plugin.Metrics {
+		return mysqlMetrics.New(mysqlMetrics.Flags{}, mysql.Flags{}, SSMBaseDir)
+	},
+}
+
+// newUninitializedMetrics returns metrics plugin of the given exporter instance
+// usable to read its custom options, or nil if service type has none.
+func newUninitializedMetrics(serviceType, instance string) plugin.Metrics {
+	newMetrics, ok := uninitializedMetrics[serviceType]
+	if !ok {
+		return nil
+	}
+	m := newMetrics()
+	if mi, ok := m.(plugin.MultiInstance); ok && instance != "" {
+		mi.SetInstance(instance)
+	}
+	return m
 }
 
 // AddMetrics add metrics service to monitoring.
@@ -43,8 +59,67 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 		sslCertFile = SSLCertFile
 	}
 
+	serviceType := fmt.Sprintf("%s:metrics", m.Name())
+
+	// The first service of the type uses the packaged exporter,
+	// any other one gets an exporter instance of its own.
+	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	instance := ""
+	newInstance := false
+	if consulSvc != nil {
+		instance = serviceInstance(consulSvc.ID)
+	} else {
+		otherSvc, err := a.getConsulService(serviceType, "")
+		if err != nil {
+			return nil, err
+		}
+		if otherSvc != nil {
+			mi, ok := m.(plugin.MultiInstance)
+			if !ok {
+				return nil, ErrDuplicate
+			}
+			if instance, err = a.freeInstance(serviceType, a.ServiceName); err != nil {
+				return nil, err
+			}
+			newInstance = true
+			if err := prepareInstance(serviceType, instance); err != nil {
+				return nil, err
+			}
+			mi.SetInstance(instance)
+		}
+	}
+	if instance != "" {
+		if mi, ok := m.(plugin.MultiInstance); ok {
+			mi.SetInstance(instance)
+		}
+	}
+
+	registered := 0
+	if consulSvc != nil {
+		registered = consulSvc.Port
+	}
+	if _, err := a.assignExporterPort(serviceType, instance, registered); err != nil {
+		if newInstance {
+			removeInstance(serviceType, instance)
+		}
+		return nil, err
+	}
+
 	info, err := m.Init(ctx, a.Config.MySQLPassword, a.Config.BindAddress, ConfigFile, sslKeyFile, sslCertFile)
 	if err != nil {
+		if consulSvc == nil {
+			releaseExporterPort(serviceType, instance)
+		}
+		if newInstance {
+			removeInstance(serviceType, instance)
+		}
+		return nil, err
+	}
+	// Plugin writes the DSN in plain text.
+	if _, err := a.prepareExporterSecrets(serviceType, instance); err != nil {
 		return nil, err
 	}
 
@@ -56,12 +131,6 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 		}
 	}
 
-	serviceType := fmt.Sprintf("%s:metrics", m.Name())
-
-	consulSvc, err := a.getConsulService(serviceType, "")
-	if err != nil {
-		return nil, err
-	}
 	if consulSvc != nil {
 		return info, ErrDuplicate
 	}
@@ -93,9 +162,10 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 	if m.Cluster() != "" {
 		tags = append(tags, fmt.Sprintf("cluster_%s", m.Cluster()))
 	}
+	tags = append(tags, a.Tags...)
 
 	// Add service to Consul.
-	serviceID := fmt.Sprintf("%s", serviceType)
+	serviceID := serviceID(serviceType, instance)
 	srv := consul.AgentService{
 		ID:      serviceID,
 		Service: serviceType,
@@ -123,11 +193,11 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 		}
 	}
 
-	if err := startService(serviceName(serviceType)); err != nil {
+	if err := startService(instanceServiceName(serviceType, instance)); err != nil {
 		return nil, err
 	}
 
-	if err := enableService(serviceName(serviceType)); err != nil {
+	if err := enableService(instanceServiceName(serviceType, instance)); err != nil {
 		return nil, err
 	}
 
@@ -163,13 +233,22 @@ func (a *Admin) RemoveMetrics(name string) error {
 	}
 
 	// Stop and uninstall service.
-	if err := stopService(serviceName(serviceType)); err != nil {
+	instance := serviceInstance(consulSvc.ID)
+	if err := stopService(instanceServiceName(serviceType, instance)); err != nil {
 		return err
 	}
 
-	if err := disableService(serviceName(serviceType)); err != nil {
+	if err := disableService(instanceServiceName(serviceType, instance)); err != nil {
 		return err
 	}
 
+	if err := releaseExporterPort(serviceType, instance); err != nil {
+		return err
+	}
+
+	if instance != "" {
+		return removeInstance(serviceType, instance)
+	}
+
 	return nil
 }
@@ -35,6 +35,7 @@ const (
 	ProxySQLMetrics   = "proxysql:metrics"
 	MySQLQueries      = "mysql:queries"
 	MongoDBQueries    = "mongodb:queries"
+	PostgreSQLQueries = "postgresql:queries"
 )
 
 const (
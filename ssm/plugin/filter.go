@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Query Analytics filter rules are kept in pc.QAN FilterOmit (exclude) and FilterAllow (include) lists.
+// qan-agent matches them against query text literally, so "kind:value" rules are recognized
+// only to be rejected until the agent can apply them.
+const (
+	FilterRegex       = "regex"       // regular expression on query text
+	FilterFingerprint = "fingerprint" // query fingerprint
+	FilterChecksum    = "checksum"    // query class checksum
+	FilterSchema      = "schema"      // default database of query
+	FilterUser        = "user"        // user running query
+	FilterHost        = "host"        // client host of query
+)
+
+var filterKinds = []string{FilterRegex, FilterFingerprint, FilterChecksum, FilterSchema, FilterUser, FilterHost}
+
+// ParseFilter returns kind and value of the filter rule, kind is empty for literal query text.
+func ParseFilter(rule string) (kind, value string) {
+	for _, k := range filterKinds {
+		if strings.HasPrefix(rule, k+":") {
+			return k, rule[len(k)+1:]
+		}
+	}
+	return "", rule
+}
+
+// ValidateFilters checks filter rules are literal query text qan-agent can apply.
+func ValidateFilters(rules []string) error {
+	for _, rule := range rules {
+		if rule == "" {
+			return errors.New("empty filter rule")
+		}
+		if kind, _ := ParseFilter(rule); kind != "" {
+			return fmt.Errorf("filter rule %q: qan-agent does not support %s rules, only literal query text", rule, kind)
+		}
+	}
+	return nil
+}
+
+// FilterHelp describes filter rules for flags.
+const FilterHelp = `literal query text`
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, tc := range []struct {
+		rule, kind, value string
+	}{
+		{"SELECT 1", "", "SELECT 1"},
+		{"regex:^SHOW ", FilterRegex, "^SHOW "},
+		{"user:etl", FilterUser, "etl"},
+		{"host:10.0.0.5", FilterHost, "10.0.0.5"},
+		{"checksum:3A99CC42AEDCCFCD", FilterChecksum, "3A99CC42AEDCCFCD"},
+		{"SELECT a:b", "", "SELECT a:b"},
+		{"Regex:x", "", "Regex:x"},
+	} {
+		kind, value := ParseFilter(tc.rule)
+		assert.Equal(t, tc.kind, kind, tc.rule)
+		assert.Equal(t, tc.value, value, tc.rule)
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	assert.NoError(t, ValidateFilters(nil))
+	assert.NoError(t, ValidateFilters([]string{"SELECT 1", "SELECT a:b", "Regex:x"}))
+
+	// qan-agent matches rules against query text literally, it can't apply the other kinds.
+	for _, rule := range []string{"", "regex:^(SHOW|SET) ", "fingerprint:select ?", "checksum:3a99cc42aedccfcd", "schema:etl", "user:backup", "host:10.0.0.5"} {
+		assert.Error(t, ValidateFilters([]string{rule}), rule)
+	}
+}
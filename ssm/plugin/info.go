@@ -9,4 +9,8 @@ type Info struct {
 	DSN             string
 	QuerySource     string
 	SSMUserPassword string
+	// Warnings are problems found which don't prevent adding the service.
+	Warnings []string
+	// Configured are statements applied to the server to fix the problems.
+	Configured []string
 }
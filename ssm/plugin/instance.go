@@ -0,0 +1,20 @@
+package plugin
+
+import (
+	"fmt"
+)
+
+// MultiInstance is implemented by metrics plugins which can run several exporters on the same system.
+type MultiInstance interface {
+	// SetInstance makes plugin use the exporter config of the given instance.
+	SetInstance(instance string)
+}
+
+// ConfigFile returns exporter config file name of the given instance.
+// The first instance of exporter uses the config file shipped with the package.
+func ConfigFile(executable, instance string) string {
+	if instance == "" {
+		return fmt.Sprintf("%s.conf", executable)
+	}
+	return fmt.Sprintf("%s-%s.conf", executable, instance)
+}
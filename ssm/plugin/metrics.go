@@ -29,3 +29,9 @@ type Metrics interface {
 	// CustomOptions returns key-value map of custom options that are applied
 	CustomOptions() (map[string]string, error)
 }
+
+// Configurable is implemented by metrics plugins with custom options which can be changed on existing exporter.
+type Configurable interface {
+	// SetCustomOptions turns custom options ON or OFF in exporter config, see CustomOptions.
+	SetCustomOptions(opts map[string]string) error
+}
@@ -13,7 +13,10 @@ import (
 	"gopkg.in/ini.v1"
 )
 
-var _ plugin.Metrics = (*Metrics)(nil)
+var (
+	_ plugin.Metrics       = (*Metrics)(nil)
+	_ plugin.MultiInstance = (*Metrics)(nil)
+)
 
 // New returns *Metrics.
 func New(dsn string, args []string, cluster string, ssmBaseDir string) *Metrics {
@@ -22,6 +25,7 @@ func New(dsn string, args []string, cluster string, ssmBaseDir string) *Metrics
 		args:       args,
 		cluster:    cluster,
 		ssmBaseDir: ssmBaseDir,
+		cfgPath:    path.Join(ssmBaseDir, plugin.ConfigFile(plugin.MongoDBExporter, "")),
 	}
 }
 
@@ -32,6 +36,7 @@ type Metrics struct {
 	cluster    string
 	ssmBaseDir string
 	port       int
+	cfgPath    string
 }
 
 // Init initializes plugin.
@@ -49,8 +54,7 @@ func (m *Metrics) Init(
 	}
 	m.dsn = info.DSN
 
-	cfgPath := path.Join(m.ssmBaseDir, "mongodb_exporter.conf")
-	cfgFile, err := ini.Load(cfgPath)
+	cfgFile, err := ini.Load(m.cfgPath)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +74,7 @@ func (m *Metrics) Init(
 	cfgFile.Section("web").Key("auth-file").SetValue(authFile)
 	cfgFile.Section("web").Key("ssl-key-file").SetValue(sslKeyFile)
 	cfgFile.Section("web").Key("ssl-cert-file").SetValue(sslCertFile)
-	err = cfgFile.SaveTo(cfgPath)
+	err = cfgFile.SaveTo(m.cfgPath)
 	if err != nil {
 		return nil, err
 	}
@@ -78,6 +82,11 @@ func (m *Metrics) Init(
 	return info, nil
 }
 
+// SetInstance makes plugin use the exporter config of the given instance.
+func (m *Metrics) SetInstance(instance string) {
+	m.cfgPath = path.Join(m.ssmBaseDir, plugin.ConfigFile(plugin.MongoDBExporter, instance))
+}
+
 // Name of the exporter.
 func (Metrics) Name() string {
 	return plugin.NameMongoDB
@@ -0,0 +1,328 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Member roles.
+const (
+	RoleMongos     = "mongos"
+	RoleConfigSvr  = "configsvr"
+	RoleShardSvr   = "shardsvr"
+	RoleReplSet    = "replset"
+	RoleStandalone = "standalone"
+)
+
+// Member is a mongod or mongos process of MongoDB deployment.
+type Member struct {
+	Host    string // host:port
+	Role    string
+	ReplSet string
+	State   string // PRIMARY, SECONDARY, ARBITER etc. as reported by replSetGetStatus
+	Local   bool   // runs on this host
+}
+
+// Name returns name the member is added to monitoring under.
+func (m Member) Name() string {
+	return m.Host
+}
+
+// Tags returns Consul tags describing place of the member in the deployment.
+func (m Member) Tags() []string {
+	tags := []string{fmt.Sprintf("role_%s", m.Role)}
+	if m.ReplSet != "" {
+		tags = append(tags, fmt.Sprintf("replset_%s", m.ReplSet))
+	}
+	return tags
+}
+
+// Arbiter is true if member holds no data.
+func (m Member) Arbiter() bool {
+	return m.State == "ARBITER"
+}
+
+// Topology is MongoDB deployment discovered from the given URI.
+type Topology struct {
+	// Cluster is replica set name or, for sharded cluster, name of config server replica set.
+	Cluster string
+	Members []Member
+}
+
+type isMasterResult struct {
+	Msg     string `bson:"msg"`
+	SetName string `bson:"setName"`
+	Me      string `bson:"me"`
+	// Present only on config servers.
+	ConfigSvr int `bson:"configsvr"`
+}
+
+type replSetStatus struct {
+	Set     string `bson:"set"`
+	Members []struct {
+		Name     string `bson:"name"`
+		StateStr string `bson:"stateStr"`
+	} `bson:"members"`
+}
+
+// Discover enumerates members of the sharded cluster or replica set the URI points to.
+// For mongos, shards are read with listShards and config servers with getShardMap,
+// then members of each replica set with replSetGetStatus.
+func Discover(ctx context.Context, uri string) (*Topology, error) {
+	session, err := dial(ctx, uri, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to MongoDB using URI %s: %s", uri, err)
+	}
+	defer session.Close()
+
+	var im isMasterResult
+	if err := session.Run("isMaster", &im); err != nil {
+		return nil, err
+	}
+
+	topology := &Topology{}
+	switch {
+	case im.Msg == "isdbgrid":
+		if err := discoverShardedCluster(ctx, session, uri, topology); err != nil {
+			return nil, err
+		}
+	case im.SetName != "":
+		role := RoleReplSet
+		if im.ConfigSvr != 0 {
+			role = RoleConfigSvr
+		}
+		members, err := replSetMembers(session, role)
+		if err != nil {
+			return nil, err
+		}
+		topology.Cluster = im.SetName
+		topology.Members = members
+	default:
+		host := im.Me
+		if host == "" {
+			servers := session.LiveServers()
+			if len(servers) == 0 {
+				return nil, fmt.Errorf("cannot determine address of MongoDB server")
+			}
+			host = servers[0]
+		}
+		topology.Members = []Member{{Host: host, Role: RoleStandalone}}
+	}
+
+	localAddrs := localAddresses()
+	for i := range topology.Members {
+		topology.Members[i].Local = isLocal(topology.Members[i].Host, localAddrs)
+	}
+	sort.SliceStable(topology.Members, func(i, j int) bool {
+		if topology.Members[i].ReplSet != topology.Members[j].ReplSet {
+			return topology.Members[i].ReplSet < topology.Members[j].ReplSet
+		}
+		return topology.Members[i].Host < topology.Members[j].Host
+	})
+	return topology, nil
+}
+
+func discoverShardedCluster(ctx context.Context, session *mgo.Session, uri string, topology *Topology) error {
+	// Routers register themselves in config.mongos.
+	var routers []struct {
+		ID string `bson:"_id"`
+	}
+	if err := session.DB("config").C("mongos").Find(nil).All(&routers); err != nil {
+		return fmt.Errorf("cannot read config.mongos: %s", err)
+	}
+	for _, r := range routers {
+		topology.Members = append(topology.Members, Member{Host: r.ID, Role: RoleMongos})
+	}
+
+	var shards struct {
+		Shards []struct {
+			ID   string `bson:"_id"`
+			Host string `bson:"host"`
+		} `bson:"shards"`
+	}
+	if err := session.Run("listShards", &shards); err != nil {
+		return fmt.Errorf("cannot run listShards: %s", err)
+	}
+	var shardMap struct {
+		Map map[string]string `bson:"map"`
+	}
+	if err := session.Run("getShardMap", &shardMap); err != nil {
+		return fmt.Errorf("cannot run getShardMap: %s", err)
+	}
+
+	replSets := map[string]string{}
+	for _, s := range shards.Shards {
+		replSets[s.Host] = RoleShardSvr
+	}
+	if config, ok := shardMap.Map["config"]; ok {
+		replSets[config] = RoleConfigSvr
+		topology.Cluster, _ = parseReplSetHost(config)
+	}
+
+	for host, role := range replSets {
+		setName, seeds := parseReplSetHost(host)
+		members, err := discoverReplSet(ctx, uri, setName, seeds, role)
+		if err != nil {
+			return err
+		}
+		topology.Members = append(topology.Members, members...)
+	}
+	return nil
+}
+
+// discoverReplSet returns members of replica set connecting to it through the seeds.
+func discoverReplSet(ctx context.Context, uri, setName string, seeds []string, role string) ([]Member, error) {
+	session, err := dial(ctx, uri, seeds, setName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to replica set %s (%s): %s", setName, strings.Join(seeds, ","), err)
+	}
+	defer session.Close()
+
+	members, err := replSetMembers(session, role)
+	if err != nil {
+		return nil, fmt.Errorf("replica set %s: %s", setName, err)
+	}
+	return members, nil
+}
+
+func replSetMembers(session *mgo.Session, role string) ([]Member, error) {
+	var status replSetStatus
+	if err := session.Run(bson.D{{Name: "replSetGetStatus", Value: 1}}, &status); err != nil {
+		return nil, fmt.Errorf("cannot run replSetGetStatus: %s", err)
+	}
+	var members []Member
+	for _, m := range status.Members {
+		members = append(members, Member{Host: m.Name, Role: role, ReplSet: status.Set, State: m.StateStr})
+	}
+	return members, nil
+}
+
+// dial connects to MongoDB using credentials and options of the URI.
+// If hosts are given, they replace hosts of the URI.
+func dial(ctx context.Context, uri string, hosts []string, setName string) (*mgo.Session, error) {
+	info, err := mgo.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = 10 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		info.Timeout = time.Until(deadline)
+	}
+	if len(hosts) > 0 {
+		info.Addrs = hosts
+		info.ReplicaSetName = setName
+	} else {
+		info.Direct = true
+	}
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(mgo.Monotonic, true)
+	return session, nil
+}
+
+// parseReplSetHost parses "setName/host1:port,host2:port" host string of shard.
+func parseReplSetHost(s string) (string, []string) {
+	setName := ""
+	if i := strings.Index(s, "/"); i != -1 {
+		setName, s = s[:i], s[i+1:]
+	}
+	return setName, strings.Split(s, ",")
+}
+
+// MemberURI returns URI to connect directly to the member, with credentials and options of the given URI.
+func MemberURI(uri, host string) string {
+	prefix := ""
+	if strings.HasPrefix(uri, "mongodb://") {
+		prefix, uri = "mongodb://", strings.TrimPrefix(uri, "mongodb://")
+	}
+
+	rest := ""
+	if i := strings.IndexAny(uri, "/?"); i != -1 {
+		uri, rest = uri[:i], uri[i:]
+	}
+	userInfo := ""
+	if i := strings.LastIndex(uri, "@"); i != -1 {
+		userInfo = uri[:i+1]
+	}
+
+	// Member is monitored on its own, so replica set option is dropped.
+	if i := strings.Index(rest, "?"); i != -1 {
+		var options []string
+		for _, o := range strings.Split(rest[i+1:], "&") {
+			if o != "" && !strings.HasPrefix(o, "replicaSet=") {
+				options = append(options, o)
+			}
+		}
+		rest = rest[:i]
+		if len(options) > 0 {
+			rest += "?" + strings.Join(options, "&")
+		}
+	}
+
+	return prefix + userInfo + host + rest
+}
+
+// localAddresses returns IP addresses and names of this host.
+func localAddresses() []string {
+	addrs := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil {
+		addrs = append(addrs, hostname)
+	}
+	if ifaceAddrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range ifaceAddrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				addrs = append(addrs, ipnet.IP.String())
+			}
+		}
+	}
+	return addrs
+}
+
+// isLocal checks if host:port resolves to one of local addresses.
+func isLocal(hostPort string, localAddrs []string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+	candidates := []string{host}
+	if net.ParseIP(host) == nil {
+		if ips, err := net.LookupHost(host); err == nil {
+			candidates = append(candidates, ips...)
+		}
+	}
+	for _, c := range candidates {
+		for _, a := range localAddrs {
+			if c == a {
+				return true
+			}
+		}
+	}
+	return false
+}
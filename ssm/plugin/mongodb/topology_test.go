@@ -0,0 +1,82 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mongodb
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplSetHost(t *testing.T) {
+	setName, hosts := parseReplSetHost("rs0/db1:27017,db2:27017")
+	assert.Equal(t, "rs0", setName)
+	assert.Equal(t, []string{"db1:27017", "db2:27017"}, hosts)
+
+	setName, hosts = parseReplSetHost("db3:27018")
+	assert.Equal(t, "", setName)
+	assert.Equal(t, []string{"db3:27018"}, hosts)
+}
+
+func TestMemberURI(t *testing.T) {
+	samples := map[string]string{
+		"127.0.0.1:27017":                    "db1:27017",
+		"mongodb://mongos:27017":             "mongodb://db1:27017",
+		"mongodb://u:p@a:1,b:2/admin":        "mongodb://u:p@db1:27017/admin",
+		"u:p@a:1/admin?replicaSet=rs0&ssl=1": "u:p@db1:27017/admin?ssl=1",
+		"mongodb://u:p@a:1/?replicaSet=rs0":  "mongodb://u:p@db1:27017/",
+	}
+	for uri, expected := range samples {
+		assert.Equal(t, expected, MemberURI(uri, "db1:27017"), uri)
+	}
+}
+
+func TestMemberTags(t *testing.T) {
+	m := Member{Host: "db1:27017", Role: RoleShardSvr, ReplSet: "rs0", State: "SECONDARY"}
+	assert.Equal(t, []string{"role_shardsvr", "replset_rs0"}, m.Tags())
+	assert.False(t, m.Arbiter())
+
+	m = Member{Host: "mongos1:27017", Role: RoleMongos}
+	assert.Equal(t, []string{"role_mongos"}, m.Tags())
+}
+
+func TestIsLocal(t *testing.T) {
+	localAddrs := []string{"localhost", "10.0.0.5"}
+	assert.True(t, isLocal("10.0.0.5:27017", localAddrs))
+	assert.True(t, isLocal("localhost:27017", localAddrs))
+	assert.False(t, isLocal("10.0.0.6:27017", localAddrs))
+}
+
+func TestDiscoverReplSetUnreachable(t *testing.T) {
+	// Port nobody listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	seed := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	members, err := discoverReplSet(ctx, "mongodb://ssm:abc123@"+seed+"/admin", "rs0", []string{seed}, RoleShardSvr)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "rs0")
+	assert.Nil(t, members)
+}
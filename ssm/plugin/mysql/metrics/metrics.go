@@ -14,7 +14,11 @@ import (
 	"gopkg.in/ini.v1"
 )
 
-var _ plugin.Metrics = (*Metrics)(nil)
+var (
+	_ plugin.Metrics       = (*Metrics)(nil)
+	_ plugin.MultiInstance = (*Metrics)(nil)
+	_ plugin.Configurable  = (*Metrics)(nil)
+)
 
 // Flags are Metrics Metrics specific flags.
 type Flags struct {
@@ -46,7 +50,7 @@ func New(flags Flags, mysqlFlags mysql.Flags, ssmBaseDir string) *Metrics {
 		flags:      flags,
 		mysqlFlags: mysqlFlags,
 		ssmBaseDir: ssmBaseDir,
-		cfgPath:    path.Join(ssmBaseDir, "mysqld_exporter.conf"),
+		cfgPath:    path.Join(ssmBaseDir, plugin.ConfigFile(plugin.MySQLExporter, "")),
 	}
 }
 
@@ -69,6 +73,8 @@ func (m *Metrics) Init(
 	sslKeyFile string,
 	sslCertFile string,
 ) (*plugin.Info, error) {
+	m.mysqlFlags.Grants.Metrics = true
+	m.mysqlFlags.Grants.BinlogStats = !m.flags.DisableBinlogStats
 	info, err := mysql.Init(ctx, m.mysqlFlags, ssmUserPassword)
 	if err != nil {
 		return nil, err
@@ -117,6 +123,11 @@ func (m *Metrics) Init(
 	return info, nil
 }
 
+// SetInstance makes plugin use the exporter config of the given instance.
+func (m *Metrics) SetInstance(instance string) {
+	m.cfgPath = path.Join(m.ssmBaseDir, plugin.ConfigFile(plugin.MySQLExporter, instance))
+}
+
 // Name of the exporter.
 func (m Metrics) Name() string {
 	return plugin.NameMySQL
@@ -176,6 +187,34 @@ func (m Metrics) CustomOptions() (map[string]string, error) {
 	return opts, nil
 }
 
+// SetCustomOptions turns custom options ON or OFF in exporter config.
+// Turning option ON enables all collect args it consists of.
+func (m Metrics) SetCustomOptions(opts map[string]string) error {
+	cfgFile, err := ini.Load(m.cfgPath)
+	if err != nil {
+		return err
+	}
+
+	for opt, value := range opts {
+		collectArgs, ok := disableCollectArgs[opt]
+		if !ok {
+			return fmt.Errorf("unknown option %s", opt)
+		}
+		for key, disabled := range collectArgs {
+			switch strings.ToUpper(value) {
+			case "OFF":
+				cfgFile.Section("collect").Key(key).SetValue(disabled)
+			case "ON":
+				cfgFile.Section("collect").Key(key).SetValue("1")
+			default:
+				return fmt.Errorf("invalid value %s of option %s, must be ON or OFF", value, opt)
+			}
+		}
+	}
+
+	return cfgFile.SaveTo(m.cfgPath)
+}
+
 // Cluster defines cluster name for the target.
 func (m Metrics) Cluster() string {
 	return ""
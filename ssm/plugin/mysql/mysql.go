@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver"
@@ -28,7 +30,12 @@ type Flags struct {
 	MaxUserConn        uint16
 	Force              bool
 
-	FilterOmit []string
+	// Query Analytics filter rules, see plugin.ValidateFilters.
+	FilterOmit  []string
+	FilterAllow []string
+
+	// Grants the created user gets, set by plugins.
+	Grants Grants
 }
 
 // Init verifies MySQL connection and creates SSM user if requested.
@@ -98,6 +105,7 @@ func Init(ctx context.Context, flags Flags, ssmUserPassword string) (*plugin.Inf
 
 	// Create a new MySQL user.
 	if flags.CreateUser {
+		flags.Grants.QuerySource = ResolveQuerySource(flags.Grants.QuerySource, info.Hostname)
 		userDSN, err = createUser(ctx, db, userDSN, flags)
 		if err != nil {
 			return nil, err
@@ -121,12 +129,7 @@ func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags) (
 		userDSN.Password = utils.GeneratePassword(20)
 	}
 
-	hosts := []string{"%"}
-	if userDSN.Socket != "" || userDSN.Hostname == "localhost" {
-		hosts = []string{"localhost", "127.0.0.1"}
-	} else if userDSN.Hostname == "127.0.0.1" {
-		hosts = []string{"127.0.0.1"}
-	}
+	hosts := userHosts(userDSN)
 
 	if !flags.Force {
 		if err := check(ctx, db, hosts); err != nil {
@@ -135,7 +138,7 @@ func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags) (
 	}
 
 	// Create a new MySQL user with the necessary privs.
-	grants, err := makeGrants(ctx, db, userDSN, hosts, flags.MaxUserConn)
+	grants, err := makeGrants(ctx, db, userDSN, hosts, flags.MaxUserConn, flags.Grants)
 	if err != nil {
 		return dsn.DSN{}, err
 	}
@@ -156,6 +159,16 @@ func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags) (
 	return userDSN, nil
 }
 
+// userHosts returns hosts SSM user is created for.
+func userHosts(userDSN dsn.DSN) []string {
+	if userDSN.Socket != "" || userDSN.Hostname == "localhost" {
+		return []string{"localhost", "127.0.0.1"}
+	} else if userDSN.Hostname == "127.0.0.1" {
+		return []string{"127.0.0.1"}
+	}
+	return []string{"%"}
+}
+
 func check(ctx context.Context, db *sql.DB, hosts []string) error {
 	var (
 		errMsg []string
@@ -198,53 +211,297 @@ func check(ctx context.Context, db *sql.DB, hosts []string) error {
 	return nil
 }
 
-func makeGrants(ctx context.Context, db *sql.DB, dsn dsn.DSN, hosts []string, conn uint16) ([]string, error) {
-	var grants []string
-	for _, host := range hosts {
-		// Privileges:
-		// PROCESS - for mysqld_exporter to get all processes from `SHOW PROCESSLIST`
-		// REPLICATION CLIENT - for mysqld_exporter to run `SHOW BINARY LOGS`
-		// RELOAD - for qan-agent to run `FLUSH SLOW LOGS`
-		// SUPER - for qan-agent to set global variables (not clear it is still required)
-		// Grants for performance_schema - for qan-agent to manage query digest tables.
-		atLeastMySQL57, err := versionConstraint(ctx, db, ">= 5.7.0")
-		if err != nil {
+// Grants describes features the SSM user is granted privileges for.
+type Grants struct {
+	Metrics     bool // mysqld_exporter
+	BinlogStats bool // mysqld_exporter `SHOW BINARY LOGS`
+
+	QuerySource     string // qan-agent query source: slowlog, perfschema or auto, empty if queries are not collected
+	SlowLogRotation bool   // qan-agent `FLUSH SLOW LOGS`
+}
+
+// ServerVersion is MySQL server flavor and version.
+type ServerVersion struct {
+	Version *semver.Version
+	MariaDB bool
+}
+
+// check checks if version fits given constraint.
+func (v ServerVersion) check(constraint string) bool {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	return c.Check(v.Version)
+}
+
+// String returns human readable server version.
+func (v ServerVersion) String() string {
+	if v.MariaDB {
+		return "MariaDB " + v.Version.String()
+	}
+	return "MySQL " + v.Version.String()
+}
+
+// DefaultServerVersion is assumed when version can't be detected.
+var DefaultServerVersion = ServerVersion{Version: semver.MustParse("8.0.0")}
+
+// ResolveQuerySource resolves "auto" query source: MySQL is local if the server hostname == MySQL hostname.
+func ResolveQuerySource(querySource, mysqlHostname string) string {
+	if querySource != "auto" {
+		return querySource
+	}
+	osHostname, _ := os.Hostname()
+	if osHostname == mysqlHostname {
+		return "slowlog"
+	}
+	return "perfschema"
+}
+
+// Privileges returns global privileges and privileges on performance_schema tables
+// required for the given grants on the given server version.
+func Privileges(v ServerVersion, g Grants) ([]string, map[string][]string) {
+	// SELECT - for mysqld_exporter to read information_schema, performance_schema and sys schema,
+	// and for qan-agent to EXPLAIN queries.
+	privs := []string{"SELECT"}
+	tablePrivs := map[string][]string{}
+	add := func(priv string) {
+		for _, p := range privs {
+			if p == priv {
+				return
+			}
+		}
+		privs = append(privs, priv)
+	}
+
+	if g.Metrics {
+		// PROCESS - for mysqld_exporter to run `SHOW PROCESSLIST` and `SHOW ENGINE INNODB STATUS`.
+		add("PROCESS")
+		// MariaDB 10.5.9 splits REPLICATION CLIENT into BINLOG MONITOR and SLAVE MONITOR.
+		if v.MariaDB && v.check(">= 10.5.9") {
+			add("SLAVE MONITOR")
+			if g.BinlogStats {
+				add("BINLOG MONITOR")
+			}
+		} else {
+			// REPLICATION CLIENT - for mysqld_exporter to run `SHOW SLAVE STATUS` and `SHOW BINARY LOGS`.
+			add("REPLICATION CLIENT")
+		}
+	}
+
+	switch g.QuerySource {
+	case "slowlog":
+		// qan-agent sets slow log global variables,
+		// MySQL 8 grants it with dynamic privilege instead of SUPER.
+		if !v.MariaDB && v.check(">= 8.0.0") {
+			add("SYSTEM_VARIABLES_ADMIN")
+		} else {
+			add("SUPER")
+		}
+		// RELOAD - for qan-agent to run `FLUSH SLOW LOGS` on rotation.
+		if g.SlowLogRotation {
+			add("RELOAD")
+		}
+	case "perfschema":
+		// qan-agent enables statement consumers and instruments and truncates digest table.
+		tablePrivs["setup_consumers"] = []string{"UPDATE"}
+		tablePrivs["setup_instruments"] = []string{"UPDATE"}
+		tablePrivs["events_statements_summary_by_digest"] = []string{"DROP"}
+	}
+
+	return privs, tablePrivs
+}
+
+// grantRe matches privileges and object of SHOW GRANTS line, role grants have no object.
+var grantRe = regexp.MustCompile("^GRANT (.+?) ON (\\S+) TO ")
+
+// MissingPrivileges returns privileges required for the given grants the current MySQL user lacks,
+// e.g. "RELOAD ON *.*". Privileges granted through roles can't be seen, so nothing is reported for such user.
+func MissingPrivileges(ctx context.Context, db *sql.DB, g Grants) ([]string, error) {
+	v, err := getServerVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SHOW GRANTS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// granted are privileges by object, e.g. *.* or performance_schema.setup_consumers.
+	granted := map[string]map[string]bool{}
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
 			return nil, err
 		}
-		if atLeastMySQL57 {
-			exists, err := userExists(ctx, db, dsn.Username, host)
-			if err != nil {
-				return nil, err
+		m := grantRe.FindStringSubmatch(grant)
+		if m == nil {
+			if strings.HasPrefix(grant, "GRANT ") {
+				return nil, nil
 			}
-			if exists {
-				grants = append(grants,
-					fmt.Sprintf("ALTER USER '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d",
-						dsn.Username, host, dsn.Password, conn),
-				)
-			} else {
-				grants = append(grants,
-					fmt.Sprintf("CREATE USER '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d",
-						dsn.Username, host, dsn.Password, conn),
-				)
+			continue
+		}
+		object := strings.NewReplacer("`", "", "'", "", `"`, "").Replace(m[2])
+		if granted[object] == nil {
+			granted[object] = map[string]bool{}
+		}
+		for _, priv := range strings.Split(m[1], ",") {
+			granted[object][strings.ToUpper(strings.TrimSpace(priv))] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	has := func(priv string, objects ...string) bool {
+		for _, object := range objects {
+			privs := granted[object]
+			if privs[priv] || privs["ALL"] || privs["ALL PRIVILEGES"] {
+				return true
 			}
+		}
+		return false
+	}
+
+	var missing []string
+	privs, tablePrivs := Privileges(v, g)
+	for _, priv := range privs {
+		// SUPER still lets MySQL 8 set global variables.
+		if has(priv, "*.*") || (priv == "SYSTEM_VARIABLES_ADMIN" && has("SUPER", "*.*")) {
+			continue
+		}
+		missing = append(missing, priv+" ON *.*")
+	}
+	tables := make([]string, 0, len(tablePrivs))
+	for table := range tablePrivs {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, priv := range tablePrivs[table] {
+			if !has(priv, "*.*", "performance_schema.*", "performance_schema."+table) {
+				missing = append(missing, fmt.Sprintf("%s ON performance_schema.%s", priv, table))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// userSyntax checks if server supports CREATE USER/ALTER USER with resource options.
+func userSyntax(v ServerVersion) bool {
+	if v.MariaDB {
+		return v.check(">= 10.2.0")
+	}
+	return v.check(">= 5.7.0")
+}
+
+// grantStatements generates statements creating (or altering existing) user and granting privileges.
+func grantStatements(v ServerVersion, g Grants, dsn dsn.DSN, host string, conn uint16, exists bool) []string {
+	privs, tablePrivs := Privileges(v, g)
+
+	var grants []string
+	if userSyntax(v) {
+		if exists {
 			grants = append(grants,
-				fmt.Sprintf("GRANT SELECT, PROCESS, REPLICATION CLIENT, RELOAD, SUPER ON *.* TO '%s'@'%s'",
-					dsn.Username, host),
+				fmt.Sprintf("ALTER USER '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d",
+					dsn.Username, host, dsn.Password, conn),
 			)
 		} else {
 			grants = append(grants,
-				fmt.Sprintf("GRANT SELECT, PROCESS, REPLICATION CLIENT, RELOAD, SUPER ON *.* TO '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d",
+				fmt.Sprintf("CREATE USER '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d",
 					dsn.Username, host, dsn.Password, conn),
 			)
 		}
 		grants = append(grants,
-			fmt.Sprintf("GRANT UPDATE, DELETE, DROP ON `performance_schema`.* TO '%s'@'%s'", dsn.Username, host),
+			fmt.Sprintf("GRANT %s ON *.* TO '%s'@'%s'", strings.Join(privs, ", "), dsn.Username, host),
+		)
+	} else {
+		grants = append(grants,
+			fmt.Sprintf("GRANT %s ON *.* TO '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d",
+				strings.Join(privs, ", "), dsn.Username, host, dsn.Password, conn),
+		)
+	}
+
+	tables := make([]string, 0, len(tablePrivs))
+	for table := range tablePrivs {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		grants = append(grants,
+			fmt.Sprintf("GRANT %s ON `performance_schema`.`%s` TO '%s'@'%s'",
+				strings.Join(tablePrivs[table], ", "), table, dsn.Username, host),
 		)
 	}
 
+	return grants
+}
+
+func makeGrants(ctx context.Context, db *sql.DB, dsn dsn.DSN, hosts []string, conn uint16, g Grants) ([]string, error) {
+	v, err := getServerVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []string
+	for _, host := range hosts {
+		exists := false
+		if userSyntax(v) {
+			if exists, err = userExists(ctx, db, dsn.Username, host); err != nil {
+				return nil, err
+			}
+		}
+		grants = append(grants, grantStatements(v, g, dsn, host, conn, exists)...)
+	}
+
 	return grants, nil
 }
 
+// PrintGrants returns statements a DBA can run to create SSM user for the given flags,
+// without changing anything on MySQL. Server version is detected if MySQL is reachable,
+// otherwise DefaultServerVersion is assumed.
+func PrintGrants(ctx context.Context, flags Flags) ([]string, ServerVersion) {
+	userDSN := dsn.DSN{
+		DefaultsFile: flags.DefaultsFile,
+		Username:     flags.User,
+		Password:     flags.Password,
+		Hostname:     flags.Host,
+		Port:         flags.Port,
+		Socket:       flags.Socket,
+	}
+	if detected, err := userDSN.AutoDetect(ctx); err == nil || err == dsn.ErrNoSocket {
+		userDSN = detected
+	}
+
+	v := DefaultServerVersion
+	g := flags.Grants
+	if db, err := sql.Open("mysql", userDSN.String()); err == nil {
+		if detected, err := getServerVersion(ctx, db); err == nil {
+			v = detected
+		}
+		if info, err := getInfo(ctx, db); err == nil {
+			g.QuerySource = ResolveQuerySource(g.QuerySource, info.Hostname)
+		}
+		db.Close()
+	}
+	if g.QuerySource == "auto" {
+		g.QuerySource = "slowlog"
+	}
+
+	userDSN.Username = plugin.SSMUsername
+	userDSN.Password = flags.CreateUserPassword
+	if userDSN.Password == "" {
+		userDSN.Password = utils.GeneratePassword(20)
+	}
+
+	var grants []string
+	for _, host := range userHosts(userDSN) {
+		grants = append(grants, grantStatements(v, g, userDSN, host, flags.MaxUserConn, false)...)
+	}
+	return grants, v
+}
+
 func userExists(ctx context.Context, db *sql.DB, user, host string) (bool, error) {
 	count := 0
 	err := db.QueryRowContext(ctx, "SELECT 1 FROM mysql.user WHERE user=? AND host=?", user, host).Scan(&count)
@@ -283,25 +540,23 @@ func getInfo(ctx context.Context, db *sql.DB) (*plugin.Info, error) {
 	return info, nil
 }
 
-// versionConstraint checks if version fits given constraint.
-func versionConstraint(ctx context.Context, db *sql.DB, constraint string) (bool, error) {
+// getServerVersion returns MySQL server flavor and version.
+func getServerVersion(ctx context.Context, db *sql.DB) (ServerVersion, error) {
 	version := sql.NullString{}
 	err := db.QueryRowContext(ctx, "SELECT @@GLOBAL.version").Scan(&version)
 	if err != nil {
-		return false, err
+		return ServerVersion{}, err
 	}
 
 	// Strip everything after the first dash
 	re := regexp.MustCompile("-.*$")
-	version.String = re.ReplaceAllString(version.String, "")
-	v, err := semver.NewVersion(version.String)
+	v, err := semver.NewVersion(re.ReplaceAllString(version.String, ""))
 	if err != nil {
-		return false, err
+		return ServerVersion{}, err
 	}
 
-	constraints, err := semver.NewConstraint(constraint)
-	if err != nil {
-		return false, err
-	}
-	return constraints.Check(v), nil
+	return ServerVersion{
+		Version: v,
+		MariaDB: strings.Contains(strings.ToLower(version.String), "mariadb"),
+	}, nil
 }
@@ -23,9 +23,11 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/semver"
 	"github.com/percona/go-mysql/dsn"
 	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMySQLCheck1(t *testing.T) {
@@ -148,21 +150,15 @@ func TestMakeGrants(t *testing.T) {
 		mock.ExpectQuery("SELECT 1 FROM mysql.user WHERE user=?").WithArgs("root", "localhost").WillReturnRows(rows)
 	}
 
-	{
-		columns := []string{"version"}
-		rows := sqlmock.NewRows(columns).AddRow("8.1.0")
-		mock.ExpectQuery("SELECT @@GLOBAL.version").WillReturnRows(rows)
-	}
-
 	{
 		columns := []string{"exists"}
-		rows := sqlmock.NewRows(columns)
+		rows := sqlmock.NewRows(columns).AddRow(1)
 		mock.ExpectQuery("SELECT 1 FROM mysql.user WHERE user=?").WithArgs("root", "127.0.0.1").WillReturnRows(rows)
 	}
 
 	{
 		columns := []string{"version"}
-		rows := sqlmock.NewRows(columns).AddRow("8.1.0")
+		rows := sqlmock.NewRows(columns).AddRow("5.7.30-log")
 		mock.ExpectQuery("SELECT @@GLOBAL.version").WillReturnRows(rows)
 	}
 
@@ -176,38 +172,128 @@ func TestMakeGrants(t *testing.T) {
 		dsn    dsn.DSN
 		hosts  []string
 		conn   uint16
+		g      Grants
 		grants []string
 	}
 	samples := []sample{
 		{dsn: dsn.DSN{Username: "root", Password: "abc123"},
 			hosts: []string{"localhost", "127.0.0.1"},
 			conn:  5,
+			g:     Grants{Metrics: true, BinlogStats: true, QuerySource: "slowlog", SlowLogRotation: true},
 			grants: []string{
 				"CREATE USER 'root'@'localhost' IDENTIFIED BY 'abc123' WITH MAX_USER_CONNECTIONS 5",
-				"GRANT SELECT, PROCESS, REPLICATION CLIENT, RELOAD, SUPER ON *.* TO 'root'@'localhost'",
-				"GRANT UPDATE, DELETE, DROP ON `performance_schema`.* TO 'root'@'localhost'",
-				"CREATE USER 'root'@'127.0.0.1' IDENTIFIED BY 'abc123' WITH MAX_USER_CONNECTIONS 5",
-				"GRANT SELECT, PROCESS, REPLICATION CLIENT, RELOAD, SUPER ON *.* TO 'root'@'127.0.0.1'",
-				"GRANT UPDATE, DELETE, DROP ON `performance_schema`.* TO 'root'@'127.0.0.1'",
+				"GRANT SELECT, PROCESS, REPLICATION CLIENT, SYSTEM_VARIABLES_ADMIN, RELOAD ON *.* TO 'root'@'localhost'",
+				"ALTER USER 'root'@'127.0.0.1' IDENTIFIED BY 'abc123' WITH MAX_USER_CONNECTIONS 5",
+				"GRANT SELECT, PROCESS, REPLICATION CLIENT, SYSTEM_VARIABLES_ADMIN, RELOAD ON *.* TO 'root'@'127.0.0.1'",
 			},
 		},
 		{dsn: dsn.DSN{Username: "admin", Password: "23;,_-asd"},
 			hosts: []string{"%"},
 			conn:  20,
+			g:     Grants{QuerySource: "perfschema"},
 			grants: []string{
 				"CREATE USER 'admin'@'%' IDENTIFIED BY '23;,_-asd' WITH MAX_USER_CONNECTIONS 20",
-				"GRANT SELECT, PROCESS, REPLICATION CLIENT, RELOAD, SUPER ON *.* TO 'admin'@'%'",
-				"GRANT UPDATE, DELETE, DROP ON `performance_schema`.* TO 'admin'@'%'",
+				"GRANT SELECT ON *.* TO 'admin'@'%'",
+				"GRANT DROP ON `performance_schema`.`events_statements_summary_by_digest` TO 'admin'@'%'",
+				"GRANT UPDATE ON `performance_schema`.`setup_consumers` TO 'admin'@'%'",
+				"GRANT UPDATE ON `performance_schema`.`setup_instruments` TO 'admin'@'%'",
 			},
 		},
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	for _, s := range samples {
-		grants, err := makeGrants(ctx, db, s.dsn, s.hosts, s.conn)
+		grants, err := makeGrants(ctx, db, s.dsn, s.hosts, s.conn, s.g)
 		assert.NoError(t, err)
 		assert.Equal(t, s.grants, grants)
 	}
+
+	// Ensure all SQL queries were executed
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPrivileges(t *testing.T) {
+	all := Grants{Metrics: true, BinlogStats: true, QuerySource: "slowlog", SlowLogRotation: true}
+	version := func(v string, mariaDB bool) ServerVersion {
+		return ServerVersion{Version: semver.MustParse(v), MariaDB: mariaDB}
+	}
+
+	privs, tablePrivs := Privileges(version("5.7.30", false), all)
+	assert.Equal(t, []string{"SELECT", "PROCESS", "REPLICATION CLIENT", "SUPER", "RELOAD"}, privs)
+	assert.Empty(t, tablePrivs)
+
+	privs, _ = Privileges(version("8.0.32", false), Grants{Metrics: true, QuerySource: "slowlog"})
+	assert.Equal(t, []string{"SELECT", "PROCESS", "REPLICATION CLIENT", "SYSTEM_VARIABLES_ADMIN"}, privs)
+
+	privs, _ = Privileges(version("10.6.12", true), all)
+	assert.Equal(t, []string{"SELECT", "PROCESS", "SLAVE MONITOR", "BINLOG MONITOR", "SUPER", "RELOAD"}, privs)
+
+	privs, _ = Privileges(version("10.4.28", true), Grants{Metrics: true})
+	assert.Equal(t, []string{"SELECT", "PROCESS", "REPLICATION CLIENT"}, privs)
+}
+
+func TestMissingPrivileges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	expect := func(version string, grants ...string) {
+		mock.ExpectQuery("SELECT @@GLOBAL.version").WillReturnRows(sqlmock.NewRows([]string{"@@GLOBAL.version"}).AddRow(version))
+		rows := sqlmock.NewRows([]string{"Grants for ssm@localhost"})
+		for _, grant := range grants {
+			rows.AddRow(grant)
+		}
+		mock.ExpectQuery("SHOW GRANTS").WillReturnRows(rows)
+	}
+
+	expect("8.0.36", "GRANT SELECT, PROCESS, REPLICATION CLIENT ON *.* TO `ssm`@`localhost`")
+	missing, err := MissingPrivileges(ctx, db, Grants{QuerySource: "perfschema"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"DROP ON performance_schema.events_statements_summary_by_digest",
+		"UPDATE ON performance_schema.setup_consumers",
+		"UPDATE ON performance_schema.setup_instruments",
+	}, missing)
+
+	expect("8.0.36",
+		"GRANT SELECT, PROCESS, REPLICATION CLIENT ON *.* TO `ssm`@`localhost`",
+		"GRANT UPDATE ON `performance_schema`.`setup_consumers` TO `ssm`@`localhost`",
+		"GRANT UPDATE ON `performance_schema`.`setup_instruments` TO `ssm`@`localhost`",
+		"GRANT DROP ON `performance_schema`.* TO `ssm`@`localhost`",
+	)
+	missing, err = MissingPrivileges(ctx, db, Grants{QuerySource: "perfschema"})
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// SUPER is enough to set global variables on MySQL 8.
+	expect("8.0.36", "GRANT SELECT, SUPER ON *.* TO `ssm`@`localhost`")
+	missing, err = MissingPrivileges(ctx, db, Grants{QuerySource: "slowlog", SlowLogRotation: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"RELOAD ON *.*"}, missing)
+
+	expect("10.6.12-MariaDB", "GRANT ALL PRIVILEGES ON *.* TO 'root'@'localhost' WITH GRANT OPTION")
+	missing, err = MissingPrivileges(ctx, db, Grants{QuerySource: "slowlog", SlowLogRotation: true})
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// Privileges of roles are not checked.
+	expect("8.0.36", "GRANT USAGE ON *.* TO `ssm`@`localhost`", "GRANT `monitoring`@`%` TO `ssm`@`localhost`")
+	missing, err = MissingPrivileges(ctx, db, Grants{QuerySource: "slowlog"})
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGrantStatementsOldSyntax(t *testing.T) {
+	v := ServerVersion{Version: semver.MustParse("10.1.48"), MariaDB: true}
+	grants := grantStatements(v, Grants{Metrics: true}, dsn.DSN{Username: "ssm", Password: "pass"}, "%", 10, false)
+	assert.Equal(t, []string{
+		"GRANT SELECT, PROCESS, REPLICATION CLIENT ON *.* TO 'ssm'@'%' IDENTIFIED BY 'pass' WITH MAX_USER_CONNECTIONS 10",
+	}, grants)
 }
 
 func TestGetMysqlInfo(t *testing.T) {
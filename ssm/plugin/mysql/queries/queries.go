@@ -2,7 +2,7 @@ package queries
 
 import (
 	"context"
-	"os"
+	"database/sql"
 
 	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
 	"github.com/shatteredsilicon/ssm-client/ssm/plugin/mysql"
@@ -17,6 +17,8 @@ type Flags struct {
 	// slowlog specific options.
 	RetainSlowLogs  int
 	SlowLogRotation bool
+	// ConfigureSlowLog applies recommended slow log settings.
+	ConfigureSlowLog bool
 }
 
 // New returns *Queries.
@@ -41,28 +43,71 @@ type Queries struct {
 func (q *Queries) Init(ctx context.Context, ssmUserPassword string, info *plugin.Info) (*plugin.Info, error) {
 	var err error
 
+	if err := plugin.ValidateFilters(q.mysqlFlags.FilterOmit); err != nil {
+		return nil, err
+	}
+	if err := plugin.ValidateFilters(q.mysqlFlags.FilterAllow); err != nil {
+		return nil, err
+	}
+
 	if info == nil {
+		q.mysqlFlags.Grants.QuerySource = q.flags.QuerySource
+		q.mysqlFlags.Grants.SlowLogRotation = q.flags.SlowLogRotation
 		info, err = mysql.Init(ctx, q.mysqlFlags, ssmUserPassword)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if q.flags.QuerySource == "auto" {
-		// MySQL is local if the server hostname == MySQL hostname.
-		osHostname, _ := os.Hostname()
-		if osHostname == info.Hostname {
-			q.flags.QuerySource = "slowlog"
-		} else {
-			q.flags.QuerySource = "perfschema"
-		}
-	}
-
+	q.flags.QuerySource = mysql.ResolveQuerySource(q.flags.QuerySource, info.Hostname)
 	info.QuerySource = q.flags.QuerySource
 	q.dsn = info.DSN
+
+	if q.flags.QuerySource == "slowlog" {
+		if err := q.checkSlowLog(ctx, info); err != nil {
+			return nil, err
+		}
+	}
 	return info, nil
 }
 
+// checkSlowLog reports slow log settings problems as warnings, applying recommended ones if requested.
+func (q *Queries) checkSlowLog(ctx context.Context, info *plugin.Info) error {
+	db, err := sql.Open("mysql", info.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	problems, err := mysql.CheckSlowLog(ctx, db)
+	if err != nil {
+		return err
+	}
+	if q.flags.ConfigureSlowLog {
+		info.Configured, err = mysql.ConfigureSlowLog(ctx, db, problems)
+		if err != nil {
+			return err
+		}
+		if len(info.Configured) > 0 {
+			if problems, err = mysql.CheckSlowLog(ctx, db); err != nil {
+				return err
+			}
+		}
+	}
+
+	fixable := false
+	for _, p := range problems {
+		info.Warnings = append(info.Warnings, "slow log "+p.String())
+		if p.Recommended != "" {
+			fixable = true
+		}
+	}
+	if fixable && !q.flags.ConfigureSlowLog {
+		info.Warnings = append(info.Warnings, "Use --configure-slowlog flag to apply recommended settings with SET GLOBAL.")
+	}
+	return nil
+}
+
 // Name of the service.
 func (q Queries) Name() string {
 	return plugin.NameMySQL
@@ -91,3 +136,8 @@ func (q Queries) Config() pc.QAN {
 func (q Queries) FilterOmit() []string {
 	return q.mysqlFlags.FilterOmit
 }
+
+// FilterAllow returns queries that are allowed, all if empty.
+func (q Queries) FilterAllow() []string {
+	return q.mysqlFlags.FilterAllow
+}
@@ -0,0 +1,241 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	pc "github.com/shatteredsilicon/ssm/proto/config"
+)
+
+// SlowLogProblem is a server setting which keeps qan-agent from getting queries from slow log.
+type SlowLogProblem struct {
+	Variable string
+	Value    string
+	// Recommended value to SET GLOBAL, empty if the problem can't be fixed this way.
+	Recommended string
+	Message     string
+}
+
+// String returns human readable problem.
+func (p SlowLogProblem) String() string {
+	return fmt.Sprintf("%s = %q: %s", p.Variable, p.Value, p.Message)
+}
+
+// Statement returns SET GLOBAL statement applying recommended value.
+func (p SlowLogProblem) Statement() string {
+	value := p.Recommended
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		value = "'" + value + "'"
+	}
+	return fmt.Sprintf("SET GLOBAL %s = %s", p.Variable, value)
+}
+
+// slowLogVariables are server variables slow log collection depends on,
+// log_slow_* ones exist on Percona Server and MariaDB only.
+var slowLogVariables = []string{
+	"version",
+	"datadir",
+	"slow_query_log",
+	"slow_query_log_file",
+	"log_output",
+	"long_query_time",
+	"log_slow_verbosity",
+	"log_slow_rate_limit",
+	"log_slow_rate_type",
+}
+
+// CheckSlowLog checks if slow log settings let qan-agent get queries from it.
+// Slow log file is checked on this host, so MySQL is expected to be local.
+func CheckSlowLog(ctx context.Context, db *sql.DB) ([]SlowLogProblem, error) {
+	vars, err := getSlowLogVariables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	problems := slowLogProblems(vars)
+	return append(problems, slowLogFileProblems(vars)...), nil
+}
+
+// ConfigureSlowLog applies recommended values of the problems and returns executed statements.
+func ConfigureSlowLog(ctx context.Context, db *sql.DB, problems []SlowLogProblem) ([]string, error) {
+	var applied []string
+	for _, p := range problems {
+		if p.Recommended == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, p.Statement()); err != nil {
+			return applied, fmt.Errorf("Problem configuring slow log. Failed to execute %s: %s\n\n%s",
+				p.Statement(), err, "Verify that MySQL user has SUPER or SYSTEM_VARIABLES_ADMIN privilege.")
+		}
+		applied = append(applied, p.Statement())
+	}
+	return applied, nil
+}
+
+func getSlowLogVariables(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	query := fmt.Sprintf("SHOW GLOBAL VARIABLES WHERE Variable_name IN ('%s')", strings.Join(slowLogVariables, "', '"))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vars := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		vars[strings.ToLower(name)] = value
+	}
+	return vars, rows.Err()
+}
+
+// slowLogEnabled checks if slow_query_log is ON.
+func slowLogEnabled(vars map[string]string) bool {
+	v := strings.ToUpper(vars["slow_query_log"])
+	return v == "ON" || v == "1"
+}
+
+// slowLogProblems checks slow log variables.
+func slowLogProblems(vars map[string]string) []SlowLogProblem {
+	var problems []SlowLogProblem
+
+	if !slowLogEnabled(vars) {
+		problems = append(problems, SlowLogProblem{
+			Variable:    "slow_query_log",
+			Value:       vars["slow_query_log"],
+			Recommended: "ON",
+			Message:     "slow log is disabled, no queries are logged",
+		})
+	}
+
+	if output, ok := vars["log_output"]; ok {
+		outputs := strings.Split(strings.ToUpper(output), ",")
+		if !contains(outputs, "FILE") {
+			recommended := "FILE"
+			if contains(outputs, "TABLE") {
+				recommended = "FILE,TABLE"
+			}
+			problems = append(problems, SlowLogProblem{
+				Variable:    "log_output",
+				Value:       output,
+				Recommended: recommended,
+				Message:     "slow log is not written to file, qan-agent reads it from file only",
+			})
+		}
+	}
+
+	if v, err := strconv.ParseFloat(vars["long_query_time"], 64); err == nil && v > 1 {
+		problems = append(problems, SlowLogProblem{
+			Variable:    "long_query_time",
+			Value:       vars["long_query_time"],
+			Recommended: "0",
+			Message:     fmt.Sprintf("only queries slower than %gs are logged, most queries are missed", v),
+		})
+	}
+
+	mariaDB := strings.Contains(strings.ToLower(vars["version"]), "mariadb")
+	if verbosity, ok := vars["log_slow_verbosity"]; ok {
+		values := strings.Split(strings.ToLower(verbosity), ",")
+		switch {
+		case mariaDB && !contains(values, "query_plan") && !contains(values, "full"):
+			problems = append(problems, SlowLogProblem{
+				Variable:    "log_slow_verbosity",
+				Value:       verbosity,
+				Recommended: "query_plan",
+				Message:     "query plan details are not logged",
+			})
+		case !mariaDB && !contains(values, "full"):
+			problems = append(problems, SlowLogProblem{
+				Variable:    "log_slow_verbosity",
+				Value:       verbosity,
+				Recommended: "full",
+				Message:     "query plan and InnoDB details are not logged",
+			})
+		}
+	}
+
+	// Sampling sessions loses queries of long-living connections, e.g. of pools, entirely.
+	if limit, err := strconv.Atoi(vars["log_slow_rate_limit"]); err == nil && limit > 1 {
+		rateType, ok := vars["log_slow_rate_type"]
+		switch {
+		case ok && strings.ToLower(rateType) == "session":
+			problems = append(problems, SlowLogProblem{
+				Variable:    "log_slow_rate_type",
+				Value:       rateType,
+				Recommended: "query",
+				Message:     fmt.Sprintf("only one of %d sessions is logged, queries of other connections are never seen", limit),
+			})
+		case !ok && mariaDB:
+			problems = append(problems, SlowLogProblem{
+				Variable:    "log_slow_rate_limit",
+				Value:       vars["log_slow_rate_limit"],
+				Recommended: "1",
+				Message:     fmt.Sprintf("only one of %d sessions is logged, queries of other connections are never seen", limit),
+			})
+		}
+	}
+
+	return problems
+}
+
+// slowLogFileProblems checks slow log file is readable and has disk space to grow till rotation.
+func slowLogFileProblems(vars map[string]string) []SlowLogProblem {
+	file := vars["slow_query_log_file"]
+	if file == "" {
+		return nil
+	}
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(vars["datadir"], path)
+	}
+	problem := SlowLogProblem{Variable: "slow_query_log_file", Value: file}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		// MySQL creates it once slow log is enabled.
+		if slowLogEnabled(vars) {
+			problem.Message = fmt.Sprintf("%s does not exist on this host, use --query-source=perfschema for remote MySQL", path)
+			return []SlowLogProblem{problem}
+		}
+	case err != nil:
+		problem.Message = fmt.Sprintf("%s is not readable by SSM agent: %s", path, err)
+		return []SlowLogProblem{problem}
+	default:
+		f.Close()
+	}
+
+	if free, err := diskFree(filepath.Dir(path)); err == nil {
+		if free < uint64(pc.DefaultMaxSlowLogSize) {
+			problem.Message = fmt.Sprintf("only %d MiB free in %s, slow log is rotated at %d MiB",
+				free>>20, filepath.Dir(path), pc.DefaultMaxSlowLogSize>>20)
+			return []SlowLogProblem{problem}
+		}
+	}
+	return nil
+}
+
+// diskFree returns bytes available to unprivileged users on filesystem of the dir.
+var diskFree = func(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowLogProblems(t *testing.T) {
+	ready := map[string]string{
+		"version":         "8.0.36",
+		"slow_query_log":  "ON",
+		"log_output":      "FILE",
+		"long_query_time": "0.000000",
+	}
+	assert.Empty(t, slowLogProblems(ready))
+
+	problems := slowLogProblems(map[string]string{
+		"version":         "8.0.36",
+		"slow_query_log":  "OFF",
+		"log_output":      "TABLE",
+		"long_query_time": "10.000000",
+	})
+	var statements []string
+	for _, p := range problems {
+		statements = append(statements, p.Statement())
+	}
+	assert.Equal(t, []string{
+		"SET GLOBAL slow_query_log = 'ON'",
+		"SET GLOBAL log_output = 'FILE,TABLE'",
+		"SET GLOBAL long_query_time = 0",
+	}, statements)
+
+	// Percona Server.
+	problems = slowLogProblems(map[string]string{
+		"version":             "8.0.36-28",
+		"slow_query_log":      "ON",
+		"log_output":          "FILE",
+		"long_query_time":     "0.000000",
+		"log_slow_verbosity":  "microtime",
+		"log_slow_rate_limit": "100",
+		"log_slow_rate_type":  "session",
+	})
+	require.Len(t, problems, 2)
+	assert.Equal(t, "SET GLOBAL log_slow_verbosity = 'full'", problems[0].Statement())
+	assert.Equal(t, "SET GLOBAL log_slow_rate_type = 'query'", problems[1].Statement())
+
+	// MariaDB.
+	problems = slowLogProblems(map[string]string{
+		"version":             "10.6.16-MariaDB",
+		"slow_query_log":      "1",
+		"log_output":          "FILE",
+		"long_query_time":     "1.000000",
+		"log_slow_verbosity":  "query_plan,explain",
+		"log_slow_rate_limit": "10",
+	})
+	require.Len(t, problems, 1)
+	assert.Equal(t, "SET GLOBAL log_slow_rate_limit = 1", problems[0].Statement())
+}
+
+func TestSlowLogFileProblems(t *testing.T) {
+	defer func(f func(string) (uint64, error)) { diskFree = f }(diskFree)
+	free := uint64(10 << 30)
+	diskFree = func(string) (uint64, error) { return free, nil }
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "slow.log"), nil, 0644))
+
+	// Relative path is in datadir.
+	assert.Empty(t, slowLogFileProblems(map[string]string{
+		"datadir":             dir,
+		"slow_query_log":      "ON",
+		"slow_query_log_file": "slow.log",
+	}))
+
+	// Missing file is created by MySQL once slow log is enabled.
+	vars := map[string]string{
+		"slow_query_log":      "OFF",
+		"slow_query_log_file": filepath.Join(dir, "missing.log"),
+	}
+	assert.Empty(t, slowLogFileProblems(vars))
+	vars["slow_query_log"] = "ON"
+	problems := slowLogFileProblems(vars)
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0].Message, "does not exist on this host")
+	assert.Empty(t, problems[0].Recommended)
+
+	// Not enough disk for slow log to grow till rotation.
+	free = 100 << 20
+	problems = slowLogFileProblems(map[string]string{
+		"slow_query_log":      "ON",
+		"slow_query_log_file": filepath.Join(dir, "slow.log"),
+	})
+	require.Len(t, problems, 1)
+	assert.Equal(t, "only 100 MiB free in "+dir+", slow log is rotated at 1024 MiB", problems[0].Message)
+}
+
+func TestConfigureSlowLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("SET GLOBAL slow_query_log = 'ON'").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	applied, err := ConfigureSlowLog(ctx, db, []SlowLogProblem{
+		{Variable: "slow_query_log", Value: "OFF", Recommended: "ON"},
+		{Variable: "slow_query_log_file", Value: "slow.log"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SET GLOBAL slow_query_log = 'ON'"}, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
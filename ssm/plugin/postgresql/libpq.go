@@ -0,0 +1,170 @@
+package postgresql
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// serviceFiles returns connection service files in the order libpq looks them up.
+func serviceFiles() []string {
+	var files []string
+	if f := os.Getenv("PGSERVICEFILE"); f != "" {
+		files = append(files, f)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".pg_service.conf"))
+	}
+	if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+		files = append(files, filepath.Join(dir, "pg_service.conf"))
+	} else {
+		files = append(files, "/etc/pg_service.conf", "/etc/postgresql-common/pg_service.conf")
+	}
+	return files
+}
+
+// applyService fills options missing in DSN from the connection service in pg_service.conf.
+func applyService(dsn DSN, service string) (DSN, error) {
+	for _, file := range serviceFiles() {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		cfg, err := ini.Load(file)
+		if err != nil {
+			return dsn, fmt.Errorf("cannot read %s: %s", file, err)
+		}
+		section, err := cfg.GetSection(service)
+		if err != nil {
+			continue
+		}
+
+		fields := map[string]*string{
+			"user":             &dsn.User,
+			"password":         &dsn.Password,
+			"port":             &dsn.Port,
+			"sslmode":          &dsn.SSLMode,
+			"dbname":           &dsn.DBName,
+			"sslrootcert":      &dsn.SSLRootCert,
+			"sslcert":          &dsn.SSLCert,
+			"sslkey":           &dsn.SSLKey,
+			"connect_timeout":  &dsn.ConnectTimeout,
+			"application_name": &dsn.ApplicationName,
+		}
+		for key, field := range fields {
+			if *field == "" && section.HasKey(key) {
+				*field = section.Key(key).String()
+			}
+		}
+		if dsn.Host == "" && dsn.SocketDir == "" && section.HasKey("host") {
+			// Host starting with slash is unix socket directory.
+			if host := section.Key("host").String(); strings.HasPrefix(host, "/") {
+				dsn.SocketDir = host
+			} else {
+				dsn.Host = host
+			}
+		}
+		return dsn, nil
+	}
+
+	return dsn, fmt.Errorf("definition of service %q not found in %s", service, strings.Join(serviceFiles(), ", "))
+}
+
+// passFile returns path of the password file.
+func passFile() string {
+	if f := os.Getenv("PGPASSFILE"); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPassFile returns password for DSN from the password file, empty if there is no match.
+// The exporter runs as another user, so the password is looked up here and stored in its DSN.
+func lookupPassFile(dsn DSN) string {
+	file := passFile()
+	if file == "" {
+		return ""
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// libpq ignores password file readable by group or others.
+	if fi, err := f.Stat(); err != nil || fi.Mode().Perm()&0077 != 0 {
+		return ""
+	}
+
+	host := dsn.Host
+	if host == "" {
+		host = "localhost"
+	}
+	port := dsn.Port
+	if port == "" {
+		port = "5432"
+	}
+	dbName := dsn.DBName
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	username := dsn.User
+	if username == "" {
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPassFileLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, value := range []string{host, port, dbName, username} {
+			if fields[i] != "*" && fields[i] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return fields[4]
+		}
+	}
+	return ""
+}
+
+// splitPassFileLine splits hostname:port:database:username:password line,
+// colons and backslashes can be escaped with backslash.
+func splitPassFileLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package postgresql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-pgservice")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "pg_service.conf")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+[main]
+host=/var/run/postgresql
+port=5433
+dbname=app
+user=monitor
+sslmode=require
+`), 0600))
+	os.Setenv("PGSERVICEFILE", file)
+	defer os.Unsetenv("PGSERVICEFILE")
+
+	dsn, err := applyService(DSN{User: "ssm"}, "main")
+	require.NoError(t, err)
+	assert.Equal(t, DSN{User: "ssm", Port: "5433", DBName: "app", SSLMode: "require", SocketDir: "/var/run/postgresql"}, dsn)
+
+	_, err = applyService(DSN{}, "missing")
+	assert.Error(t, err)
+}
+
+func TestLookupPassFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-pgpass")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, ".pgpass")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`# comment
+db1:5432:*:ssm:first
+*:*:app:ssm:sec\:ond
+`), 0600))
+	os.Setenv("PGPASSFILE", file)
+	defer os.Unsetenv("PGPASSFILE")
+
+	assert.Equal(t, "first", lookupPassFile(DSN{User: "ssm", Host: "db1"}))
+	assert.Equal(t, "sec:ond", lookupPassFile(DSN{User: "ssm", Host: "db2", DBName: "app"}))
+	assert.Equal(t, "", lookupPassFile(DSN{User: "other", Host: "db1"}))
+
+	// Password file readable by others is ignored.
+	require.NoError(t, os.Chmod(file, 0644))
+	assert.Equal(t, "", lookupPassFile(DSN{User: "ssm", Host: "db1"}))
+}
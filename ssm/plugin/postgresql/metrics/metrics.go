@@ -13,13 +13,17 @@ import (
 	"gopkg.in/ini.v1"
 )
 
-var _ plugin.Metrics = (*Metrics)(nil)
+var (
+	_ plugin.Metrics       = (*Metrics)(nil)
+	_ plugin.MultiInstance = (*Metrics)(nil)
+)
 
 // New returns *Metrics.
 func New(flags postgresql.Flags, ssmBaseDir string) *Metrics {
 	return &Metrics{
 		postgresqlFlags: flags,
 		ssmBaseDir:      ssmBaseDir,
+		cfgPath:         path.Join(ssmBaseDir, plugin.ConfigFile(plugin.PostgreSQLExporter, "")),
 	}
 }
 
@@ -29,6 +33,7 @@ type Metrics struct {
 	ssmBaseDir      string
 	dsn             string
 	port            int
+	cfgPath         string
 }
 
 // Init initializes plugin.
@@ -49,8 +54,7 @@ func (m *Metrics) Init(
 	}
 	m.dsn = info.DSN
 
-	cfgPath := path.Join(m.ssmBaseDir, "postgres_exporter.conf")
-	cfgFile, err := ini.Load(cfgPath)
+	cfgFile, err := ini.Load(m.cfgPath)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +74,7 @@ func (m *Metrics) Init(
 	cfgFile.Section("web").Key("auth-file").SetValue(authFile)
 	cfgFile.Section("web").Key("ssl-key-file").SetValue(sslKeyFile)
 	cfgFile.Section("web").Key("ssl-cert-file").SetValue(sslCertFile)
-	err = cfgFile.SaveTo(cfgPath)
+	err = cfgFile.SaveTo(m.cfgPath)
 	if err != nil {
 		return nil, err
 	}
@@ -78,6 +82,11 @@ func (m *Metrics) Init(
 	return info, nil
 }
 
+// SetInstance makes plugin use the exporter config of the given instance.
+func (m *Metrics) SetInstance(instance string) {
+	m.cfgPath = path.Join(m.ssmBaseDir, plugin.ConfigFile(plugin.PostgreSQLExporter, instance))
+}
+
 // Name of the exporter.
 func (m Metrics) Name() string {
 	return plugin.NamePostgreSQL
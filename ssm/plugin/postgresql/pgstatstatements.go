@@ -0,0 +1,62 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// CheckPgStatStatements verifies pg_stat_statements is preloaded and created in the database of DSN.
+// If create is set, missing extension is created.
+func CheckPgStatStatements(ctx context.Context, dsn string, create bool) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return checkPgStatStatements(ctx, db, create)
+}
+
+func checkPgStatStatements(ctx context.Context, db *sql.DB, create bool) error {
+	var libraries string
+	err := db.QueryRowContext(ctx, "SELECT setting FROM pg_settings WHERE name = 'shared_preload_libraries'").Scan(&libraries)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	preloaded := false
+	for _, library := range strings.Split(libraries, ",") {
+		if strings.TrimSpace(library) == "pg_stat_statements" {
+			preloaded = true
+			break
+		}
+	}
+	if !preloaded {
+		return fmt.Errorf("pg_stat_statements is not loaded.\n\n%s\n%s",
+			"Add pg_stat_statements to shared_preload_libraries in postgresql.conf and restart PostgreSQL:",
+			"  shared_preload_libraries = 'pg_stat_statements'")
+	}
+
+	var version string
+	err = db.QueryRowContext(ctx, "SELECT extversion FROM pg_extension WHERE extname = 'pg_stat_statements'").Scan(&version)
+	switch {
+	case err == sql.ErrNoRows && create:
+		if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS pg_stat_statements"); err != nil {
+			return fmt.Errorf("cannot create pg_stat_statements extension: %s\n\n%s", err,
+				"Run 'CREATE EXTENSION pg_stat_statements' as superuser in the monitored database.")
+		}
+	case err == sql.ErrNoRows:
+		return fmt.Errorf("pg_stat_statements extension is not created.\n\n%s",
+			"Use --create-extension flag or run 'CREATE EXTENSION pg_stat_statements' as superuser in the monitored database.")
+	case err != nil:
+		return err
+	}
+
+	// Check the view is readable by the user.
+	if _, err := db.ExecContext(ctx, "SELECT 1 FROM pg_stat_statements LIMIT 1"); err != nil {
+		return fmt.Errorf("cannot read pg_stat_statements: %s", err)
+	}
+
+	return nil
+}
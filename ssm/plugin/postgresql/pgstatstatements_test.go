@@ -0,0 +1,85 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package postgresql
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPgStatStatements(t *testing.T) {
+	preloadQuery := regexp.QuoteMeta("SELECT setting FROM pg_settings WHERE name = 'shared_preload_libraries'")
+	extensionQuery := regexp.QuoteMeta("SELECT extversion FROM pg_extension WHERE extname = 'pg_stat_statements'")
+	createQuery := regexp.QuoteMeta("CREATE EXTENSION IF NOT EXISTS pg_stat_statements")
+	readQuery := regexp.QuoteMeta("SELECT 1 FROM pg_stat_statements LIMIT 1")
+
+	t.Run("NotPreloaded", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery(preloadQuery).WillReturnRows(sqlmock.NewRows([]string{"setting"}).AddRow("auto_explain"))
+		err = checkPgStatStatements(context.Background(), db, true)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "shared_preload_libraries")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("NotCreated", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery(preloadQuery).WillReturnRows(sqlmock.NewRows([]string{"setting"}).AddRow("auto_explain, pg_stat_statements"))
+		mock.ExpectQuery(extensionQuery).WillReturnRows(sqlmock.NewRows([]string{"extversion"}))
+		err = checkPgStatStatements(context.Background(), db, false)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--create-extension")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery(preloadQuery).WillReturnRows(sqlmock.NewRows([]string{"setting"}).AddRow("pg_stat_statements"))
+		mock.ExpectQuery(extensionQuery).WillReturnRows(sqlmock.NewRows([]string{"extversion"}))
+		mock.ExpectExec(createQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(readQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.NoError(t, checkPgStatStatements(context.Background(), db, true))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery(preloadQuery).WillReturnRows(sqlmock.NewRows([]string{"setting"}).AddRow("pg_stat_statements"))
+		mock.ExpectQuery(extensionQuery).WillReturnRows(sqlmock.NewRows([]string{"extversion"}).AddRow("1.9"))
+		mock.ExpectExec(readQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+		assert.NoError(t, checkPgStatStatements(context.Background(), db, false))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
 	"os/exec"
 	"regexp"
 	"strings"
@@ -25,6 +26,7 @@ var (
 // Flags are PostgreSQL specific flags.
 type Flags struct {
 	DSN
+	Service            string
 	CreateUser         bool
 	CreateUserPassword string
 	Force              bool
@@ -37,6 +39,14 @@ type DSN struct {
 	Host     string
 	Port     string
 	SSLMode  string
+
+	DBName          string
+	SocketDir       string
+	SSLRootCert     string
+	SSLCert         string
+	SSLKey          string
+	ConnectTimeout  string
+	ApplicationName string
 }
 
 // String converts DSN struct to DSN string.
@@ -45,19 +55,13 @@ func (d DSN) String() string {
 
 	buf.WriteString("postgresql://")
 
-	// [username]
-	if len(d.User) > 0 {
-		buf.WriteString(d.User)
-	}
-
-	// [:password]
-	if len(d.Password) > 0 {
-		buf.WriteByte(':')
-		buf.WriteString(d.Password)
-	}
-
-	// @ is required if User or Password is set.
+	// [username][:password]@
 	if len(d.User) > 0 || len(d.Password) > 0 {
+		if len(d.Password) > 0 {
+			buf.WriteString(url.UserPassword(d.User, d.Password).String())
+		} else {
+			buf.WriteString(url.User(d.User).String())
+		}
 		buf.WriteByte('@')
 	}
 
@@ -72,13 +76,35 @@ func (d DSN) String() string {
 		buf.WriteString(d.Port)
 	}
 
-	buf.WriteString("/postgres")
+	dbName := d.DBName
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	buf.WriteByte('/')
+	buf.WriteString(url.PathEscape(dbName))
+
 	buf.WriteString("?sslmode=")
 	if d.SSLMode == "" {
 		d.SSLMode = "disable"
 	}
 	buf.WriteString(d.SSLMode)
 
+	// Unix socket directory is passed as host parameter, as libpq does.
+	params := []struct{ key, value string }{
+		{"host", d.SocketDir},
+		{"sslrootcert", d.SSLRootCert},
+		{"sslcert", d.SSLCert},
+		{"sslkey", d.SSLKey},
+		{"connect_timeout", d.ConnectTimeout},
+		{"application_name", d.ApplicationName},
+	}
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		buf.WriteString("&" + p.key + "=" + url.QueryEscape(p.value))
+	}
+
 	return buf.String()
 }
 
@@ -89,7 +115,21 @@ func Init(ctx context.Context, flags Flags, ssmUserPassword string) (*plugin.Inf
 		return nil, errors.New("flag --create-user-password should be used along with --create-user")
 	}
 
+	if flags.Host != "" && flags.SocketDir != "" {
+		return nil, errors.New("flags --socket and --host are mutually exclusive")
+	}
+
+	// Fill missing options from pg_service.conf and password from ~/.pgpass.
 	userDSN := flags.DSN
+	if flags.Service != "" {
+		var err error
+		if userDSN, err = applyService(userDSN, flags.Service); err != nil {
+			return nil, err
+		}
+	}
+	if userDSN.Password == "" {
+		userDSN.Password = lookupPassFile(userDSN)
+	}
 
 	var errs errs
 
@@ -0,0 +1,74 @@
+package queries
+
+import (
+	"context"
+
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin/postgresql"
+	pc "github.com/shatteredsilicon/ssm/proto/config"
+)
+
+var _ plugin.Queries = (*Queries)(nil)
+
+// Flags are PostgreSQL Queries specific flags.
+type Flags struct {
+	CreateExtension bool
+}
+
+// New returns *Queries.
+func New(queriesFlags plugin.QueriesFlags, flags Flags, postgresqlFlags postgresql.Flags) *Queries {
+	return &Queries{
+		queriesFlags:    queriesFlags,
+		flags:           flags,
+		postgresqlFlags: postgresqlFlags,
+	}
+}
+
+// Queries implements plugin.Queries.
+type Queries struct {
+	queriesFlags    plugin.QueriesFlags
+	flags           Flags
+	postgresqlFlags postgresql.Flags
+
+	dsn string
+}
+
+// Init initializes plugin.
+func (q *Queries) Init(ctx context.Context, ssmUserPassword string, info *plugin.Info) (*plugin.Info, error) {
+	var err error
+
+	if info == nil {
+		info, err = postgresql.Init(ctx, q.postgresqlFlags, ssmUserPassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := postgresql.CheckPgStatStatements(ctx, info.DSN, q.flags.CreateExtension); err != nil {
+		return nil, err
+	}
+
+	info.QuerySource = "pg_stat_statements"
+	q.dsn = info.DSN
+	return info, nil
+}
+
+// Name of the service.
+func (q Queries) Name() string {
+	return plugin.NamePostgreSQL
+}
+
+// InstanceTypeName of the service.
+func (q Queries) InstanceTypeName() string {
+	return q.Name()
+}
+
+// Config returns pc.QAN.
+func (q Queries) Config() pc.QAN {
+	exampleQueries := !q.queriesFlags.DisableQueryExamples
+	return pc.QAN{
+		CollectFrom:    "pg_stat_statements",
+		Interval:       60,
+		ExampleQueries: &exampleQueries,
+	}
+}
@@ -2,33 +2,38 @@ package metrics
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"path"
 	"strconv"
 	"strings"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin/proxysql"
 	"github.com/shatteredsilicon/ssm-client/ssm/utils"
 	"gopkg.in/ini.v1"
 )
 
-var _ plugin.Metrics = (*Metrics)(nil)
+var (
+	_ plugin.Metrics       = (*Metrics)(nil)
+	_ plugin.MultiInstance = (*Metrics)(nil)
+)
 
 // New returns *Metrics.
-func New(dsn, ssmBaseDir string) *Metrics {
+func New(flags proxysql.Flags, ssmBaseDir string) *Metrics {
 	return &Metrics{
-		dsn:        dsn,
+		flags:      flags,
 		ssmBaseDir: ssmBaseDir,
+		cfgPath:    path.Join(ssmBaseDir, plugin.ConfigFile(plugin.ProxySQLExporter, "")),
 	}
 }
 
 // Metrics implements plugin.Metrics.
 type Metrics struct {
 	ssmBaseDir string
+	flags      proxysql.Flags
 	dsn        string
 	port       int
+	cfgPath    string
 }
 
 // Init initializes plugin.
@@ -40,21 +45,13 @@ func (m *Metrics) Init(
 	sslKeyFile string,
 	sslCertFile string,
 ) (*plugin.Info, error) {
-	dsn, err := mysql.ParseDSN(m.dsn)
+	info, err := proxysql.Init(ctx, m.flags)
 	if err != nil {
-		return nil, fmt.Errorf("Bad dsn %s: %s", m.dsn, err)
-	}
-
-	if err := testConnection(ctx, dsn.FormatDSN()); err != nil {
-		return nil, fmt.Errorf("Cannot connect to ProxySQL using DSN %s: %s", m.dsn, err)
-	}
-
-	info := &plugin.Info{
-		DSN: m.dsn,
+		return nil, err
 	}
+	m.dsn = info.DSN
 
-	cfgPath := path.Join(m.ssmBaseDir, "proxysql_exporter.conf")
-	cfgFile, err := ini.Load(cfgPath)
+	cfgFile, err := ini.Load(m.cfgPath)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +71,7 @@ func (m *Metrics) Init(
 	cfgFile.Section("web").Key("auth-file").SetValue(authFile)
 	cfgFile.Section("web").Key("ssl-key-file").SetValue(sslKeyFile)
 	cfgFile.Section("web").Key("ssl-cert-file").SetValue(sslCertFile)
-	err = cfgFile.SaveTo(cfgPath)
+	err = cfgFile.SaveTo(m.cfgPath)
 	if err != nil {
 		return nil, err
 	}
@@ -82,6 +79,11 @@ func (m *Metrics) Init(
 	return info, nil
 }
 
+// SetInstance makes plugin use the exporter config of the given instance.
+func (m *Metrics) SetInstance(instance string) {
+	m.cfgPath = path.Join(m.ssmBaseDir, plugin.ConfigFile(plugin.ProxySQLExporter, instance))
+}
+
 // Name of the exporter.
 func (Metrics) Name() string {
 	return plugin.NameProxySQL
@@ -113,17 +115,3 @@ func (Metrics) Cluster() string {
 func (m Metrics) CustomOptions() (map[string]string, error) {
 	return nil, nil
 }
-
-func testConnection(ctx context.Context, dsn string) error {
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	if err = db.PingContext(ctx); err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,198 @@
+package proxysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
+)
+
+// Flags are ProxySQL specific flags.
+type Flags struct {
+	DSN                string
+	CreateUser         bool
+	CreateUserPassword string
+}
+
+// Backend is a MySQL server configured in ProxySQL.
+type Backend struct {
+	Host       string
+	Port       int
+	Hostgroups []int
+	Role       string
+	Status     string
+}
+
+// Name returns name the backend is added to monitoring under.
+func (b Backend) Name() string {
+	return fmt.Sprintf("%s:%d", b.Host, b.Port)
+}
+
+// Tags returns Consul tags describing hostgroups of the backend.
+func (b Backend) Tags() []string {
+	var tags []string
+	for _, hg := range b.Hostgroups {
+		tags = append(tags, fmt.Sprintf("hostgroup_%d", hg))
+	}
+	if b.Role != "" {
+		tags = append(tags, fmt.Sprintf("role_%s", b.Role))
+	}
+	return tags
+}
+
+// Init verifies connection to ProxySQL admin interface and creates a new read-only admin user if requested.
+func Init(ctx context.Context, flags Flags) (*plugin.Info, error) {
+	if !flags.CreateUser && flags.CreateUserPassword != "" {
+		return nil, errors.New("flag --create-user-password should be used along with --create-user")
+	}
+
+	cfg, err := mysql.ParseDSN(flags.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("Bad dsn %s: %s", flags.DSN, err)
+	}
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if err = db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("Cannot connect to ProxySQL using DSN %s: %s", utils.SanitizeDSN(flags.DSN), err)
+	}
+
+	if flags.CreateUser {
+		password := flags.CreateUserPassword
+		if password == "" {
+			password = utils.GeneratePassword(20)
+		}
+		if err := createUser(ctx, db, plugin.SSMUsername, password); err != nil {
+			return nil, err
+		}
+		cfg.User = plugin.SSMUsername
+		cfg.Passwd = password
+	}
+
+	info := &plugin.Info{
+		DSN: cfg.FormatDSN(),
+	}
+	return info, nil
+}
+
+// createUser adds user to admin-stats_credentials, users listed there have read-only access to admin interface.
+func createUser(ctx context.Context, db *sql.DB, username, password string) error {
+	if strings.ContainsAny(password, ":;'") {
+		return errors.New("password of ProxySQL user can't contain ':', ';' or quote characters")
+	}
+
+	var credentials string
+	err := db.QueryRowContext(ctx, "SELECT variable_value FROM global_variables WHERE variable_name = 'admin-stats_credentials'").Scan(&credentials)
+	if err != nil {
+		return fmt.Errorf("cannot read admin-stats_credentials, creating ProxySQL user requires admin credentials in --dsn: %s", err)
+	}
+
+	// ProxySQL admin interface does not support prepared statements.
+	queries := []string{
+		fmt.Sprintf("UPDATE global_variables SET variable_value = '%s' WHERE variable_name = 'admin-stats_credentials'",
+			setCredentials(credentials, username, password)),
+		"LOAD ADMIN VARIABLES TO RUNTIME",
+		"SAVE ADMIN VARIABLES TO DISK",
+	}
+	for _, q := range queries {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return fmt.Errorf("cannot create ProxySQL user: %s", err)
+		}
+	}
+	return nil
+}
+
+// setCredentials adds or replaces user in the "user:password;user:password" list.
+func setCredentials(credentials, username, password string) string {
+	list := []string{}
+	for _, c := range strings.Split(credentials, ";") {
+		if c == "" || strings.SplitN(c, ":", 2)[0] == username {
+			continue
+		}
+		list = append(list, c)
+	}
+	list = append(list, username+":"+password)
+	return strings.Join(list, ";")
+}
+
+// Backends returns MySQL servers configured in ProxySQL, reading them requires admin credentials.
+func Backends(ctx context.Context, dsn string) ([]Backend, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return getBackends(ctx, db)
+}
+
+func getBackends(ctx context.Context, db *sql.DB) ([]Backend, error) {
+	roles := map[int]string{}
+	rows, err := db.QueryContext(ctx, "SELECT writer_hostgroup, reader_hostgroup FROM mysql_replication_hostgroups")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read mysql_replication_hostgroups: %s", err)
+	}
+	for rows.Next() {
+		var writer, reader int
+		if err := rows.Scan(&writer, &reader); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles[writer] = "writer"
+		roles[reader] = "reader"
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT hostgroup_id, hostname, port, status FROM runtime_mysql_servers")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read runtime_mysql_servers: %s", err)
+	}
+	defer rows.Close()
+
+	backends := map[string]*Backend{}
+	for rows.Next() {
+		var hostgroup, port int
+		var host, status string
+		if err := rows.Scan(&hostgroup, &host, &port, &status); err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s:%d", host, port)
+		b, ok := backends[key]
+		if !ok {
+			b = &Backend{Host: host, Port: port, Status: status}
+			backends[key] = b
+		}
+		b.Hostgroups = append(b.Hostgroups, hostgroup)
+		// Server which is writer in any hostgroup is a writer.
+		if role := roles[hostgroup]; role == "writer" || b.Role == "" {
+			b.Role = role
+		}
+		if status != "ONLINE" {
+			b.Status = status
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var list []Backend
+	for _, b := range backends {
+		sort.Ints(b.Hostgroups)
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
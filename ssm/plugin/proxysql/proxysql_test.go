@@ -0,0 +1,60 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package proxysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCredentials(t *testing.T) {
+	assert.Equal(t, "ssm:abc", setCredentials("", "ssm", "abc"))
+	assert.Equal(t, "stats:stats;ssm:abc", setCredentials("stats:stats", "ssm", "abc"))
+	assert.Equal(t, "stats:stats;other:x;ssm:new", setCredentials("stats:stats;ssm:old;other:x", "ssm", "new"))
+}
+
+func TestGetBackends(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT writer_hostgroup, reader_hostgroup FROM mysql_replication_hostgroups").
+		WillReturnRows(sqlmock.NewRows([]string{"writer_hostgroup", "reader_hostgroup"}).AddRow(10, 20))
+	mock.ExpectQuery("SELECT hostgroup_id, hostname, port, status FROM runtime_mysql_servers").
+		WillReturnRows(sqlmock.NewRows([]string{"hostgroup_id", "hostname", "port", "status"}).
+			AddRow(20, "db1", 3306, "ONLINE").
+			AddRow(10, "db1", 3306, "ONLINE").
+			AddRow(20, "db2", 3306, "SHUNNED").
+			AddRow(30, "db3", 3307, "ONLINE"))
+
+	backends, err := getBackends(context.Background(), db)
+	require.NoError(t, err)
+	expected := []Backend{
+		{Host: "db1", Port: 3306, Hostgroups: []int{10, 20}, Role: "writer", Status: "ONLINE"},
+		{Host: "db2", Port: 3306, Hostgroups: []int{20}, Role: "reader", Status: "SHUNNED"},
+		{Host: "db3", Port: 3307, Hostgroups: []int{30}, Status: "ONLINE"},
+	}
+	assert.Equal(t, expected, backends)
+	assert.Equal(t, []string{"hostgroup_10", "hostgroup_20", "role_writer"}, backends[0].Tags())
+	assert.Equal(t, "db3:3307", backends[2].Name())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,367 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/cli/templates"
+	consul "github.com/hashicorp/consul/api"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Exporter ports are assigned by ssm-admin from the configured range
+// and kept in the port registry file, keyed by Consul service ID.
+
+// PortRegistry is the content of the port registry file.
+type PortRegistry struct {
+	Ports map[string]int `yaml:"ports"`
+}
+
+// PortStatus is a row of ports table.
+type PortStatus struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Port       int    `json:"port"`
+	ConsulPort int    `json:"consul_port"`
+	Listening  bool   `json:"listening"`
+}
+
+// parsePortRange parses port range in "min-max" format.
+func parsePortRange(s string) (int, int, error) {
+	if s == "" {
+		s = defaultExporterPortRange
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q, expected min-max.", s)
+	}
+	min, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	max, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q, expected min-max.", s)
+	}
+	return min, max, nil
+}
+
+// loadPortRegistry reads the port registry file, missing file means empty registry.
+func loadPortRegistry() (*PortRegistry, error) {
+	r := &PortRegistry{Ports: map[string]int{}}
+	bytes, err := ioutil.ReadFile(PortsFile)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(bytes, r); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", PortsFile, err)
+	}
+	if r.Ports == nil {
+		r.Ports = map[string]int{}
+	}
+	return r, nil
+}
+
+// save writes the port registry file.
+func (r *PortRegistry) save() error {
+	bytes, _ := yaml.Marshal(r)
+	return ioutil.WriteFile(PortsFile, bytes, 0600)
+}
+
+// owner returns ID of the service the port is assigned to.
+func (r *PortRegistry) owner(port int) string {
+	for id, p := range r.Ports {
+		if p == port {
+			return id
+		}
+	}
+	return ""
+}
+
+// portIsFree checks nothing is listening on the port of bind address.
+func portIsFree(bindAddress string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// allocatePort picks a free port from the configured range, preferring the given one.
+func (a *Admin) allocatePort(r *PortRegistry, preferred int) (int, error) {
+	min, max, err := parsePortRange(a.Config.ExporterPortRange)
+	if err != nil {
+		return 0, err
+	}
+	if preferred >= min && preferred <= max && r.owner(preferred) == "" && portIsFree(a.Config.BindAddress, preferred) {
+		return preferred, nil
+	}
+	for port := min; port <= max; port++ {
+		if r.owner(port) == "" && portIsFree(a.Config.BindAddress, port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port left in range %d-%d on %s.", min, max, a.Config.BindAddress)
+}
+
+// exporterPort returns port from web.listen-address of exporter config, 0 if it is not valid.
+func exporterPort(cfgFile *ini.File) int {
+	_, portStr, err := net.SplitHostPort(cfgFile.Section("web").Key("listen-address").Value())
+	if err != nil {
+		return 0
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return 0
+	}
+	return port
+}
+
+// assignExporterPort assigns port to the exporter instance and writes it into exporter config.
+// The port already known by registry or Consul (registered) or used by running exporter is kept.
+func (a *Admin) assignExporterPort(serviceType, instance string, registered int) (int, error) {
+	r, err := loadPortRegistry()
+	if err != nil {
+		return 0, err
+	}
+	cfgPath := instanceConfigPath(serviceType, instance)
+	cfgFile, err := ini.Load(cfgPath)
+	if err != nil {
+		return 0, err
+	}
+
+	id := serviceID(serviceType, instance)
+	current := exporterPort(cfgFile)
+	port, ok := r.Ports[id]
+	switch {
+	case ok:
+	case registered > 0:
+		port = registered
+	case current > 0 && getServiceStatus(instanceServiceName(serviceType, instance)):
+		port = current
+	default:
+		if port, err = a.allocatePort(r, current); err != nil {
+			return 0, err
+		}
+	}
+
+	if port != current {
+		if err := setExporterPort(cfgFile, cfgPath, a.Config.BindAddress, port); err != nil {
+			return 0, err
+		}
+	}
+	if r.Ports[id] != port {
+		r.Ports[id] = port
+		if err := r.save(); err != nil {
+			return 0, err
+		}
+	}
+	return port, nil
+}
+
+// setExporterPort rewrites web.listen-address of exporter config.
+func setExporterPort(cfgFile *ini.File, cfgPath, bindAddress string, port int) error {
+	cfgFile.Section("web").Key("listen-address").SetValue(net.JoinHostPort(bindAddress, strconv.Itoa(port)))
+	return cfgFile.SaveTo(cfgPath)
+}
+
+// releaseExporterPort removes port assignment of the exporter instance from registry.
+func releaseExporterPort(serviceType, instance string) error {
+	r, err := loadPortRegistry()
+	if err != nil {
+		return err
+	}
+	id := serviceID(serviceType, instance)
+	if _, ok := r.Ports[id]; !ok {
+		return nil
+	}
+	delete(r.Ports, id)
+	return r.save()
+}
+
+// Ports returns port assignments of metrics services.
+func (a *Admin) Ports() ([]PortStatus, error) {
+	r, err := loadPortRegistry()
+	if err != nil {
+		return nil, err
+	}
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[string]*PortStatus{}
+	for id, port := range r.Ports {
+		svcType := id
+		if i := strings.Index(id, "@"); i != -1 {
+			svcType = id[:i]
+		}
+		rows[id] = &PortStatus{ID: id, Type: svcType, Name: "-", Port: port}
+	}
+	if node != nil {
+		for _, svc := range node.Services {
+			if _, ok := serviceExporters[svc.Service]; !ok {
+				continue
+			}
+			row, ok := rows[svc.ID]
+			if !ok {
+				row = &PortStatus{ID: svc.ID, Type: svc.Service, Name: "-"}
+				rows[svc.ID] = row
+			}
+			row.ConsulPort = svc.Port
+			for _, tag := range svc.Tags {
+				if strings.HasPrefix(tag, "alias_") {
+					row.Name = tag[6:]
+				}
+			}
+		}
+	}
+
+	var ports []PortStatus
+	for _, row := range rows {
+		port := row.Port
+		if port == 0 {
+			port = row.ConsulPort
+		}
+		row.Listening = port > 0 && !portIsFree(a.Config.BindAddress, port)
+		ports = append(ports, *row)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].ID < ports[j].ID })
+	return ports, nil
+}
+
+// PrintPorts prints port assignments of metrics services.
+func (a *Admin) PrintPorts(ports []PortStatus) {
+	if a.Format != "" {
+		tmpl, err := templates.Parse(a.Format)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := tmpl.Execute(os.Stdout, ports); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println()
+		return
+	}
+	if len(ports) == 0 {
+		fmt.Println("No metrics services are assigned ports.")
+		return
+	}
+	min, max, _ := parsePortRange(a.Config.ExporterPortRange)
+	fmt.Printf("Port range: %d-%d\n\n", min, max)
+
+	linefmt := "%-25s %-20s %-7s %-12s %-9s\n"
+	fmt.Printf(linefmt, "SERVICE TYPE", "NAME", "PORT", "CONSUL PORT", "LISTENING")
+	fmt.Printf(linefmt, strings.Repeat("-", 25), strings.Repeat("-", 20), strings.Repeat("-", 7), strings.Repeat("-", 12), strings.Repeat("-", 9))
+	for _, p := range ports {
+		port, consulPort := "-", "-"
+		if p.Port > 0 {
+			port = strconv.Itoa(p.Port)
+		}
+		if p.ConsulPort > 0 {
+			consulPort = strconv.Itoa(p.ConsulPort)
+		}
+		listening := "NO"
+		if p.Listening {
+			listening = "YES"
+		}
+		fmt.Printf(linefmt, p.Type, p.Name, port, consulPort, listening)
+	}
+}
+
+// ReassignPort moves metrics service to another port, or to a free one from the range if port is 0.
+// Exporter config, port registry and Consul registration are updated and the exporter is restarted.
+func (a *Admin) ReassignPort(serviceType string, port int) (int, int, error) {
+	if _, ok := serviceExporters[serviceType]; !ok {
+		return 0, 0, fmt.Errorf("%s is not a metrics service type.", serviceType)
+	}
+	if port < 0 || port > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %d.", port)
+	}
+	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if consulSvc == nil {
+		return 0, 0, ErrNoService
+	}
+	instance := serviceInstance(consulSvc.ID)
+
+	r, err := loadPortRegistry()
+	if err != nil {
+		return 0, 0, err
+	}
+	oldPort, ok := r.Ports[consulSvc.ID]
+	if !ok {
+		oldPort = consulSvc.Port
+	}
+
+	if port == 0 {
+		if port, err = a.allocatePort(r, 0); err != nil {
+			return 0, 0, err
+		}
+	} else {
+		if owner := r.owner(port); owner != "" && owner != consulSvc.ID {
+			return 0, 0, fmt.Errorf("port %d is already assigned to %s.", port, owner)
+		}
+		if port != oldPort && !portIsFree(a.Config.BindAddress, port) {
+			return 0, 0, fmt.Errorf("port %d is already in use on %s.", port, a.Config.BindAddress)
+		}
+	}
+
+	cfgPath := instanceConfigPath(serviceType, instance)
+	cfgFile, err := ini.Load(cfgPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := setExporterPort(cfgFile, cfgPath, a.Config.BindAddress, port); err != nil {
+		return 0, 0, err
+	}
+	r.Ports[consulSvc.ID] = port
+	if err := r.save(); err != nil {
+		return 0, 0, err
+	}
+
+	// Re-register service on Consul with the new port.
+	consulSvc.Port = port
+	reg := consul.CatalogRegistration{
+		Node:    a.Config.ClientName,
+		Address: a.Config.ClientAddress,
+		Service: consulSvc,
+	}
+	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+		return 0, 0, err
+	}
+
+	if err := restartService(instanceServiceName(serviceType, instance)); err != nil {
+		return 0, 0, err
+	}
+
+	return oldPort, port, nil
+}
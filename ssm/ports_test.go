@@ -0,0 +1,58 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestParsePortRange(t *testing.T) {
+	min, max, err := parsePortRange("")
+	assert.NoError(t, err)
+	assert.Equal(t, 42000, min)
+	assert.Equal(t, 42999, max)
+
+	min, max, err = parsePortRange("43000 - 43010")
+	assert.NoError(t, err)
+	assert.Equal(t, 43000, min)
+	assert.Equal(t, 43010, max)
+
+	for _, s := range []string{"43000", "43010-43000", "0-100", "42000-70000", "a-b"} {
+		_, _, err = parsePortRange(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestExporterPort(t *testing.T) {
+	cfg, err := ini.Load([]byte("[web]\nlisten-address = 127.0.0.1:42002\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 42002, exporterPort(cfg))
+
+	cfg, err = ini.Load([]byte("[web]\nlisten-address = 42002\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exporterPort(cfg))
+}
+
+func TestPortRegistryOwner(t *testing.T) {
+	r := &PortRegistry{Ports: map[string]int{"mysql:metrics": 42002, "mysql:metrics@db2": 42006}}
+	assert.Equal(t, "mysql:metrics@db2", r.owner(42006))
+	assert.Equal(t, "", r.owner(42003))
+}
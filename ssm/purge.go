@@ -0,0 +1,211 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm/proto"
+)
+
+// ExternalMetricsType is the service type to purge data of external Prometheus exporter jobs with.
+const ExternalMetricsType = "external:metrics"
+
+// qanSubsystems are QAN instance types of metrics service types.
+var qanSubsystems = map[string]string{
+	"mysql":      "mysql",
+	"mongodb":    "mongo",
+	"postgresql": "postgresql",
+}
+
+// PurgeOptions limit data purge deletes.
+type PurgeOptions struct {
+	// Start and End limit time range of data, zero for unbounded.
+	Start time.Time
+	End   time.Time
+	// Instance limits purge of external metrics job to the instance.
+	Instance string
+	// DryRun counts matching series without deleting anything.
+	DryRun bool
+	// QAN purges Query Analytics data of the service too.
+	QAN bool
+}
+
+// PurgeResult is the outcome of purge.
+type PurgeResult struct {
+	// Match is Prometheus series selector of the purged data.
+	Match string `json:"match"`
+	// Series is the number of series having data in the time range.
+	Series int `json:"series"`
+	// QANInstance is UUID of QAN instance data is purged of, empty if there is none.
+	QANInstance string `json:"qan_instance,omitempty"`
+}
+
+// purgeMatch returns Prometheus series selector of the service data.
+func purgeMatch(svcType, name, instance string) string {
+	if svcType == ExternalMetricsType {
+		if instance == "" {
+			return fmt.Sprintf(`{job=%q}`, name)
+		}
+		return fmt.Sprintf(`{job=%q,instance=%q}`, name, instance)
+	}
+	return fmt.Sprintf(`{job=%q,instance=%q}`, strings.Split(svcType, ":")[0], name)
+}
+
+// PurgeMetrics purge metrics data on the server by its metric type and name.
+// For external metrics, name is the job name.
+func (a *Admin) PurgeMetrics(svcType string, opts PurgeOptions) (*PurgeResult, error) {
+	if svcType != ExternalMetricsType && (isValidSvcType(svcType) != nil || !strings.HasSuffix(svcType, plugin.TypeMetrics)) {
+		return nil, errors.New(`bad service type.
+
+Service type takes the following values: linux:metrics, mysql:metrics, mongodb:metrics, proxysql:metrics, postgresql:metrics, external:metrics.`)
+	}
+	if opts.Instance != "" && svcType != ExternalMetricsType {
+		return nil, errors.New("instance can be given for external:metrics only.")
+	}
+	if !opts.Start.IsZero() && !opts.End.IsZero() && opts.End.Before(opts.Start) {
+		return nil, errors.New("end time can't be before start time.")
+	}
+	subsystem := qanSubsystems[strings.Split(svcType, ":")[0]]
+	if opts.QAN && subsystem == "" {
+		return nil, errors.New("Query Analytics data can be purged for mysql:metrics, mongodb:metrics and postgresql:metrics only.")
+	}
+
+	res := &PurgeResult{Match: purgeMatch(svcType, a.ServiceName, opts.Instance)}
+	var err error
+	if res.Series, err = a.matchingSeries(res.Match, opts.Start, opts.End); err != nil {
+		return nil, err
+	}
+	if opts.QAN {
+		in, err := a.findQANInstance(subsystem, a.ServiceName)
+		if err != nil && err != errNoInstance {
+			return nil, err
+		}
+		res.QANInstance = in.UUID
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+
+	var promError error
+
+	// Delete series in Prometheus v2.
+	query := timeRangeQuery(opts.Start, opts.End, "start", "end")
+	query.Set("match[]", res.Match)
+	url := a.qanAPI.URL(a.serverURL, "prometheus/api/v1/admin/tsdb/delete_series?"+query.Encode())
+	resp, _, err := a.qanAPI.Post(url, []byte{})
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		promError = fmt.Errorf("%v:%v resp: %v", promError, err, resp)
+	}
+
+	// Clean tombstones in Prometheus v2.
+	url = a.qanAPI.URL(a.serverURL, "prometheus/api/v1/admin/tsdb/clean_tombstones")
+	resp, _, err = a.qanAPI.Post(url, []byte{})
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		promError = fmt.Errorf("%v:%v resp: %v", promError, err, resp)
+	}
+	if promError != nil {
+		return res, promError
+	}
+
+	if res.QANInstance != "" {
+		if err := a.purgeQANData(res.QANInstance, opts.Start, opts.End); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// matchingSeries returns number of series matching the selector which have data in the time range.
+func (a *Admin) matchingSeries(match string, start, end time.Time) (int, error) {
+	query := timeRangeQuery(start, end, "start", "end")
+	query.Set("match[]", match)
+	url := a.qanAPI.URL(a.serverURL, "prometheus/api/v1/series?"+query.Encode())
+	resp, content, err := a.qanAPI.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, a.qanAPI.Error("GET", url, resp.StatusCode, http.StatusOK, content)
+	}
+	var series struct {
+		Data []map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(content, &series); err != nil {
+		return 0, fmt.Errorf("cannot parse Prometheus series: %s", err)
+	}
+	return len(series.Data), nil
+}
+
+// findQANInstance returns QAN instance of this client by type and name.
+// Other clients may have QAN instances with the same name, so it is looked up under the OS instance of this client's agent.
+func (a *Admin) findQANInstance(subsystem, name string) (proto.Instance, error) {
+	agentID, err := getAgentID(fmt.Sprintf("%s/config/agent.conf", AgentBaseDir))
+	if os.IsNotExist(err) {
+		// QAN agent was never set up on this client.
+		return proto.Instance{}, errNoInstance
+	}
+	if err != nil {
+		return proto.Instance{}, err
+	}
+	parentUUID, err := a.getAgentInstance(agentID)
+	if err != nil {
+		return proto.Instance{}, err
+	}
+	return a.getInstance(subsystem, name, parentUUID)
+}
+
+// purgeQANData deletes Query Analytics data of the instance in the time range.
+func (a *Admin) purgeQANData(uuid string, start, end time.Time) error {
+	query := timeRangeQuery(start, end, "begin", "end")
+	url := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "qan", uuid)
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
+	resp, content, err := a.qanAPI.Delete(url)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errors.New("SSM server does not support purging Query Analytics data, please upgrade it.")
+	}
+	return a.qanAPI.Error("DELETE", url, resp.StatusCode, http.StatusNoContent, content)
+}
+
+// timeRangeQuery returns query parameters of the time range, zero times are omitted.
+func timeRangeQuery(start, end time.Time, startParam, endParam string) url.Values {
+	query := url.Values{}
+	if !start.IsZero() {
+		query.Set(startParam, start.UTC().Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		query.Set(endParam, end.UTC().Format(time.RFC3339))
+	}
+	return query
+}
@@ -0,0 +1,165 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shatteredsilicon/ssm-client/tests/fakeapi"
+	"github.com/shatteredsilicon/ssm/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeMatch(t *testing.T) {
+	assert.Equal(t, `{job="mysql",instance="db01"}`, purgeMatch("mysql:metrics", "db01", ""))
+	assert.Equal(t, `{job="redis"}`, purgeMatch(ExternalMetricsType, "redis", ""))
+	assert.Equal(t, `{job="redis",instance="10.0.0.5:9121"}`, purgeMatch(ExternalMetricsType, "redis", "10.0.0.5:9121"))
+}
+
+func TestAdmin_PurgeMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-purge")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(baseDir string) { AgentBaseDir = baseDir }(AgentBaseDir)
+	AgentBaseDir = dir
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "config"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config", "agent.conf"), []byte(`{"UUID":"agent1"}`), 0600))
+
+	var mu sync.Mutex
+	var requests []*http.Request
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r)
+	}
+	reset := func() []*http.Request {
+		mu.Lock()
+		defer mu.Unlock()
+		reqs := requests
+		requests = nil
+		return reqs
+	}
+
+	api := fakeapi.New()
+	api.Append("/prometheus/api/v1/series", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":[{"__name__":"up","job":"mysql","instance":"db01"},{"__name__":"mysql_up","job":"mysql","instance":"db01"}]}`))
+	})
+	api.Append("/prometheus/api/v1/admin/tsdb/", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api.Append("/qan-api/instances/", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if r.URL.Path == "/qan-api/instances/agent1" {
+			data, _ := json.Marshal(proto.Instance{UUID: "agent1", Subsystem: "agent", ParentUUID: "os1"})
+			w.Write(data)
+			return
+		}
+		// Instance of another client with the same name is not returned.
+		if r.URL.Query().Get("name") != "db01" || r.URL.Query().Get("parent_uuid") != "os1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, _ := json.Marshal(proto.Instance{UUID: "abc", Subsystem: "mysql", Name: "db01", ParentUUID: "os1"})
+		w.Write(data)
+	})
+	api.Append("/qan-api/qan/", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer api.Close()
+	_, host, port := api.Start()
+
+	admin := &Admin{}
+	admin.qanAPI = NewAPI(&tls.Config{InsecureSkipVerify: true}, 1*time.Second, false)
+	admin.serverURL = fmt.Sprintf("http://%s:%s", host, port)
+	admin.ServiceName = "db01"
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	t.Run("dry run", func(t *testing.T) {
+		res, err := admin.PurgeMetrics("mysql:metrics", PurgeOptions{Start: start, End: end, DryRun: true, QAN: true})
+		require.NoError(t, err)
+		assert.Equal(t, &PurgeResult{Match: `{job="mysql",instance="db01"}`, Series: 2, QANInstance: "abc"}, res)
+
+		reqs := reset()
+		require.Len(t, reqs, 3)
+		assert.Equal(t, "/prometheus/api/v1/series", reqs[0].URL.Path)
+		assert.Equal(t, `{job="mysql",instance="db01"}`, reqs[0].URL.Query().Get("match[]"))
+		assert.Equal(t, "2024-01-01T00:00:00Z", reqs[0].URL.Query().Get("start"))
+		assert.Equal(t, "2024-01-02T00:00:00Z", reqs[0].URL.Query().Get("end"))
+		assert.Equal(t, "/qan-api/instances/agent1", reqs[1].URL.Path)
+		assert.Equal(t, "/qan-api/instances/", reqs[2].URL.Path)
+		assert.Equal(t, "mysql", reqs[2].URL.Query().Get("type"))
+		assert.Equal(t, "os1", reqs[2].URL.Query().Get("parent_uuid"))
+	})
+
+	t.Run("time range with QAN", func(t *testing.T) {
+		res, err := admin.PurgeMetrics("mysql:metrics", PurgeOptions{Start: start, End: end, QAN: true})
+		require.NoError(t, err)
+		assert.Equal(t, "abc", res.QANInstance)
+
+		reqs := reset()
+		require.Len(t, reqs, 6)
+		assert.Equal(t, "/prometheus/api/v1/admin/tsdb/delete_series", reqs[3].URL.Path)
+		assert.Equal(t, `{job="mysql",instance="db01"}`, reqs[3].URL.Query().Get("match[]"))
+		assert.Equal(t, "2024-01-01T00:00:00Z", reqs[3].URL.Query().Get("start"))
+		assert.Equal(t, "2024-01-02T00:00:00Z", reqs[3].URL.Query().Get("end"))
+		assert.Equal(t, "/prometheus/api/v1/admin/tsdb/clean_tombstones", reqs[4].URL.Path)
+		assert.Equal(t, "DELETE", reqs[5].Method)
+		assert.Equal(t, "/qan-api/qan/abc", reqs[5].URL.Path)
+		assert.Equal(t, "2024-01-01T00:00:00Z", reqs[5].URL.Query().Get("begin"))
+	})
+
+	t.Run("external job", func(t *testing.T) {
+		admin.ServiceName = "redis"
+		defer func() { admin.ServiceName = "db01" }()
+		_, err := admin.PurgeMetrics(ExternalMetricsType, PurgeOptions{Instance: "10.0.0.5:9121"})
+		require.NoError(t, err)
+
+		reqs := reset()
+		require.Len(t, reqs, 3)
+		assert.Equal(t, `{job="redis",instance="10.0.0.5:9121"}`, reqs[1].URL.Query().Get("match[]"))
+		assert.Empty(t, reqs[1].URL.Query().Get("start"))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := admin.PurgeMetrics("mysql:queries", PurgeOptions{})
+		assert.Error(t, err)
+		_, err = admin.PurgeMetrics("linux:metrics", PurgeOptions{QAN: true})
+		assert.Error(t, err)
+		_, err = admin.PurgeMetrics("mysql:metrics", PurgeOptions{Instance: "x"})
+		assert.Error(t, err)
+		_, err = admin.PurgeMetrics("mysql:metrics", PurgeOptions{Start: end, End: start})
+		assert.Error(t, err)
+		assert.Empty(t, reset())
+	})
+}
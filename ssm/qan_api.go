@@ -34,25 +34,33 @@ import (
 )
 
 type API struct {
-	headers     map[string]string
-	hostname    string
-	insecureSSL bool
-	apiTimeout  time.Duration
-	debug       bool // turns on logging requests with std logger
+	headers    map[string]string
+	hostname   string
+	transport  http.RoundTripper
+	retry      utils.RetryPolicy
+	apiTimeout time.Duration
+	debug      bool // turns on logging requests with std logger
 }
 
 type apiError struct {
 	Error string
 }
 
-func NewAPI(insecureFlag bool, timeout time.Duration, debug bool) *API {
+func NewAPI(tlsConfig *tls.Config, timeout time.Duration, debug bool) *API {
+	transport, _ := utils.NewTransport(utils.TransportConfig{TLSConfig: tlsConfig})
+	return newAPI(transport, timeout, debug)
+}
+
+// newAPI returns *API sending requests with the transport.
+func newAPI(transport http.RoundTripper, timeout time.Duration, debug bool) *API {
 	hostname, _ := os.Hostname()
 	a := &API{
-		headers:     nil,
-		hostname:    hostname,
-		insecureSSL: insecureFlag,
-		apiTimeout:  timeout,
-		debug:       debug,
+		headers:    nil,
+		hostname:   hostname,
+		transport:  transport,
+		retry:      utils.DefaultRetryPolicy,
+		apiTimeout: timeout,
+		debug:      debug,
 	}
 	return a
 }
@@ -93,6 +101,15 @@ func (a *API) URL(paths ...string) string {
 }
 
 func (a *API) Get(url string) (*http.Response, []byte, error) {
+	return a.get(url, a.retry)
+}
+
+// GetOnce is like Get but sends the request once, e.g. to check the server is reachable.
+func (a *API) GetOnce(url string) (*http.Response, []byte, error) {
+	return a.get(url, utils.RetryPolicy{})
+}
+
+func (a *API) get(url string, retry utils.RetryPolicy) (*http.Response, []byte, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, nil, err
@@ -103,8 +120,7 @@ func (a *API) Get(url string) (*http.Response, []byte, error) {
 		}
 	}
 
-	client := a.NewClient()
-	resp, err := client.Do(req)
+	resp, err := retry.Do(a.NewClient(), req)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -159,15 +175,9 @@ func (a *API) Error(method, url string, gotStatusCode, expectedStatusCode int, c
 
 // NewClient creates new *http.Client tailored for this API
 func (a *API) NewClient() *http.Client {
-	transport := &http.Transport{}
-	if a.insecureSSL {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-	}
 	client := &http.Client{
 		Timeout:   a.apiTimeout,
-		Transport: transport,
+		Transport: a.transport,
 	}
 	if a.debug {
 		// if api is in debug mode we should log every request and response
@@ -197,8 +207,7 @@ func (a *API) send(method, url string, data []byte) (*http.Response, []byte, err
 		}
 	}
 
-	client := a.NewClient()
-	resp, err := client.Do(req)
+	resp, err := a.retry.Do(a.NewClient(), req)
 	if err != nil {
 		return resp, nil, err
 	}
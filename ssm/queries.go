@@ -81,9 +81,10 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries, prevInfo *plug
 		return nil, err
 	}
 
-	var filterOmit []string
+	var filterOmit, filterAllow []string
 	if mysqlQueries, isMySQL := q.(*queries.Queries); isMySQL {
 		filterOmit = mysqlQueries.FilterOmit()
+		filterAllow = mysqlQueries.FilterAllow()
 	}
 
 	// Register agent if config file does not exist.
@@ -146,8 +147,7 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries, prevInfo *plug
 
 	// Write instance config for qan-agent with real DSN.
 	instance.DSN = info.DSN
-	bytes, _ := json.MarshalIndent(instance, "", "    ")
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instance.UUID), bytes, 0600); err != nil {
+	if err := a.writeInstanceFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instance.UUID), instance); err != nil {
 		return nil, err
 	}
 
@@ -165,6 +165,7 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries, prevInfo *plug
 	qanConfig.UUID = instance.UUID
 	qanConfig.Interval = 60
 	qanConfig.FilterOmit = filterOmit
+	qanConfig.FilterAllow = filterAllow
 	if err := a.startQAN(agentID, qanConfig); err != nil {
 		return nil, err
 	}
@@ -213,6 +214,16 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries, prevInfo *plug
 	if err != nil {
 		return nil, err
 	}
+	// Queries service is shared by all instances, so additional tags are kept per instance in KV.
+	if len(a.Tags) > 0 {
+		d = &consul.KVPair{
+			Key:   fmt.Sprintf("%s/%s/%s/tags", a.Config.ClientName, serviceID, a.ServiceName),
+			Value: []byte(strings.Join(a.Tags, ",")),
+		}
+		if _, err = a.consulAPI.KV().Put(d, nil); err != nil {
+			return nil, err
+		}
+	}
 
 	return info, nil
 }
@@ -498,7 +509,8 @@ func (a *Admin) registerAgent() error {
 		}
 		return fmt.Errorf("problem with agent registration on QAN API: %s", err)
 	}
-	return nil
+	// Installer knows nothing about custom CA and client certificate.
+	return a.syncAgentConfig(fmt.Sprintf("%s/config/agent.conf", AgentBaseDir))
 }
 
 // getProtoQAN reads instance from QAN config file.
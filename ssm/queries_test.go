@@ -18,6 +18,7 @@
 package ssm
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -89,10 +90,10 @@ func TestAdmin_StartStopQAN(t *testing.T) {
 
 	// create ssm-admin instance
 	admin := &Admin{}
-	insecureFlag := true
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
 	timeout := 1 * time.Second
 	debug := false
-	admin.qanAPI = NewAPI(insecureFlag, timeout, debug)
+	admin.qanAPI = NewAPI(tlsConfig, timeout, debug)
 
 	// point ssm-admin to fake http api
 	admin.serverURL = fmt.Sprintf("%s:%s", host, port)
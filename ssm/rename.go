@@ -0,0 +1,110 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+)
+
+// RenameService changes name (alias) of the monitored service keeping its data.
+// Service ID and exporter instance stay the same, so the service keeps running as is.
+func (a *Admin) RenameService(svcType, oldName, newName string) error {
+	if err := isValidSvcType(svcType); err != nil {
+		return err
+	}
+	if match, _ := regexp.MatchString(NameRegex, newName); !match {
+		return errors.New("new name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :")
+	}
+	if oldName == newName {
+		return errors.New("new name is the same as the current one.")
+	}
+
+	consulSvc, err := a.getConsulService(svcType, oldName)
+	if err != nil {
+		return err
+	}
+	if consulSvc == nil {
+		return fmt.Errorf("%s %s: %w", svcType, oldName, ErrNoService)
+	}
+	dup, err := a.getConsulService(svcType, newName)
+	if err != nil {
+		return err
+	}
+	if dup != nil {
+		return fmt.Errorf("%s %s: %w", svcType, newName, ErrDuplicate)
+	}
+	if err := a.checkGlobalDuplicateService(svcType, newName); err != nil {
+		return err
+	}
+
+	// Move per-instance keys, queries services keep them under the name.
+	oldPrefix := fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, consulSvc.ID, oldName)
+	newPrefix := fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, consulSvc.ID, newName)
+	pairs, _, err := a.consulAPI.KV().List(oldPrefix, nil)
+	if err != nil {
+		return err
+	}
+	for _, kvp := range pairs {
+		// Rename QAN instance first, so nothing is moved if QAN API refuses it.
+		if strings.HasPrefix(kvp.Key[len(oldPrefix):], "qan_") && strings.HasSuffix(kvp.Key, "_uuid") {
+			if err := a.renameInstance(string(kvp.Value), oldName, newName); err != nil {
+				return fmt.Errorf("cannot rename QAN instance %s: %s", kvp.Value, err)
+			}
+		}
+	}
+	for _, kvp := range pairs {
+		oldKey := kvp.Key
+		kvp.Key = newPrefix + oldKey[len(oldPrefix):]
+		if _, err := a.consulAPI.KV().Put(kvp, nil); err != nil {
+			return err
+		}
+		if _, err := a.consulAPI.KV().Delete(oldKey, nil); err != nil {
+			return err
+		}
+	}
+
+	// Re-register service with the new alias tag.
+	for i := range consulSvc.Tags {
+		if consulSvc.Tags[i] == fmt.Sprintf("alias_%s", oldName) {
+			consulSvc.Tags[i] = fmt.Sprintf("alias_%s", newName)
+		}
+	}
+	reg := consul.CatalogRegistration{
+		Node:    a.Config.ClientName,
+		Address: a.Config.ClientAddress,
+		Service: consulSvc,
+	}
+	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+		return err
+	}
+
+	// Let SSM server relabel metrics collected under the old name.
+	if strings.HasSuffix(svcType, plugin.TypeMetrics) {
+		if err := a.addServiceRelabelKV(consulSvc.ID, oldName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsul keeps services of one node and KV in memory.
+type fakeConsul struct {
+	mu       sync.Mutex
+	node     string
+	services map[string]*consul.AgentService
+	kv       map[string][]byte
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/catalog/node/"+f.node:
+		json.NewEncoder(w).Encode(consul.CatalogNode{Node: &consul.Node{Node: f.node, Address: "127.0.0.1"}, Services: f.services})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/catalog/service/"):
+		out := []consul.CatalogService{}
+		for _, svc := range f.services {
+			for _, tag := range svc.Tags {
+				if svc.Service == strings.TrimPrefix(r.URL.Path, "/v1/catalog/service/") && tag == r.URL.Query().Get("tag") {
+					out = append(out, consul.CatalogService{Node: f.node, ServiceID: svc.ID, ServiceTags: svc.Tags})
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	case r.Method == "PUT" && r.URL.Path == "/v1/catalog/register":
+		var reg consul.CatalogRegistration
+		json.NewDecoder(r.Body).Decode(&reg)
+		f.services[reg.Service.ID] = reg.Service
+		w.Write([]byte("true"))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		out := consul.KVPairs{}
+		for k, v := range f.kv {
+			if strings.HasPrefix(k, prefix) {
+				out = append(out, &consul.KVPair{Key: k, Value: v})
+			}
+		}
+		if len(out) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(out)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		f.kv[strings.TrimPrefix(r.URL.Path, "/v1/kv/")], _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte("true"))
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		for k := range f.kv {
+			if _, recurse := r.URL.Query()["recurse"]; k == key || recurse && strings.HasPrefix(k, key) {
+				delete(f.kv, k)
+			}
+		}
+		w.Write([]byte("true"))
+	case r.Method == "PUT" && r.URL.Path == "/v1/catalog/deregister":
+		var dereg consul.CatalogDeregistration
+		json.NewDecoder(r.Body).Decode(&dereg)
+		for id := range f.services {
+			if dereg.Node == f.node && (dereg.ServiceID == "" || dereg.ServiceID == id) {
+				delete(f.services, id)
+			}
+		}
+		w.Write([]byte("true"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := []string{}
+	for k := range f.kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestAdmin_RenameService(t *testing.T) {
+	fake := &fakeConsul{
+		node: "client1",
+		services: map[string]*consul.AgentService{
+			"mysql:metrics": {ID: "mysql:metrics", Service: "mysql:metrics", Port: 42002,
+				Tags: []string{"alias_db01", "scheme_https"}},
+			"mysql:queries": {ID: "mysql:queries", Service: "mysql:queries",
+				Tags: []string{"alias_db01", "alias_db02"}},
+		},
+		kv: map[string][]byte{
+			"client1/mysql:metrics/dsn":       []byte("root@tcp(localhost:3306)/"),
+			"client1/mysql:queries/db01/dsn":  []byte("root@tcp(localhost:3306)/"),
+			"client1/mysql:queries/db01/tags": []byte("primary"),
+			"client1/mysql:queries/db02/dsn":  []byte("root@tcp(localhost:3307)/"),
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	admin := &Admin{Config: &Config{ClientName: "client1", ClientAddress: "127.0.0.1"}}
+	var err error
+	admin.consulAPI, err = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+
+	t.Run("metrics", func(t *testing.T) {
+		require.NoError(t, admin.RenameService("mysql:metrics", "db01", "db01-primary"))
+		assert.Equal(t, []string{"alias_db01-primary", "scheme_https"}, fake.services["mysql:metrics"].Tags)
+		assert.Equal(t, 42002, fake.services["mysql:metrics"].Port)
+
+		var relabel []relabelConsulValue
+		require.NoError(t, json.Unmarshal(fake.kv["client1/mysql:metrics/relabel"], &relabel))
+		require.Len(t, relabel, 1)
+		assert.Equal(t, "db01", relabel[0].OldName)
+		assert.Equal(t, int64(0), relabel[0].Start)
+
+		// The next rename starts where the previous one ended.
+		require.NoError(t, admin.RenameService("mysql:metrics", "db01-primary", "db01-main"))
+		require.NoError(t, json.Unmarshal(fake.kv["client1/mysql:metrics/relabel"], &relabel))
+		require.Len(t, relabel, 2)
+		assert.Equal(t, "db01-primary", relabel[1].OldName)
+		assert.Equal(t, relabel[0].End, relabel[1].Start)
+	})
+
+	t.Run("queries", func(t *testing.T) {
+		require.NoError(t, admin.RenameService("mysql:queries", "db01", "db01-primary"))
+		assert.Equal(t, []string{"alias_db01-primary", "alias_db02"}, fake.services["mysql:queries"].Tags)
+		assert.Equal(t, []string{
+			"client1/mysql:metrics/dsn",
+			"client1/mysql:metrics/relabel",
+			"client1/mysql:queries/db01-primary/dsn",
+			"client1/mysql:queries/db01-primary/tags",
+			"client1/mysql:queries/db02/dsn",
+		}, fake.keys())
+		assert.Equal(t, "primary", string(fake.kv["client1/mysql:queries/db01-primary/tags"]))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		err := admin.RenameService("mysql:queries", "db01", "db03")
+		assert.True(t, errors.Is(err, ErrNoService), "%v", err)
+		err = admin.RenameService("mysql:queries", "db02", "db01-primary")
+		assert.True(t, errors.Is(err, ErrDuplicate), "%v", err)
+		assert.Error(t, admin.RenameService("mysql:queries", "db02", "db02"))
+		assert.Error(t, admin.RenameService("mysql:queries", "db02", "bad name"))
+		assert.Error(t, admin.RenameService("mysql:logs", "db02", "db03"))
+	})
+}
+
+func TestAdmin_RenameServiceAddOldName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-rename")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(baseDir string) { SSMBaseDir = baseDir }(SSMBaseDir)
+	SSMBaseDir = dir
+
+	fake := &fakeConsul{
+		node: "client1",
+		services: map[string]*consul.AgentService{
+			"mysql:metrics": {ID: "mysql:metrics", Service: "mysql:metrics", Port: 42002,
+				Tags: []string{"alias_db01", "scheme_https"}},
+			"mysql:metrics@db02": {ID: "mysql:metrics@db02", Service: "mysql:metrics", Port: 42003,
+				Tags: []string{"alias_db02", "scheme_https"}},
+		},
+		kv: map[string][]byte{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	admin := &Admin{Config: &Config{ClientName: "client1", ClientAddress: "127.0.0.1"}}
+	admin.consulAPI, err = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(instanceConfigPath("mysql:metrics", "db02"), []byte("[web]\nlisten-address = 127.0.0.1:42003\n"), 0600))
+
+	// Renamed service keeps its exporter instance, adding the old name must not take it over.
+	require.NoError(t, admin.RenameService("mysql:metrics", "db02", "db02-primary"))
+	svc, err := admin.getConsulService("mysql:metrics", "db02")
+	require.NoError(t, err)
+	assert.Nil(t, svc)
+	instance, err := admin.freeInstance("mysql:metrics", "db02")
+	require.NoError(t, err)
+	assert.Equal(t, "db02-2", instance)
+
+	// Config left by another exporter instance is not reused either.
+	require.NoError(t, ioutil.WriteFile(instanceConfigPath("mysql:metrics", "db02-2"), nil, 0600))
+	instance, err = admin.freeInstance("mysql:metrics", "db02")
+	require.NoError(t, err)
+	assert.Equal(t, "db02-3", instance)
+
+	instance, err = admin.freeInstance("mysql:metrics", "db03")
+	require.NoError(t, err)
+	assert.Equal(t, "db03", instance)
+}
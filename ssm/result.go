@@ -0,0 +1,191 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"strings"
+
+	"github.com/shatteredsilicon/ssm-client/ssm/managed"
+)
+
+// Exit codes of ssm-admin commands, except doctor which has codes of its own.
+const (
+	ExitOK           = 0
+	ExitError        = 1
+	ExitDuplicate    = 3
+	ExitNotFound     = 4
+	ExitConnectivity = 5
+	ExitPermission   = 6
+)
+
+// ExitCodesHelp documents exit codes of ssm-admin commands.
+const ExitCodesHelp = `Exit codes:
+  0  success
+  1  error not covered below
+  3  service or instance with this name is already monitored
+  4  service or instance is not found
+  5  SSM server or monitored service can not be reached
+  6  permission denied: not running as root, server authentication or file access failed
+`
+
+// ConnectivityError is returned if SSM server can not be reached or does not look like SSM server.
+type ConnectivityError struct {
+	Err error
+}
+
+func (e *ConnectivityError) Error() string { return e.Err.Error() }
+
+func (e *ConnectivityError) Unwrap() error { return e.Err }
+
+// PermissionError is returned if SSM server rejects the credentials or access is denied otherwise.
+type PermissionError struct {
+	Err error
+}
+
+func (e *PermissionError) Error() string { return e.Err.Error() }
+
+func (e *PermissionError) Unwrap() error { return e.Err }
+
+// ExitCode returns exit code of ssm-admin command failed with the error.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var connErr *ConnectivityError
+	var permErr *PermissionError
+	var managedErr *managed.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrDuplicate):
+		return ExitDuplicate
+	case errors.Is(err, ErrNoService):
+		return ExitNotFound
+	case errors.As(err, &permErr), errors.Is(err, fs.ErrPermission):
+		return ExitPermission
+	case errors.As(err, &connErr), errors.As(err, &netErr):
+		return ExitConnectivity
+	case errors.As(err, &managedErr):
+		switch managedErr.Code {
+		case managed.ErrAlreadyExists:
+			return ExitDuplicate
+		case managed.ErrNotFound:
+			return ExitNotFound
+		case managed.ErrPermissionDenied, managed.ErrUnauthenticated:
+			return ExitPermission
+		case managed.ErrUnavailable, managed.ErrDeadlineExceeded:
+			return ExitConnectivity
+		}
+	}
+	return ExitError
+}
+
+// Result is the outcome of ssm-admin command printed with --json.
+// Commands record it explicitly, the text output is kept for humans only.
+type Result struct {
+	Command  string          `json:"command"`
+	Success  bool            `json:"success"`
+	ExitCode int             `json:"exit_code"`
+	Actions  []string        `json:"actions"`
+	Services []ServiceResult `json:"services"`
+	Warnings []string        `json:"warnings"`
+	Errors   []string        `json:"errors"`
+	// Output is the text output of the command as printed without --json.
+	Output []string `json:"output"`
+}
+
+// ServiceResult is the outcome of the command for one service.
+type ServiceResult struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Statuses of ServiceResult.
+const (
+	ServiceOK = "ok"
+	// ServiceUnchanged means the service is already in the requested state,
+	// e.g. it is already monitored on add.
+	ServiceUnchanged = "unchanged"
+	// ServiceSkipped means the service was not set up while the command succeeded,
+	// Error tells the reason.
+	ServiceSkipped = "skipped"
+	ServiceError   = "error"
+)
+
+// AddService records the outcome of the action on the service, failed if err is not nil.
+func (r *Result) AddService(svcType, name, action string, err error) {
+	s := ServiceResult{Type: svcType, Name: name, Action: action, Status: ServiceOK}
+	if err != nil {
+		s.Status, s.Error = ServiceError, err.Error()
+	}
+	r.Services = append(r.Services, s)
+}
+
+// AddUnchanged records the service is already in the state the action requests.
+func (r *Result) AddUnchanged(svcType, name, action string) {
+	r.Services = append(r.Services, ServiceResult{Type: svcType, Name: name, Action: action, Status: ServiceUnchanged})
+}
+
+// AddSkipped records the service is left out for the reason without failing the command.
+func (r *Result) AddSkipped(svcType, name, action string, reason error) {
+	r.Services = append(r.Services, ServiceResult{Type: svcType, Name: name, Action: action, Status: ServiceSkipped, Error: reason.Error()})
+}
+
+// AddAction records the overall outcome of the command.
+func (r *Result) AddAction(msg string) {
+	r.Actions = append(r.Actions, strings.TrimSpace(msg))
+}
+
+// AddWarning records a problem which does not fail the command.
+func (r *Result) AddWarning(msg string) {
+	r.Warnings = append(r.Warnings, strings.TrimSpace(msg))
+}
+
+// AddError records the reason the command failed.
+func (r *Result) AddError(msg string) {
+	r.Errors = append(r.Errors, strings.TrimSpace(msg))
+}
+
+// SetOutput keeps the text output of the command.
+func (r *Result) SetOutput(out string) {
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimRight(line, " \t"); line != "" {
+			r.Output = append(r.Output, line)
+		}
+	}
+}
+
+// Finish sets exit code of the command.
+func (r *Result) Finish(code int) {
+	r.ExitCode = code
+	r.Success = code == ExitOK
+	// Print empty lists rather than nulls.
+	for _, list := range []*[]string{&r.Actions, &r.Warnings, &r.Errors, &r.Output} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	if r.Services == nil {
+		r.Services = []ServiceResult{}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package ssm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/shatteredsilicon/ssm-client/ssm/managed"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{errors.New("boom"), ExitError},
+		{ErrDuplicate, ExitDuplicate},
+		{fmt.Errorf("mysql: %w", ErrNoService), ExitNotFound},
+		{&PermissionError{Err: errors.New("unauthorized")}, ExitPermission},
+		{&os.PathError{Op: "open", Path: "/etc/shadow", Err: os.ErrPermission}, ExitPermission},
+		{&ConnectivityError{Err: errors.New("connection refused")}, ExitConnectivity},
+		{&managed.Error{Code: managed.ErrAlreadyExists}, ExitDuplicate},
+		{&managed.Error{Code: managed.ErrNotFound}, ExitNotFound},
+	} {
+		assert.Equal(t, tt.code, ExitCode(tt.err), "%v", tt.err)
+	}
+}
+
+func TestResult(t *testing.T) {
+	t.Parallel()
+
+	r := &Result{Command: "add mysql"}
+	r.AddUnchanged("linux:metrics", "db01", "add")
+	r.AddService("mysql:metrics", "db01", "add", nil)
+	r.AddService("mysql:queries", "db01", "add", errors.New("timeout"))
+	r.AddSkipped("postgresql:queries", "db01", "add", errors.New("pg_stat_statements is missing"))
+	r.AddWarning("[mysql:queries] Warning: slow log is disabled.\n")
+	r.AddError("[mysql:queries] Error adding MySQL queries: timeout\n")
+	r.SetOutput("[linux:metrics] OK, already monitoring this system.\n\n[mysql:metrics] OK, now monitoring MySQL metrics.\n")
+	r.Finish(ExitError)
+
+	assert.False(t, r.Success)
+	assert.Equal(t, ExitError, r.ExitCode)
+	assert.Equal(t, []ServiceResult{
+		{Type: "linux:metrics", Name: "db01", Action: "add", Status: ServiceUnchanged},
+		{Type: "mysql:metrics", Name: "db01", Action: "add", Status: ServiceOK},
+		{Type: "mysql:queries", Name: "db01", Action: "add", Status: ServiceError, Error: "timeout"},
+		{Type: "postgresql:queries", Name: "db01", Action: "add", Status: ServiceSkipped, Error: "pg_stat_statements is missing"},
+	}, r.Services)
+	assert.Equal(t, []string{}, r.Actions)
+	assert.Equal(t, []string{"[mysql:queries] Warning: slow log is disabled."}, r.Warnings)
+	assert.Equal(t, []string{"[mysql:queries] Error adding MySQL queries: timeout"}, r.Errors)
+	assert.Equal(t, []string{
+		"[linux:metrics] OK, already monitoring this system.",
+		"[mysql:metrics] OK, now monitoring MySQL metrics.",
+	}, r.Output)
+
+	r = &Result{Command: "start"}
+	r.AddAction("OK, started 2 services.\n")
+	r.Finish(ExitOK)
+	assert.True(t, r.Success)
+	assert.Equal(t, []string{"OK, started 2 services."}, r.Actions)
+	assert.Equal(t, []ServiceResult{}, r.Services)
+	assert.Equal(t, []string{}, r.Output)
+}
@@ -0,0 +1,238 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// exporterHealthMetrics are metrics exporters report their connection to the monitored service with.
+var exporterHealthMetrics = map[string]string{
+	"mysql":      "mysql_up",
+	"postgresql": "pg_up",
+	"mongodb":    "mongodb_up",
+	"proxysql":   "proxysql_up",
+}
+
+// ScrapeResult is the outcome of scraping exporter from the client side.
+type ScrapeResult struct {
+	URL string `json:"url"`
+	// Duration of the scrape in seconds.
+	Duration float64 `json:"duration"`
+	// Size of the response body in bytes.
+	Size   int `json:"size"`
+	Series int `json:"series"`
+	// Health is the exporter-reported health metric, e.g. mysql_up, if exporter has one.
+	Health     string `json:"health,omitempty"`
+	HealthUp   bool   `json:"health_up"`
+	HealthSeen bool   `json:"health_seen"`
+	Error      string `json:"error,omitempty"`
+}
+
+// scrapeExporter requests metrics from exporter the way SSM server does
+// and parses the exposition.
+func (a *Admin) scrapeExporter(svc *consul.AgentService) *ScrapeResult {
+	scheme := "http"
+	for _, tag := range svc.Tags {
+		if tag == "scheme_https" {
+			scheme = "https"
+		}
+	}
+	urlPath := "metrics"
+	if svc.Service == "mysql:metrics" {
+		urlPath = "metrics-hr"
+	}
+	res := &ScrapeResult{
+		URL:    fmt.Sprintf("%s://%s/%s", scheme, net.JoinHostPort(a.Config.BindAddress, fmt.Sprint(svc.Port)), urlPath),
+		Health: exporterHealthMetrics[strings.Split(svc.Service, ":")[0]],
+	}
+
+	req, err := http.NewRequest("GET", res.URL, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if a.Config.ServerUser != "" {
+		req.SetBasicAuth(a.Config.ServerUser, a.Config.ServerPassword)
+	}
+	client := &http.Client{
+		Timeout: apiTimeout,
+		// Exporters use self-signed certificate.
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = fmt.Sprintf("cannot get %s: %s", res.URL, err)
+		return res
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	res.Duration = time.Since(start).Seconds()
+	res.Size = len(body)
+	if resp.StatusCode != http.StatusOK {
+		res.Error = fmt.Sprintf("%s returned %s", res.URL, resp.Status)
+		return res
+	}
+	if err != nil {
+		res.Error = fmt.Sprintf("cannot read %s: %s", res.URL, err)
+		return res
+	}
+
+	families, err := parseExposition(bytes.NewReader(body))
+	if err != nil {
+		res.Error = fmt.Sprintf("%s returned invalid metrics: %s", res.URL, err)
+		return res
+	}
+	if len(families) == 0 {
+		res.Error = fmt.Sprintf("%s returned no metrics", res.URL)
+		return res
+	}
+	res.Series = countSeries(families)
+	if mf, ok := families[res.Health]; ok && len(mf.Metric) > 0 {
+		res.HealthSeen = true
+		res.HealthUp = metricValue(mf.GetType(), mf.Metric[0]) == 1
+	}
+	return res
+}
+
+// parseExposition parses metrics in Prometheus text exposition format.
+func parseExposition(r io.Reader) (map[string]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(r)
+}
+
+// countSeries returns number of time series Prometheus gets from the metric families.
+func countSeries(families map[string]*dto.MetricFamily) int {
+	n := 0
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			switch mf.GetType() {
+			case dto.MetricType_HISTOGRAM:
+				// Buckets, _sum and _count.
+				n += len(m.GetHistogram().GetBucket()) + 2
+			case dto.MetricType_SUMMARY:
+				// Quantiles, _sum and _count.
+				n += len(m.GetSummary().GetQuantile()) + 2
+			default:
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func metricValue(t dto.MetricType, m *dto.Metric) float64 {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+// ExporterStatus is the state of exporter scraped from the client side.
+type ExporterStatus struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Running bool   `json:"running"`
+	// ScrapeResult is nil if exporter is not running.
+	*ScrapeResult
+}
+
+// printExporterStatus prints exporter scrape table of check-network.
+func printExporterStatus(rows []ExporterStatus) {
+	maxTypeLen := len("SERVICE TYPE")
+	maxNameLen := len("NAME")
+	for _, r := range rows {
+		if len(r.Type) > maxTypeLen {
+			maxTypeLen = len(r.Type)
+		}
+		if len(r.Name) > maxNameLen {
+			maxNameLen = len(r.Name)
+		}
+	}
+	maxTypeLen++
+	maxNameLen++
+
+	fmtPattern := "%%-%ds %%-%ds %%-%ds %%-8s %%-10s %%-10s %%s\n"
+	linefmt := fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, 7)
+	fmt.Printf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", 7),
+		strings.Repeat("-", 8), strings.Repeat("-", 10), strings.Repeat("-", 10), strings.Repeat("-", 10))
+	fmt.Printf(linefmt, "SERVICE TYPE", "NAME", "SCRAPE", "SERIES", "DURATION", "SIZE", "HEALTH")
+	fmt.Printf(linefmt, strings.Repeat("-", maxTypeLen), strings.Repeat("-", maxNameLen), strings.Repeat("-", 7),
+		strings.Repeat("-", 8), strings.Repeat("-", 10), strings.Repeat("-", 10), strings.Repeat("-", 10))
+
+	// Pad values before coloring, escape sequences have no width.
+	linefmt = fmt.Sprintf(fmtPattern, maxTypeLen, maxNameLen, 0)
+	for _, r := range rows {
+		if !r.Running {
+			fmt.Printf(linefmt, r.Type, r.Name, colorStatus("", "STOPPED", false), "-", "-", "-", "-")
+			continue
+		}
+		if r.Error != "" {
+			fmt.Printf(linefmt, r.Type, r.Name, colorStatus("", fmt.Sprintf("%-7s", "FAIL"), false), "-", "-", "-", "-")
+			continue
+		}
+		health := "-"
+		if r.HealthSeen {
+			health = colorStatus(r.Health+"=1", r.Health+"=0", r.HealthUp)
+		} else if r.Health != "" {
+			health = colorStatus("", "no "+r.Health, false)
+		}
+		fmt.Printf(linefmt, r.Type, r.Name, colorStatus(fmt.Sprintf("%-7s", "OK"), "", true), fmt.Sprint(r.Series),
+			secondsToDuration(r.Duration).Round(time.Millisecond).String(), formatSize(r.Size), health)
+	}
+	for _, r := range rows {
+		switch {
+		case !r.Running:
+		case r.Error != "":
+			fmt.Printf("Error scraping %s %s: %s\n", r.Type, r.Name, r.Error)
+		case r.HealthSeen && !r.HealthUp:
+			fmt.Printf("Warning: %s %s reports %s 0, the exporter can not connect to the monitored service, check its DSN.\n", r.Type, r.Name, r.Health)
+		}
+	}
+}
+
+// formatSize formats size in bytes for humans.
+func formatSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package ssm
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testExposition = `# HELP mysql_up Whether the MySQL server is up.
+# TYPE mysql_up gauge
+mysql_up 0
+# HELP mysql_global_status_threads_connected Generic metric.
+# TYPE mysql_global_status_threads_connected untyped
+mysql_global_status_threads_connected 4
+# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.1"} 1
+http_request_duration_seconds_bucket{le="1"} 2
+http_request_duration_seconds_bucket{le="+Inf"} 3
+http_request_duration_seconds_sum 2.5
+http_request_duration_seconds_count 3
+`
+
+func TestScrapeExporter(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ssm" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/metrics-hr":
+			fmt.Fprint(w, testExposition)
+		default:
+			fmt.Fprint(w, "not metrics {\n")
+		}
+	}))
+	defer ts.Close()
+
+	host, portStr, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(t, err)
+	port, _ := strconv.Atoi(portStr)
+
+	a := &Admin{Config: &Config{BindAddress: host, ServerUser: "ssm", ServerPassword: "secret"}}
+	res := a.scrapeExporter(&consul.AgentService{Service: "mysql:metrics", Port: port})
+	assert.Empty(t, res.Error)
+	assert.Equal(t, "http://"+ts.Listener.Addr().String()+"/metrics-hr", res.URL)
+	assert.Equal(t, len(testExposition), res.Size)
+	assert.Equal(t, 7, res.Series)
+	assert.Equal(t, "mysql_up", res.Health)
+	assert.True(t, res.HealthSeen)
+	assert.False(t, res.HealthUp)
+
+	res = a.scrapeExporter(&consul.AgentService{Service: "linux:metrics", Port: port})
+	assert.Contains(t, res.Error, "invalid metrics")
+
+	a.Config.ServerPassword = "wrong"
+	res = a.scrapeExporter(&consul.AgentService{Service: "mysql:metrics", Port: port})
+	assert.Equal(t, res.URL+" returned 401 Unauthorized", res.Error)
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+	assert.Equal(t, "2.0 MiB", formatSize(2*1024*1024))
+}
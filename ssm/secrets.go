@@ -0,0 +1,469 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm/proto"
+	"gopkg.in/ini.v1"
+)
+
+// Secrets can be kept encrypted at rest in ssm.yml and exporter configs. Passwords and DSNs there
+// are stored as secretPrefix followed by base64 of AES-256-GCM nonce and ciphertext, the key is read
+// from SecretKeyFile. Exporters can't decrypt them, so on systemd their services get a drop-in which
+// runs `ssm-admin decrypt-config` before start, writing the decrypted config to the runtime directory
+// (tmpfs, removed on stop), and points the exporter to it. qan-agent reads and rewrites QAN instance
+// files and agent.conf itself, so the DSNs and the password there are kept in plain text.
+
+const secretPrefix = "enc:v1:"
+
+// exporterSecrets are section and key names of exporter config values holding credentials.
+var exporterSecrets = [][2]string{
+	{"", "dsn"},
+	{"exporter", "dsn"},
+	{"mongodb", "uri"},
+}
+
+// secretsDropIn is the name of systemd drop-in of exporter service reading decrypted config.
+const secretsDropIn = "ssm-secrets.conf"
+
+// isEncrypted checks if value is encrypted.
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, secretPrefix)
+}
+
+// secretKeyPath returns path of the host key, systemd credential takes precedence.
+func secretKeyPath() string {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		if f := filepath.Join(dir, filepath.Base(SecretKeyFile)); FileExists(f) {
+			return f
+		}
+	}
+	return SecretKeyFile
+}
+
+// generateSecretKey creates a new host key unless it already exists.
+func generateSecretKey() error {
+	if FileExists(SecretKeyFile) {
+		return nil
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(SecretKeyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0400)
+}
+
+// loadSecretKey reads the host key.
+func loadSecretKey() ([]byte, error) {
+	file := secretKeyPath()
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key to decrypt secrets: %s", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(bytes)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s is not a valid key file.", file)
+	}
+	return key, nil
+}
+
+// encryptSecret encrypts value with the key.
+func encryptSecret(key []byte, s string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(s), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts value encrypted by encryptSecret.
+func decryptSecret(key []byte, s string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, secretPrefix))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted value.")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value, the key does not match.")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// key returns the host key, it is read once.
+func (a *Admin) key() ([]byte, error) {
+	if a.secretKey == nil {
+		key, err := loadSecretKey()
+		if err != nil {
+			return nil, err
+		}
+		a.secretKey = key
+	}
+	return a.secretKey, nil
+}
+
+// encryptValue encrypts value if secrets are to be encrypted.
+func (a *Admin) encryptValue(s string) (string, error) {
+	if !a.Config.EncryptSecrets || s == "" || isEncrypted(s) {
+		return s, nil
+	}
+	key, err := a.key()
+	if err != nil {
+		return "", err
+	}
+	return encryptSecret(key, s)
+}
+
+// decryptValue decrypts value if it is encrypted.
+func (a *Admin) decryptValue(s string) (string, error) {
+	if !isEncrypted(s) {
+		return s, nil
+	}
+	key, err := a.key()
+	if err != nil {
+		return "", err
+	}
+	return decryptSecret(key, s)
+}
+
+// decryptConfig decrypts passwords of the loaded config.
+func (a *Admin) decryptConfig() error {
+	for _, s := range []*string{&a.Config.MySQLPassword, &a.Config.ServerPassword} {
+		v, err := a.decryptValue(*s)
+		if err != nil {
+			return err
+		}
+		*s = v
+	}
+	return nil
+}
+
+// encryptedConfig returns copy of the config with passwords encrypted if secrets are to be encrypted.
+func (a *Admin) encryptedConfig() (Config, error) {
+	c := *a.Config
+	for _, s := range []*string{&c.MySQLPassword, &c.ServerPassword} {
+		v, err := a.encryptValue(*s)
+		if err != nil {
+			return c, err
+		}
+		*s = v
+	}
+	return c, nil
+}
+
+// readInstanceFile reads QAN instance file.
+func (a *Admin) readInstanceFile(file string) (proto.Instance, error) {
+	instance := proto.Instance{}
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return instance, err
+	}
+	err = json.Unmarshal(bytes, &instance)
+	return instance, err
+}
+
+// writeInstanceFile writes QAN instance file, qan-agent reads DSN from it as is.
+func (a *Admin) writeInstanceFile(file string, instance proto.Instance) error {
+	bytes, _ := json.MarshalIndent(instance, "", "    ")
+	return ioutil.WriteFile(file, bytes, 0600)
+}
+
+// convertExporterConfig encrypts or decrypts credentials in exporter config.
+func (a *Admin) convertExporterConfig(cfgPath string, encrypt bool) (bool, error) {
+	cfgFile, err := ini.Load(cfgPath)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, s := range exporterSecrets {
+		section, err := cfgFile.GetSection(s[0])
+		if err != nil || !section.HasKey(s[1]) {
+			continue
+		}
+		old := section.Key(s[1]).String()
+		var v string
+		if encrypt {
+			v, err = a.encryptValue(old)
+		} else {
+			v, err = a.decryptValue(old)
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", cfgPath, err)
+		}
+		if v != old {
+			section.Key(s[1]).SetValue(v)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, cfgFile.SaveTo(cfgPath)
+}
+
+// DecryptExporterConfig writes copy of exporter config with credentials decrypted for exporter to read it.
+func (a *Admin) DecryptExporterConfig(src, dst string) error {
+	cfgFile, err := ini.Load(src)
+	if err != nil {
+		return err
+	}
+	for _, s := range exporterSecrets {
+		section, err := cfgFile.GetSection(s[0])
+		if err != nil || !section.HasKey(s[1]) {
+			continue
+		}
+		v, err := a.decryptValue(section.Key(s[1]).String())
+		if err != nil {
+			return fmt.Errorf("%s: %s", src, err)
+		}
+		section.Key(s[1]).SetValue(v)
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := cfgFile.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prepareExporterSecrets keeps credentials of exporter config encrypted if secrets are encrypted,
+// pointing its systemd service to the decrypted copy. Elsewhere they are kept in plain text.
+func (a *Admin) prepareExporterSecrets(serviceType, instance string) (bool, error) {
+	encrypt := a.Config.EncryptSecrets && service.Platform() == systemdPlatform
+	if encrypt {
+		if err := installSecretsDropIn(serviceType, instance); err != nil {
+			return false, err
+		}
+	}
+	changed, err := a.convertExporterConfig(instanceConfigPath(serviceType, instance), encrypt)
+	if err != nil || encrypt {
+		return changed, err
+	}
+	return changed, removeSecretsDropIn(serviceType, instance)
+}
+
+// secretsDropInPath returns path of systemd drop-in of the exporter service reading decrypted config.
+func secretsDropInPath(serviceType, instance string) string {
+	return path.Join(systemdDir, instanceServiceName(serviceType, instance)+systemdExtension+".d", secretsDropIn)
+}
+
+// installSecretsDropIn creates systemd drop-in of the exporter service reading decrypted config.
+func installSecretsDropIn(serviceType, instance string) error {
+	name := instanceServiceName(serviceType, instance)
+	var unit string
+	for _, svc := range GetLocalServices(serviceType) {
+		if svc.instance == instance {
+			unit = svc.filePath
+			break
+		}
+	}
+	if unit == "" {
+		return fmt.Errorf("system service %s is not found.", name)
+	}
+	bytes, err := ioutil.ReadFile(unit)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	content, err := secretsDropInContent(string(bytes), name, instanceConfigPath(serviceType, instance), exe)
+	if err != nil {
+		return fmt.Errorf("%s: %s", unit, err)
+	}
+
+	file := secretsDropInPath(serviceType, instance)
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "daemon-reload").Run()
+}
+
+// secretsDropInContent returns drop-in of the unit which decrypts exporter config into the runtime directory
+// before start and replaces the config path in ExecStart of the unit with the decrypted copy.
+func secretsDropInContent(unit, name, cfgPath, exe string) (string, error) {
+	// Config may be given relative to the working directory.
+	old := cfgPath
+	if !strings.Contains(unit, old) {
+		old = path.Base(cfgPath)
+	}
+	decrypted := path.Join("/run", name, path.Base(cfgPath))
+
+	var execStart []string
+	for _, line := range strings.Split(unit, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "ExecStart=") && strings.Contains(line, old) {
+			execStart = append(execStart, strings.Replace(line, old, decrypted, -1))
+		}
+	}
+	if len(execStart) == 0 {
+		return "", fmt.Errorf("ExecStart does not pass %s to the exporter.", path.Base(cfgPath))
+	}
+
+	return fmt.Sprintf(`# Created by ssm-admin, exporter reads its config with credentials decrypted.
+[Service]
+RuntimeDirectory=%s
+RuntimeDirectoryMode=0700
+ExecStartPre=%s decrypt-config %s %s
+ExecStart=
+%s
+`, name, exe, cfgPath, decrypted, strings.Join(execStart, "\n")), nil
+}
+
+// removeSecretsDropIn removes systemd drop-in of the exporter service reading decrypted config.
+func removeSecretsDropIn(serviceType, instance string) error {
+	file := secretsDropInPath(serviceType, instance)
+	if err := os.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	os.Remove(path.Dir(file))
+	return exec.Command("systemctl", "daemon-reload").Run()
+}
+
+// SecretsConversion is the outcome of EncryptSecrets.
+type SecretsConversion struct {
+	// Files are files with secrets converted.
+	Files []string
+	// Restarted are running system services restarted to pick up the converted files.
+	Restarted []string
+	// Warnings are secrets which can't be converted.
+	Warnings []string
+}
+
+// EncryptSecrets turns encryption of secrets in ssm.yml and exporter configs on or off.
+// Converted exporters and Query Analytics agents are restarted if running.
+func (a *Admin) EncryptSecrets(encrypt bool) (*SecretsConversion, error) {
+	if encrypt {
+		if err := generateSecretKey(); err != nil {
+			return nil, fmt.Errorf("cannot create key file %s: %s", SecretKeyFile, err)
+		}
+	}
+	a.Config.EncryptSecrets = encrypt
+
+	if err := a.writeConfig(); err != nil {
+		return nil, fmt.Errorf("Unable to write config file %s: %s", ConfigFile, err)
+	}
+	res := &SecretsConversion{Files: []string{ConfigFile}}
+
+	var exporterTypes []string
+	for serviceType := range serviceExporters {
+		exporterTypes = append(exporterTypes, serviceType)
+	}
+	var exporters []localService
+	for _, svc := range GetLocalServices(exporterTypes...) {
+		if FileExists(instanceConfigPath(svc.serviceType, svc.instance)) {
+			exporters = append(exporters, svc)
+		}
+	}
+	if encrypt && service.Platform() != systemdPlatform && len(exporters) > 0 {
+		res.Warnings = append(res.Warnings, "exporter configs are kept in plain text, only systemd can pass decrypted configs to exporters.")
+	}
+
+	var converted []string
+	for _, svc := range exporters {
+		changed, err := a.prepareExporterSecrets(svc.serviceType, svc.instance)
+		if err != nil {
+			return res, err
+		}
+		if changed {
+			res.Files = append(res.Files, instanceConfigPath(svc.serviceType, svc.instance))
+			converted = append(converted, instanceServiceName(svc.serviceType, svc.instance))
+		}
+	}
+	if encrypt {
+		res.Warnings = append(res.Warnings, "QAN instance files and qan-agent config are kept in plain text, qan-agent can't decrypt them.")
+	}
+
+	restarted, err := restartRunningServices(plugin.MySQLQueries, plugin.MongoDBQueries, plugin.PostgreSQLQueries)
+	res.Restarted = append(res.Restarted, restarted...)
+	if err != nil {
+		return res, err
+	}
+	for _, name := range converted {
+		if !getServiceStatus(name) {
+			continue
+		}
+		if err := restartService(name); err != nil {
+			return res, err
+		}
+		res.Restarted = append(res.Restarted, name)
+	}
+	return res, nil
+}
+
+// restartRunningServices restarts running system services of the given types.
+// Only local system services are looked up, so it works without SSM server.
+func restartRunningServices(serviceTypes ...string) ([]string, error) {
+	var restarted []string
+	for _, svc := range GetLocalServices(serviceTypes...) {
+		name := instanceServiceName(svc.serviceType, svc.instance)
+		if !getServiceStatus(name) {
+			continue
+		}
+		if err := restartService(name); err != nil {
+			return restarted, err
+		}
+		restarted = append(restarted, name)
+	}
+	sort.Strings(restarted)
+	return restarted, nil
+}
@@ -0,0 +1,132 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shatteredsilicon/ssm/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	enc, err := encryptSecret(key, "ssm:abc123@tcp(localhost:3306)/")
+	require.NoError(t, err)
+	assert.True(t, isEncrypted(enc))
+	assert.NotContains(t, enc, "abc123")
+
+	dec, err := decryptSecret(key, enc)
+	require.NoError(t, err)
+	assert.Equal(t, "ssm:abc123@tcp(localhost:3306)/", dec)
+
+	_, err = decryptSecret(bytes.Repeat([]byte{2}, 32), enc)
+	assert.Error(t, err)
+}
+
+func TestConvertSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &Admin{
+		Config:    &Config{EncryptSecrets: true, ServerPassword: "secret"},
+		secretKey: bytes.Repeat([]byte{1}, 32),
+	}
+
+	// Config passwords.
+	c, err := a.encryptedConfig()
+	require.NoError(t, err)
+	assert.True(t, isEncrypted(c.ServerPassword))
+	assert.Equal(t, "", c.MySQLPassword)
+	a.Config = &c
+	require.NoError(t, a.decryptConfig())
+	assert.Equal(t, "secret", a.Config.ServerPassword)
+
+	// Exporter config.
+	cfgPath := filepath.Join(dir, "mysqld_exporter.conf")
+	require.NoError(t, ioutil.WriteFile(cfgPath, []byte("[exporter]\ndsn = ssm:abc@tcp(localhost:3306)/\n[web]\nlisten-address = 127.0.0.1:42002\n"), 0600))
+	changed, err := a.convertExporterConfig(cfgPath, true)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	cfg, err := ini.Load(cfgPath)
+	require.NoError(t, err)
+	assert.True(t, isEncrypted(cfg.Section("exporter").Key("dsn").String()))
+	assert.Equal(t, "127.0.0.1:42002", cfg.Section("web").Key("listen-address").String())
+	changed, err = a.convertExporterConfig(cfgPath, true)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Exporter reads the decrypted copy.
+	decrypted := filepath.Join(dir, "run", "mysqld_exporter.conf")
+	require.NoError(t, os.Mkdir(filepath.Dir(decrypted), 0700))
+	require.NoError(t, a.DecryptExporterConfig(cfgPath, decrypted))
+	cfg, err = ini.Load(decrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "ssm:abc@tcp(localhost:3306)/", cfg.Section("exporter").Key("dsn").String())
+	assert.Equal(t, "127.0.0.1:42002", cfg.Section("web").Key("listen-address").String())
+	fi, err := os.Stat(decrypted)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	changed, err = a.convertExporterConfig(cfgPath, false)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	cfg, err = ini.Load(cfgPath)
+	require.NoError(t, err)
+	assert.Equal(t, "ssm:abc@tcp(localhost:3306)/", cfg.Section("exporter").Key("dsn").String())
+
+	// QAN instance file is read by qan-agent as is.
+	instanceFile := filepath.Join(dir, "instance.json")
+	require.NoError(t, a.writeInstanceFile(instanceFile, proto.Instance{UUID: "1", DSN: "ssm:abc@tcp(localhost:3306)/"}))
+	content, err := ioutil.ReadFile(instanceFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "ssm:abc@tcp(localhost:3306)/")
+	instance, err := a.readInstanceFile(instanceFile)
+	require.NoError(t, err)
+	assert.Equal(t, "ssm:abc@tcp(localhost:3306)/", instance.DSN)
+}
+
+func TestSecretsDropInContent(t *testing.T) {
+	unit := `[Unit]
+Description=SSM Prometheus mysqld_exporter
+
+[Service]
+ExecStart=/opt/ss/ssm-client/mysqld_exporter --config=/opt/ss/ssm-client/mysqld_exporter-db02.conf
+Restart=always
+`
+	content, err := secretsDropInContent(unit, "ssm-mysql-metrics@db02", "/opt/ss/ssm-client/mysqld_exporter-db02.conf", "/usr/sbin/ssm-admin")
+	require.NoError(t, err)
+	assert.Equal(t, `# Created by ssm-admin, exporter reads its config with credentials decrypted.
+[Service]
+RuntimeDirectory=ssm-mysql-metrics@db02
+RuntimeDirectoryMode=0700
+ExecStartPre=/usr/sbin/ssm-admin decrypt-config /opt/ss/ssm-client/mysqld_exporter-db02.conf /run/ssm-mysql-metrics@db02/mysqld_exporter-db02.conf
+ExecStart=
+ExecStart=/opt/ss/ssm-client/mysqld_exporter --config=/run/ssm-mysql-metrics@db02/mysqld_exporter-db02.conf
+`, content)
+
+	_, err = secretsDropInContent("[Service]\nExecStart=/opt/ss/ssm-client/mysqld_exporter\n", "ssm-mysql-metrics", "/opt/ss/ssm-client/mysqld_exporter.conf", "/usr/sbin/ssm-admin")
+	assert.Error(t, err)
+}
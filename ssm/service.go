@@ -20,6 +20,7 @@ package ssm
 import (
 	"fmt"
 	"os/exec"
+	"strings"
 
 	service "github.com/percona/kardianos-service"
 )
@@ -212,6 +213,35 @@ func serviceName(serviceType string) string {
 	return fmt.Sprintf("ssm-%s", serviceTypeInName(serviceType))
 }
 
+// instanceServiceName returns system service name of the given exporter instance.
+// The first instance of each service type uses the service shipped with the package.
+func instanceServiceName(serviceType, instance string) string {
+	name := serviceName(serviceType)
+	if name == "" || instance == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s@%s", name, instance)
+}
+
+// serviceID returns Consul service ID of the given exporter instance.
+func serviceID(serviceType, instance string) string {
+	if instance == "" {
+		return serviceType
+	}
+
+	return fmt.Sprintf("%s@%s", serviceType, instance)
+}
+
+// serviceInstance returns exporter instance from Consul service ID.
+func serviceInstance(id string) string {
+	if i := strings.Index(id, "@"); i != -1 {
+		return id[i+1:]
+	}
+
+	return ""
+}
+
 func upgradeServiceName(serviceType string) string {
 	if name := serviceName(serviceType); name != "" {
 		return fmt.Sprintf("%s%s", name, upgradeServiceSuffix)
@@ -19,17 +19,8 @@ package ssm
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"errors"
 	"fmt"
 	"io/fs"
-	"math/big"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -60,6 +51,7 @@ type Admin struct {
 	ServiceName  string
 	ServicePort  int
 	Args         []string // Args defines additional arguments to pass through to *_exporter or qan-agent
+	Tags         []string // Tags defines additional Consul tags of the service being added
 	Config       *Config
 	Verbose      bool
 	SkipAdmin    bool
@@ -70,133 +62,156 @@ type Admin struct {
 	consulAPI    *consul.Client
 	promQueryAPI prometheus.QueryAPI
 	managedAPI   *managed.Client
+	secretKey    []byte
 	//promSeriesAPI prometheus.SeriesAPI
 }
 
 // SetAPI setups QAN, Consul, Prometheus, pmm-managed clients and verifies connections.
 func (a *Admin) SetAPI() error {
-	// Set default API timeout if unset.
-	if a.apiTimeout == 0 {
-		a.apiTimeout = apiTimeout
-	}
-
-	scheme := "http"
-	helpText := ""
-	insecureTransport := &http.Transport{}
-	if a.Config.ServerInsecureSSL {
-		scheme = "https"
-		insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		helpText = "--server-insecure-ssl"
-	}
-	if a.Config.ServerSSL {
-		scheme = "https"
-		helpText = "--server-ssl"
-	}
-
-	// QAN API.
-	a.qanAPI = NewAPI(a.Config.ServerInsecureSSL, a.apiTimeout, a.Verbose)
-	httpClient := a.qanAPI.NewClient()
-
-	// Consul API.
-	config := consul.Config{
-		Address:    a.Config.ServerAddress,
-		HttpClient: httpClient,
-		Scheme:     scheme,
-	}
-	var authStr string
-	if a.Config.ServerUser != "" {
-		config.HttpAuth = &consul.HttpBasicAuth{
-			Username: a.Config.ServerUser,
-			Password: a.Config.ServerPassword,
-		}
-		authStr = fmt.Sprintf("%s:%s@", url.QueryEscape(a.Config.ServerUser), url.QueryEscape(a.Config.ServerPassword))
+	if err := a.SetClients(); err != nil {
+		return err
 	}
-	a.consulAPI, _ = consul.NewClient(&config)
-
-	// Full URL.
-	a.serverURL = fmt.Sprintf("%s://%s%s", scheme, authStr, a.Config.ServerAddress)
-
-	// Prometheus API.
-	cfg := prometheus.Config{Address: fmt.Sprintf("%s/prometheus", a.serverURL)}
-	// cfg.Transport = httpClient.Transport
-	// above should be used instead below but
-	// https://github.com/prometheus/client_golang/issues/292
-	if a.Config.ServerInsecureSSL {
-		cfg.Transport = insecureTransport
-	}
-	client, _ := prometheus.New(cfg)
-	a.promQueryAPI = prometheus.NewQueryAPI(client)
-	//a.promSeriesAPI = prometheus.NewSeriesAPI(client)
+	scheme, helpText := a.Config.serverScheme()
 
 	// Check if server is alive.
 	qanApiURL := a.qanAPI.URL(a.serverURL, qanAPIBasePath, "ping")
-	resp, _, err := a.qanAPI.Get(qanApiURL)
+	resp, _, err := a.qanAPI.GetOnce(qanApiURL)
 	if err != nil {
 		if strings.Contains(err.Error(), "x509: cannot validate certificate") {
-			return fmt.Errorf(`Unable to connect to SSM server by address: %s
+			return &ConnectivityError{fmt.Errorf(`Unable to connect to SSM server by address: %s
 
-Looks like SSM server running with self-signed SSL certificate.
-Run 'ssm-admin config --server-insecure-ssl' to enable such configuration.`, a.Config.ServerAddress)
+Looks like SSM server running with self-signed SSL certificate or one issued by internal CA.
+Run 'ssm-admin config --server-ca-file' to trust the CA or 'ssm-admin config --server-insecure-ssl'
+to skip certificate verification.`, a.Config.ServerAddress)}
 		}
 		serverURL := fmt.Sprintf("%s://%s", scheme, a.Config.ServerAddress)
 		cleanedErr := strings.Replace(err.Error(), a.serverURL, serverURL, -1)
-		return fmt.Errorf(`Unable to connect to SSM server by address: %s
+		return &ConnectivityError{fmt.Errorf(`Unable to connect to SSM server by address: %s
 %s
 
 * Check if the configured address is correct.
 * If server is running on non-default port, ensure it was specified along with the address.
 * If server is enabled for SSL or self-signed SSL, enable the corresponding option.
-* You may also check the firewall settings.`, a.Config.ServerAddress, cleanedErr)
+* You may also check the firewall settings.`, a.Config.ServerAddress, cleanedErr)}
 	}
 
 	// Try to detect 400 (SSL) and 401 (HTTP auth).
 	if resp.StatusCode == http.StatusBadRequest {
-		return fmt.Errorf(`Unable to connect to SSM server by address: %s
+		return &ConnectivityError{fmt.Errorf(`Unable to connect to SSM server by address: %s
 
 Looks like the server is enabled for SSL or self-signed SSL.
-Use 'ssm-admin config' to enable the corresponding SSL option.`, a.Config.ServerAddress)
+Use 'ssm-admin config' to enable the corresponding SSL option.`, a.Config.ServerAddress)}
 	}
 	if resp.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf(`Unable to connect to SSM server by address: %s
+		return &PermissionError{fmt.Errorf(`Unable to connect to SSM server by address: %s
 
 Looks like the server is password protected.
-Use 'ssm-admin config' to define server user and password.`, a.Config.ServerAddress)
+Use 'ssm-admin config' to define server user and password.`, a.Config.ServerAddress)}
 	}
 
 	// Check Consul status.
 	if leader, err := a.consulAPI.Status().Leader(); err != nil || leader == "" {
-		return fmt.Errorf(`Unable to connect to SSM server by address: %s
+		return &ConnectivityError{fmt.Errorf(`Unable to connect to SSM server by address: %s
 
 Even though the server is reachable it does not look to be SSM server.
-Check if the configured address is correct. %s`, a.Config.ServerAddress, err)
+Check if the configured address is correct. %s`, a.Config.ServerAddress, err)}
 	}
 
 	// Check if server is not password protected but client is configured so.
 	if a.Config.ServerUser != "" {
 		serverURL := fmt.Sprintf("%s://%s", scheme, a.Config.ServerAddress)
 		qanApiURL = a.qanAPI.URL(serverURL, qanAPIBasePath, "ping")
-		if resp, _, err := a.qanAPI.Get(qanApiURL); err == nil && resp.StatusCode == http.StatusOK {
-			return fmt.Errorf(`This client is configured with HTTP basic authentication.
+		if resp, _, err := a.qanAPI.GetOnce(qanApiURL); err == nil && resp.StatusCode == http.StatusOK {
+			return &PermissionError{fmt.Errorf(`This client is configured with HTTP basic authentication.
 However, SSM server is not.
 
 If you forgot to enable password protection on the server, you may want to do so.
 
 Otherwise, run the following command to reset the config and disable authentication:
-ssm-admin config --server %s %s`, a.Config.ServerAddress, helpText)
+ssm-admin config --server %s %s`, a.Config.ServerAddress, helpText)}
 		}
 	}
 
+	return nil
+}
+
+// SetClients sets clients of SSM server APIs without checking the server is reachable.
+func (a *Admin) SetClients() error {
+	// Set default API timeout if unset.
+	if a.apiTimeout == 0 {
+		a.apiTimeout = apiTimeout
+	}
+
+	scheme, _ := a.Config.serverScheme()
+	transport, err := a.Config.serverTransport()
+	if err != nil {
+		return err
+	}
+
+	// QAN API.
+	a.qanAPI = newAPI(transport, a.apiTimeout, a.Verbose)
+	httpClient := a.qanAPI.NewClient()
+
+	// Consul API.
+	config := consul.Config{
+		Address:    a.Config.ServerAddress,
+		HttpClient: httpClient,
+		Scheme:     scheme,
+	}
+	var authStr string
+	if a.Config.ServerUser != "" {
+		config.HttpAuth = &consul.HttpBasicAuth{
+			Username: a.Config.ServerUser,
+			Password: a.Config.ServerPassword,
+		}
+		authStr = fmt.Sprintf("%s:%s@", url.QueryEscape(a.Config.ServerUser), url.QueryEscape(a.Config.ServerPassword))
+	}
+	a.consulAPI, _ = consul.NewClient(&config)
+
+	// Full URL.
+	a.serverURL = fmt.Sprintf("%s://%s%s", scheme, authStr, a.Config.ServerAddress)
+
+	// Prometheus API.
+	// Prometheus client requires cancelable transport, so it gets the shared one unwrapped.
+	cfg := prometheus.Config{
+		Address:   fmt.Sprintf("%s/prometheus", a.serverURL),
+		Transport: transport,
+	}
+	client, _ := prometheus.New(cfg)
+	a.promQueryAPI = prometheus.NewQueryAPI(client)
+	//a.promSeriesAPI = prometheus.NewSeriesAPI(client)
+
 	var user *url.Userinfo
 	if a.Config.ServerUser != "" {
 		user = url.UserPassword(a.Config.ServerUser, a.Config.ServerPassword)
 	}
-	a.managedAPI = managed.NewClient(a.Config.ServerAddress, a.Config.ManagedAPIPath, scheme, user, a.Config.ServerInsecureSSL, a.Verbose)
+	a.managedAPI = managed.NewClient(a.Config.ServerAddress, a.Config.ManagedAPIPath, scheme, user, transport, a.Verbose)
 
 	return nil
 }
 
 // PrintInfo print SSM client info.
 func (a *Admin) PrintInfo() {
+	if a.Format != "" {
+		tmpl, err := templates.Parse(a.Format)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		info := ClientInfo{
+			Version:        Version,
+			ServerInfo:     a.serverInfo(),
+			ServiceManager: service.Platform(),
+			GoVersion:      strings.Replace(runtime.Version(), "go", "", 1),
+			RuntimeInfo:    fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		}
+		if err := tmpl.Execute(os.Stdout, info); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println()
+		return
+	}
+
 	fmt.Printf("ssm-admin %s\n\n", Version)
 	a.ServerInfo()
 	fmt.Printf("%-15s | %s\n\n", "Service Manager", service.Platform())
@@ -214,6 +229,15 @@ const (
 `
 )
 
+// ClientInfo is SSM client information printed by PrintInfo.
+type ClientInfo struct {
+	Version string
+	ServerInfo
+	ServiceManager string
+	GoVersion      string
+	RuntimeInfo    string
+}
+
 type ServerInfo struct {
 	ServerAddress     string
 	ServerSecurity    string
@@ -248,6 +272,12 @@ func (a *Admin) serverInfo() ServerInfo {
 	} else if a.Config.ServerSSL {
 		labels = append(labels, "SSL")
 	}
+	if a.Config.ServerCAFile != "" {
+		labels = append(labels, "custom CA")
+	}
+	if a.Config.ServerClientCert != "" {
+		labels = append(labels, "client certificate")
+	}
 	if a.Config.ServerUser != "" {
 		labels = append(labels, "password-protected")
 	}
@@ -278,6 +308,7 @@ func (a *Admin) StartStopMonitoring(action, svcType string) (affected bool, err
 	}
 
 	// Check if we have this service on Consul.
+	instance := ""
 	if !IsOfflineAction(action) {
 		consulSvc, err := a.getConsulService(svcType, a.ServiceName)
 		if err != nil {
@@ -286,12 +317,19 @@ func (a *Admin) StartStopMonitoring(action, svcType string) (affected bool, err
 		if consulSvc == nil {
 			return false, ErrNoService
 		}
+		instance = serviceInstance(consulSvc.ID)
 	}
 
 	var svcName string
 	services := GetLocalServices(svcType)
-	if len(services) > 0 {
-		svcName = services[0].serviceName
+	for _, svc := range services {
+		// Offline, the instance is looked up by service name.
+		if svc.instance == instance || (IsOfflineAction(action) && svc.instance == a.ServiceName) {
+			svcName = svc.serviceName
+			if svc.instance != "" {
+				break
+			}
+		}
 	}
 	switch action {
 	case "start":
@@ -339,7 +377,11 @@ func (a *Admin) StartStopAllMonitoring(action string) (numOfAffected, numOfAll i
 
 	for _, svc := range localServices {
 		if !IsOfflineAction(action) {
-			consulSvc, err := a.getConsulService(svc.serviceType, a.ServiceName)
+			name := a.ServiceName
+			if svc.instance != "" {
+				name = svc.instance
+			}
+			consulSvc, err := a.getConsulService(svc.serviceType, name)
 			if err != nil {
 				errs = append(errs, err)
 				continue
@@ -437,6 +479,10 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 				if err := a.RemoveMetrics(plugin.NamePostgreSQL); err != nil && !ignoreErrors {
 					return count, err
 				}
+			case plugin.PostgreSQLQueries:
+				if err := a.RemoveQueries(plugin.NamePostgreSQL); err != nil && !ignoreErrors {
+					return count, err
+				}
 			case plugin.ProxySQLMetrics:
 				if err := a.RemoveMetrics(plugin.NameProxySQL); err != nil && !ignoreErrors {
 					return count, err
@@ -453,34 +499,6 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 	return count, nil
 }
 
-// PurgeMetrics purge metrics data on the server by its metric type and name.
-func (a *Admin) PurgeMetrics(svcType string) error {
-	if isValidSvcType(svcType) != nil || !strings.HasSuffix(svcType, plugin.TypeMetrics) {
-		return errors.New(`bad service type.
-
-Service type takes the following values: linux:metrics, mysql:metrics, mongodb:metrics, proxysql:metrics, postgresql:metrics.`)
-	}
-
-	var promError error
-
-	// Delete series in Prometheus v2.
-	match := fmt.Sprintf(`{job="%s",instance="%s"}`, strings.Split(svcType, ":")[0], a.ServiceName)
-	url := a.qanAPI.URL(a.serverURL, fmt.Sprintf("prometheus/api/v1/admin/tsdb/delete_series?match[]=%s", match))
-	resp, _, err := a.qanAPI.Post(url, []byte{})
-	if err != nil || resp.StatusCode != http.StatusNoContent {
-		promError = fmt.Errorf("%v:%v resp: %v", promError, err, resp)
-	}
-
-	// Clean tombstones in Prometheus v2.
-	url = a.qanAPI.URL(a.serverURL, "prometheus/api/v1/admin/tsdb/clean_tombstones")
-	resp, _, err = a.qanAPI.Post(url, []byte{})
-	if err != nil || resp.StatusCode != http.StatusNoContent {
-		promError = fmt.Errorf("%v:%v resp: %v", promError, err, resp)
-	}
-
-	return promError
-}
-
 // getConsulService get service from Consul by service type and optionally name (alias).
 func (a *Admin) getConsulService(service, name string) (*consul.AgentService, error) {
 	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
@@ -535,16 +553,6 @@ Choose different name for this service.`,
 	return nil
 }
 
-// checkSSLCertificate check if SSL cert and key files exist and generate them if not.
-func (a *Admin) checkSSLCertificate() error {
-	if FileExists(SSLCertFile) && FileExists(SSLKeyFile) {
-		return nil
-	}
-
-	// Generate SSL cert and key.
-	return generateSSLCertificate(a.Config.ClientAddress, SSLCertFile, SSLKeyFile)
-}
-
 // CheckVersion check server and client versions and returns boolean and error; boolean is true if error is fatal.
 func (a *Admin) CheckVersion(ctx context.Context) (fatal bool, err error) {
 	clientVersion, err := version.Parse(Version)
@@ -605,7 +613,7 @@ func (a *Admin) CheckInstallation() (upgradeRequired bool, orphanedServices, mis
 ForLoop1:
 	for _, s := range activeServices {
 		for _, svc := range node.Services {
-			if s.serviceType == svc.Service {
+			if serviceID(s.serviceType, s.instance) == svc.ID {
 				continue ForLoop1
 			}
 		}
@@ -616,7 +624,7 @@ ForLoop1:
 ForLoop2:
 	for _, svc := range node.Services {
 		for _, s := range localServices {
-			if svc.Service == s.serviceType {
+			if svc.ID == serviceID(s.serviceType, s.instance) {
 				continue ForLoop2
 			}
 		}
@@ -659,7 +667,7 @@ func (a *Admin) RepairInstallation() error {
 		names, _, err := a.consulAPI.KV().Keys(prefix, "", nil)
 		if err == nil {
 			for _, name := range names {
-				for _, serviceName := range []string{"mysql", "mongodb"} {
+				for _, serviceName := range []string{"mysql", "mongodb", "postgresql"} {
 					if strings.HasSuffix(name, fmt.Sprintf("/qan_%s_uuid", serviceName)) {
 						data, _, err := a.consulAPI.KV().Get(name, nil)
 						if err == nil && data != nil {
@@ -705,6 +713,13 @@ func (a *Admin) Uninstall() (count uint16, clientErr, serverErr error) {
 		}
 	}
 
+	// Remove system services and configs created for additional exporter instances.
+	for _, service := range GetLocalServices() {
+		if service.instance != "" {
+			removeInstance(service.serviceType, service.instance)
+		}
+	}
+
 	// remove saved ssm service files under /etc/systemd/system, ignore error
 	exec.Command(
 		"sh",
@@ -737,9 +752,13 @@ func (a *Admin) Uninstall() (count uint16, clientErr, serverErr error) {
 	if a.Config.ServerInsecureSSL || a.Config.ServerSSL {
 		schema = "https"
 	}
+	var transport *http.Transport
+	if transport, serverErr = a.Config.serverTransport(); serverErr != nil {
+		return
+	}
 	a.managedAPI = managed.NewClient(
 		a.Config.ServerAddress, a.Config.ManagedAPIPath,
-		schema, user, a.Config.ServerInsecureSSL, false,
+		schema, user, transport, false,
 	)
 	serverErr = a.managedAPI.DeleteNode(context.Background(), a.Config.ClientName)
 	if serverErr != nil {
@@ -751,6 +770,8 @@ func (a *Admin) Uninstall() (count uint16, clientErr, serverErr error) {
 	os.RemoveAll(fmt.Sprintf("%s/%s", AgentBaseDir, "data"))
 	os.RemoveAll(fmt.Sprintf("%s/%s", AgentBaseDir, "instance"))
 	os.RemoveAll(fmt.Sprintf("%s/%s", AgentBaseDir, "trash"))
+	os.Remove(PortsFile)
+	os.Remove(SecretKeyFile)
 
 	err := a.removeConfig()
 	if err != nil {
@@ -765,6 +786,7 @@ type localService struct {
 	serviceType string
 	serviceName string
 	filePath    string
+	instance    string
 }
 
 func (svc localService) isPMMService() bool {
@@ -772,7 +794,7 @@ func (svc localService) isPMMService() bool {
 }
 
 func (svc localService) isV1Service() bool {
-	return strings.Count(svc.serviceName, "-") == 3
+	return svc.instance == "" && strings.Count(svc.serviceName, "-") == 3
 }
 
 func (svc localService) isQueries() bool {
@@ -790,7 +812,7 @@ func GetLocalServices(serviceTypes ...string) (services []localService) {
 	dir, extension := GetServiceDirAndExtension()
 
 	serviceMap := make(map[string]localService)
-	serviceRegex := regexp.MustCompile(`^(ssm|pmm)-([^-]+-[^-]+)(-\d+)?$`)
+	serviceRegex := regexp.MustCompile(`^(ssm|pmm)-([^-@]+-[^-@]+)(-\d+)?(@(.+))?$`)
 	walkFunc := func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -815,11 +837,13 @@ func GetLocalServices(serviceTypes ...string) (services []localService) {
 		}
 
 		serviceType := strings.Replace(parts[2], "-", ":", 1)
-		if _, ok := serviceMap[serviceType]; !ok || parts[3] != "" {
-			serviceMap[serviceType] = localService{
+		id := serviceID(serviceType, parts[5])
+		if _, ok := serviceMap[id]; !ok || parts[3] != "" {
+			serviceMap[id] = localService{
 				serviceType: serviceType,
 				serviceName: name,
 				filePath:    path,
+				instance:    parts[5],
 			}
 		}
 
@@ -896,16 +920,7 @@ func FileExists(file string) bool {
 
 // CheckBinaries check if all SSM Client binaries are at their paths
 func CheckBinaries() string {
-	paths := []string{
-		fmt.Sprintf("%s/node_exporter", SSMBaseDir),
-		fmt.Sprintf("%s/mysqld_exporter", SSMBaseDir),
-		fmt.Sprintf("%s/mongodb_exporter", SSMBaseDir),
-		fmt.Sprintf("%s/proxysql_exporter", SSMBaseDir),
-		fmt.Sprintf("%s/postgres_exporter", SSMBaseDir),
-		fmt.Sprintf("%s/bin/ssm-qan-agent", AgentBaseDir),
-		fmt.Sprintf("%s/bin/ssm-qan-agent-installer", AgentBaseDir),
-	}
-	for _, p := range paths {
+	for _, p := range binaries() {
 		if !FileExists(p) {
 			return p
 		}
@@ -924,56 +939,6 @@ func colorStatus(msgOK string, msgNotOK string, ok bool) string {
 	return c(msgNotOK)
 }
 
-// generateSSLCertificate generate SSL certificate and key and write them into the files.
-func generateSSLCertificate(host, certFile, keyFile string) error {
-	// Generate key.
-	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return fmt.Errorf("failed to generate private key: %s", err)
-	}
-
-	// Generate cert.
-	notBefore, _ := time.Parse("Jan 2 15:04:05 2006", "Nov 25 15:00:00 2016")
-	notAfter, _ := time.Parse("Jan 2 15:04:05 2006", "Nov 25 15:00:00 2026")
-	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
-	cert := x509.Certificate{
-		Subject:               pkix.Name{Organization: []string{"SSM Client"}},
-		SerialNumber:          serialNumber,
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-	if ip := net.ParseIP(host); ip != nil {
-		cert.IPAddresses = append(cert.IPAddresses, ip)
-	} else {
-		cert.DNSNames = append(cert.DNSNames, host)
-	}
-
-	certBytes, err := x509.CreateCertificate(rand.Reader, &cert, &cert, &privKey.PublicKey, privKey)
-	if err != nil {
-		return fmt.Errorf("failed to generate certificate: %s", err)
-	}
-
-	// Write files.
-	out, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write %s: %s", certFile, err)
-	}
-	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-	out.Close()
-
-	out, err = os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write %s: %s", keyFile, err)
-	}
-	pem.Encode(out, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)})
-	out.Close()
-
-	return nil
-}
-
 var svcTypes = []string{
 	plugin.LinuxMetrics,
 	plugin.MySQLMetrics,
@@ -981,6 +946,7 @@ var svcTypes = []string{
 	plugin.MongoDBMetrics,
 	plugin.MongoDBQueries,
 	plugin.PostgreSQLMetrics,
+	plugin.PostgreSQLQueries,
 	plugin.ProxySQLMetrics,
 }
 
@@ -1036,6 +1002,11 @@ func (a *Admin) Upgrade() (err error) {
 		return err
 	}
 
+	// Running exporters are restarted below and pick up the renewed certificate.
+	if _, err = a.renewExpiringCertificate(); err != nil {
+		return err
+	}
+
 	if service.Platform() == systemdPlatform {
 		if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
 			return err
@@ -1049,7 +1020,7 @@ func (a *Admin) Upgrade() (err error) {
 		}
 
 		isRunning := getServiceStatus(svc.serviceName)
-		svcName := serviceName(svc.serviceType)
+		svcName := instanceServiceName(svc.serviceType, svc.instance)
 
 		if svc.isV1Service() && !svc.isQueries() {
 			switch service.Platform() {
@@ -0,0 +1,378 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+	"github.com/fatih/color"
+	"github.com/shatteredsilicon/ssm-client/ssm/utils"
+)
+
+const (
+	defaultNTPHost      = "0.pool.ntp.org"
+	defaultMaxTimeDrift = 60 * time.Second
+	// Offsets closer than this to the median are never treated as outliers,
+	// so sources agreeing within network noise are all accepted.
+	minOutlierDeviation = 100 * time.Millisecond
+)
+
+// TimeReport is the result of system time check. Offsets, drifts and durations are in seconds.
+type TimeReport struct {
+	Sources []NTPSample `json:"sources"`
+	// Accepted is the number of sources the consensus is made of.
+	Accepted int `json:"accepted"`
+	// Offset of NTP time from the client clock agreed by the sources.
+	Offset *float64 `json:"offset,omitempty"`
+	// Jitter is RMS of accepted source offsets around the consensus offset.
+	Jitter            *float64        `json:"jitter,omitempty"`
+	ClientTime        time.Time       `json:"client_time"`
+	ServerTime        *time.Time      `json:"server_time,omitempty"`
+	ServerDrift       *float64        `json:"server_drift,omitempty"`
+	ClientDrift       *float64        `json:"client_drift,omitempty"`
+	ClientServerDrift *float64        `json:"client_server_drift,omitempty"`
+	MaxDrift          float64         `json:"max_drift"`
+	Daemon            *TimeSyncStatus `json:"daemon,omitempty"`
+	InSync            bool            `json:"in_sync"`
+}
+
+// NTPSample is the response of one NTP server.
+type NTPSample struct {
+	Host    string  `json:"host"`
+	Offset  float64 `json:"offset"`
+	RTT     float64 `json:"rtt"`
+	Stratum int     `json:"stratum"`
+	Outlier bool    `json:"outlier"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// TimeSyncStatus is synchronization state of the local time sync daemon.
+type TimeSyncStatus struct {
+	Daemon       string `json:"daemon"`
+	Synchronized bool   `json:"synchronized"`
+	Source       string `json:"source,omitempty"`
+	Stratum      int    `json:"stratum,omitempty"`
+	// Offset of the system clock chrony is correcting.
+	Offset *float64 `json:"offset,omitempty"`
+}
+
+// ntpQuery is replaced in tests.
+var ntpQuery = ntp.QueryWithOptions
+
+// runCommand is replaced in tests.
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// ntpHosts returns NTP servers to use, hosts are comma separated.
+func ntpHosts(hosts ...string) []string {
+	list := []string{}
+	for _, h := range hosts {
+		for _, host := range strings.Split(h, ",") {
+			if host = strings.TrimSpace(host); host != "" && !utils.SliceContains(list, host) {
+				list = append(list, host)
+			}
+		}
+		// First non-empty setting wins.
+		if len(list) > 0 {
+			break
+		}
+	}
+	if len(list) == 0 {
+		list = append(list, defaultNTPHost)
+	}
+	return list
+}
+
+// checkTime compares client time with NTP servers and SSM server.
+// serverTime is zero if SSM server time is not known.
+func checkTime(hosts []string, serverTime time.Time, maxDrift time.Duration) *TimeReport {
+	r := &TimeReport{
+		Sources:  queryNTPServers(hosts),
+		MaxDrift: maxDrift.Seconds(),
+		InSync:   true,
+	}
+	r.ClientTime = time.Now()
+	if offset, jitter, accepted := ntpConsensus(r.Sources); accepted > 0 {
+		r.Accepted = accepted
+		r.Offset, r.Jitter = &offset, &jitter
+		drift := math.Abs(offset)
+		r.ClientDrift = &drift
+	}
+	if !serverTime.IsZero() {
+		r.ServerTime = &serverTime
+		drift := math.Abs(serverTime.Sub(r.ClientTime).Seconds())
+		r.ClientServerDrift = &drift
+		if r.Offset != nil {
+			ntpTime := r.ClientTime.Add(time.Duration(*r.Offset * float64(time.Second)))
+			drift := math.Abs(serverTime.Sub(ntpTime).Seconds())
+			r.ServerDrift = &drift
+		}
+	}
+	for _, d := range []*float64{r.ServerDrift, r.ClientDrift, r.ClientServerDrift} {
+		if d != nil && *d > r.MaxDrift {
+			r.InSync = false
+		}
+	}
+	r.Daemon = timeSyncStatus()
+	return r
+}
+
+// queryNTPServers queries NTP servers in parallel.
+func queryNTPServers(hosts []string) []NTPSample {
+	samples := make([]NTPSample, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			samples[i] = queryNTPServer(host)
+		}(i, host)
+	}
+	wg.Wait()
+	return samples
+}
+
+func queryNTPServer(host string) NTPSample {
+	s := NTPSample{Host: host}
+	var resp *ntp.Response
+	var err error
+	// ntp.Query() has default timeout of 5s, which should be enough,
+	// but still it fails to get time too often.
+	// Let's try smaller timeouts (1s) but try several times.
+	for i := 0; i < 3; i++ {
+		resp, err = ntpQuery(host, ntp.QueryOptions{Timeout: 1 * time.Second})
+		if err == nil {
+			err = resp.Validate()
+		}
+		if err == nil {
+			break
+		}
+		if i < 2 {
+			time.Sleep(1 * time.Second)
+		}
+	}
+	if err != nil {
+		s.Error = fmt.Sprintf("unable to get ntp time: %s", err)
+		return s
+	}
+	s.Offset = resp.ClockOffset.Seconds()
+	s.RTT = resp.RTT.Seconds()
+	s.Stratum = int(resp.Stratum)
+	return s
+}
+
+// ntpConsensus marks sources which disagree with the others as outliers
+// and returns mean offset and jitter of the rest.
+// Outlier is a source further from the median offset than three median absolute deviations.
+func ntpConsensus(samples []NTPSample) (offset, jitter float64, accepted int) {
+	offsets := []float64{}
+	for _, s := range samples {
+		if s.Error == "" {
+			offsets = append(offsets, s.Offset)
+		}
+	}
+	if len(offsets) == 0 {
+		return 0, 0, 0
+	}
+	med := median(offsets)
+	deviations := make([]float64, len(offsets))
+	for i, o := range offsets {
+		deviations[i] = math.Abs(o - med)
+	}
+	limit := math.Max(3*median(deviations), minOutlierDeviation.Seconds())
+
+	sum := 0.0
+	for i := range samples {
+		if samples[i].Error != "" {
+			continue
+		}
+		if math.Abs(samples[i].Offset-med) > limit {
+			samples[i].Outlier = true
+			continue
+		}
+		sum += samples[i].Offset
+		accepted++
+	}
+	offset = sum / float64(accepted)
+	for _, s := range samples {
+		if s.Error == "" && !s.Outlier {
+			jitter += (s.Offset - offset) * (s.Offset - offset)
+		}
+	}
+	jitter = math.Sqrt(jitter / float64(accepted))
+	return offset, jitter, accepted
+}
+
+func median(values []float64) float64 {
+	v := append([]float64{}, values...)
+	sort.Float64s(v)
+	if len(v)%2 == 0 {
+		return (v[len(v)/2-1] + v[len(v)/2]) / 2
+	}
+	return v[len(v)/2]
+}
+
+// timeSyncStatus reads synchronization state of chrony or systemd-timesyncd.
+// Returns nil if neither of them is found.
+func timeSyncStatus() *TimeSyncStatus {
+	if out, err := runCommand("chronyc", "-c", "tracking"); err == nil {
+		if s, err := parseChronyTracking(out); err == nil {
+			return s
+		}
+	}
+	out, err := runCommand("timedatectl", "show")
+	if err != nil {
+		return nil
+	}
+	// Available since systemd 239 and only if timesyncd is running.
+	timesync, _ := runCommand("timedatectl", "show-timesync")
+	return parseTimedatectl(out, timesync)
+}
+
+// parseChronyTracking parses output of 'chronyc -c tracking'.
+func parseChronyTracking(out []byte) (*TimeSyncStatus, error) {
+	fields, err := csv.NewReader(bytes.NewReader(out)).Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 14 {
+		return nil, fmt.Errorf("unexpected chronyc output: %q", out)
+	}
+	s := &TimeSyncStatus{
+		Daemon:       "chrony",
+		Source:       fields[1],
+		Synchronized: fields[13] == "Normal",
+	}
+	s.Stratum, _ = strconv.Atoi(fields[2])
+	if offset, err := strconv.ParseFloat(fields[4], 64); err == nil {
+		s.Offset = &offset
+	}
+	// Reference ID is zero if chrony has not synchronized yet.
+	if fields[0] == "00000000" || s.Stratum == 0 {
+		s.Synchronized = false
+	}
+	return s, nil
+}
+
+// parseTimedatectl parses output of 'timedatectl show' and 'timedatectl show-timesync'.
+func parseTimedatectl(show, timesync []byte) *TimeSyncStatus {
+	props := parseProperties(show)
+	s := &TimeSyncStatus{
+		Daemon:       "systemd-timesyncd",
+		Synchronized: props["NTPSynchronized"] == "yes",
+	}
+	if props["NTP"] != "yes" {
+		if !s.Synchronized {
+			return nil
+		}
+		// Clock is synchronized by some other daemon, e.g. ntpd.
+		s.Daemon = "unknown"
+		return s
+	}
+	s.Source = parseProperties(timesync)["ServerName"]
+	return s
+}
+
+// parseProperties parses KEY=VALUE lines.
+func parseProperties(out []byte) map[string]string {
+	props := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if kv := strings.SplitN(scanner.Text(), "=", 2); len(kv) == 2 {
+			props[kv[0]] = kv[1]
+		}
+	}
+	return props
+}
+
+// printTimeReport prints the system time section of check-network.
+func printTimeReport(r *TimeReport) {
+	timeFormat := "2006-01-02 15:04:05 -0700 MST"
+	linefmt := "%-35s | %s\n"
+
+	color.New(color.Bold).Println("* System Time")
+	for _, s := range r.Sources {
+		name := fmt.Sprintf("NTP Server (%s)", s.Host)
+		switch {
+		case s.Error != "":
+			fmt.Printf(linefmt, name, s.Error)
+		case s.Outlier:
+			fmt.Printf(linefmt, name, colorStatus("", fmt.Sprintf("offset %+.3fs, outlier rejected", s.Offset), false))
+		default:
+			fmt.Printf(linefmt, name, fmt.Sprintf("%s (offset %+.3fs, rtt %.3fs, stratum %d)",
+				r.ClientTime.Add(time.Duration(s.Offset*float64(time.Second))).Format(timeFormat), s.Offset, s.RTT, s.Stratum))
+		}
+	}
+	if r.Offset != nil {
+		fmt.Printf(linefmt, "NTP Consensus", fmt.Sprintf("offset %+.3fs, jitter %.3fs (%d of %d servers)",
+			*r.Offset, *r.Jitter, r.Accepted, len(r.Sources)))
+	}
+	if r.ServerTime != nil {
+		fmt.Printf(linefmt, "SSM Server", r.ServerTime.Format(timeFormat))
+	}
+	fmt.Printf(linefmt, "SSM Client", r.ClientTime.Format(timeFormat))
+	if d := r.Daemon; d != nil {
+		text := d.Daemon
+		if d.Source != "" {
+			text += ", source " + d.Source
+		}
+		if d.Stratum != 0 {
+			text += fmt.Sprintf(", stratum %d", d.Stratum)
+		}
+		if d.Offset != nil {
+			text += fmt.Sprintf(", offset %+.6fs", *d.Offset)
+		}
+		fmt.Printf(linefmt, "Time Sync Daemon", colorStatus("synchronized", "not synchronized", d.Synchronized)+" ("+text+")")
+	} else {
+		fmt.Printf(linefmt, "Time Sync Daemon", colorStatus("", "not found", false))
+	}
+
+	if r.ServerDrift != nil {
+		fmt.Printf(linefmt, "SSM Server Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", *r.ServerDrift), *r.ServerDrift <= r.MaxDrift))
+		if *r.ServerDrift > r.MaxDrift {
+			fmt.Print("Time is out of sync. Please make sure the server time is correct to see the metrics.\n")
+		}
+	}
+	if r.ClientDrift != nil {
+		fmt.Printf(linefmt, "SSM Client Time Drift", colorStatus("OK", fmt.Sprintf("%.3fs", *r.ClientDrift), *r.ClientDrift <= r.MaxDrift))
+		if *r.ClientDrift > r.MaxDrift {
+			fmt.Print("Time is out of sync. Please make sure the client time is correct to see the metrics.\n")
+		}
+	}
+	if r.ClientServerDrift != nil {
+		fmt.Printf(linefmt, "SSM Client to SSM Server Time Drift", colorStatus("OK", fmt.Sprintf("%.0fs", *r.ClientServerDrift), *r.ClientServerDrift <= r.MaxDrift))
+		if *r.ClientServerDrift > r.MaxDrift {
+			fmt.Print("Time is out of sync. Please make sure the server time is correct to see the metrics.\n")
+		}
+	}
+	if r.Daemon != nil && !r.Daemon.Synchronized {
+		fmt.Print("Warning: the system clock is not synchronized, check chrony or systemd-timesyncd.\n")
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package ssm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNTPHosts(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"a.ntp", "b.ntp"}, ntpHosts("a.ntp, b.ntp,,a.ntp", "c.ntp"))
+	assert.Equal(t, []string{"c.ntp"}, ntpHosts("", "c.ntp"))
+	assert.Equal(t, []string{defaultNTPHost}, ntpHosts("", ""))
+}
+
+func TestNTPConsensus(t *testing.T) {
+	t.Parallel()
+
+	samples := []NTPSample{
+		{Host: "a", Offset: 0.010},
+		{Host: "b", Offset: 0.014},
+		{Host: "c", Offset: 0.012},
+		{Host: "d", Offset: 5.3},
+		{Host: "e", Error: "unable to get ntp time: timeout"},
+	}
+	offset, jitter, accepted := ntpConsensus(samples)
+	assert.Equal(t, 3, accepted)
+	assert.InDelta(t, 0.012, offset, 1e-9)
+	assert.InDelta(t, 0.00163, jitter, 1e-5)
+	assert.True(t, samples[3].Outlier)
+	for _, i := range []int{0, 1, 2, 4} {
+		assert.False(t, samples[i].Outlier, samples[i].Host)
+	}
+
+	_, _, accepted = ntpConsensus([]NTPSample{{Host: "e", Error: "timeout"}})
+	assert.Equal(t, 0, accepted)
+}
+
+func TestParseChronyTracking(t *testing.T) {
+	t.Parallel()
+
+	s, err := parseChronyTracking([]byte("A29FC87B,time.cloudflare.com,4,1760000000.123,-0.000012345,0.000001,0.000020,-12.3,0.001,0.020,0.010,0.001,64.5,Normal\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "chrony", s.Daemon)
+	assert.True(t, s.Synchronized)
+	assert.Equal(t, "time.cloudflare.com", s.Source)
+	assert.Equal(t, 4, s.Stratum)
+	require.NotNil(t, s.Offset)
+	assert.InDelta(t, -0.000012345, *s.Offset, 1e-12)
+
+	s, err = parseChronyTracking([]byte("00000000,,0,0.000,0.0,0.0,0.0,0.0,0.0,0.0,1.0,1.0,0.0,Not synchronised\n"))
+	require.NoError(t, err)
+	assert.False(t, s.Synchronized)
+
+	_, err = parseChronyTracking([]byte("506 Cannot talk to daemon\n"))
+	assert.Error(t, err)
+}
+
+func TestParseTimedatectl(t *testing.T) {
+	t.Parallel()
+
+	s := parseTimedatectl([]byte("Timezone=UTC\nCanNTP=yes\nNTP=yes\nNTPSynchronized=yes\n"), []byte("ServerName=ntp.ubuntu.com\nServerAddress=185.125.190.56\n"))
+	require.NotNil(t, s)
+	assert.Equal(t, &TimeSyncStatus{Daemon: "systemd-timesyncd", Synchronized: true, Source: "ntp.ubuntu.com"}, s)
+
+	s = parseTimedatectl([]byte("NTP=no\nNTPSynchronized=yes\n"), nil)
+	assert.Equal(t, &TimeSyncStatus{Daemon: "unknown", Synchronized: true}, s)
+
+	assert.Nil(t, parseTimedatectl([]byte("NTP=no\nNTPSynchronized=no\n"), nil))
+}
@@ -0,0 +1,347 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin"
+	"github.com/shatteredsilicon/ssm-client/ssm/plugin/mysql"
+	pc "github.com/shatteredsilicon/ssm/proto/config"
+	"gopkg.in/ini.v1"
+)
+
+// MetricsUpdate are changes of options of existing metrics service, nil fields are left as is.
+type MetricsUpdate struct {
+	// DisableSSL switches exporter between http and https.
+	DisableSSL *bool
+	// Cluster changes cluster of the service, empty removes it.
+	Cluster *string
+	// CustomOptions turns plugin custom options ON or OFF, e.g. tablestats=OFF.
+	CustomOptions map[string]string
+}
+
+// OptionsUpdate are options of the service before and after update.
+type OptionsUpdate struct {
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+	// Changed is false if the service already had the options.
+	Changed bool `json:"changed"`
+	// Restarted is the system service restarted to apply the update.
+	Restarted string `json:"restarted,omitempty"`
+	// Warnings are problems found with the new options which don't prevent the update.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// UpdateMetrics changes options of existing metrics service in place.
+// Exporter config and Consul service are updated and only the exporter of the service is restarted,
+// the service keeps its port and ID, so its metrics stay continuous.
+func (a *Admin) UpdateMetrics(svcType string, upd MetricsUpdate) (*OptionsUpdate, error) {
+	if isValidSvcType(svcType) != nil || !strings.HasSuffix(svcType, plugin.TypeMetrics) {
+		return nil, errors.New(`bad service type.
+
+Service type takes the following values: linux:metrics, mysql:metrics, mongodb:metrics, proxysql:metrics, postgresql:metrics.`)
+	}
+
+	consulSvc, err := a.getConsulService(svcType, a.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	if consulSvc == nil {
+		return nil, fmt.Errorf("%s %s: %w", svcType, a.ServiceName, ErrNoService)
+	}
+	instance := serviceInstance(consulSvc.ID)
+
+	res := &OptionsUpdate{}
+	_, _, res.Before = a.metricsOptions(consulSvc)
+
+	if len(upd.CustomOptions) > 0 {
+		m, ok := newUninitializedMetrics(svcType, instance).(plugin.Configurable)
+		if !ok {
+			return nil, fmt.Errorf("%s has no options to update", svcType)
+		}
+		if err := m.SetCustomOptions(upd.CustomOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	tags := append([]string{}, consulSvc.Tags...)
+	if upd.DisableSSL != nil {
+		if err := a.setExporterSSL(instanceConfigPath(svcType, instance), !*upd.DisableSSL); err != nil {
+			return nil, err
+		}
+		scheme := "scheme_https"
+		if *upd.DisableSSL {
+			scheme = "scheme_http"
+		}
+		tags = replaceTag(tags, "scheme_", scheme)
+	}
+	if upd.Cluster != nil {
+		cluster := ""
+		if *upd.Cluster != "" {
+			cluster = "cluster_" + *upd.Cluster
+		}
+		tags = replaceTag(tags, "cluster_", cluster)
+	}
+	if !reflect.DeepEqual(tags, consulSvc.Tags) {
+		consulSvc.Tags = tags
+		reg := consul.CatalogRegistration{
+			Node:    a.Config.ClientName,
+			Address: a.Config.ClientAddress,
+			Service: consulSvc,
+		}
+		if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	_, _, res.After = a.metricsOptions(consulSvc)
+	if reflect.DeepEqual(res.Before, res.After) {
+		return res, nil
+	}
+
+	res.Changed = true
+	res.Restarted = instanceServiceName(svcType, instance)
+	if err := restartService(res.Restarted); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// setExporterSSL sets or clears certificate in exporter config.
+func (a *Admin) setExporterSSL(cfgPath string, enable bool) error {
+	keyFile, certFile := "", ""
+	if enable {
+		if err := a.checkSSLCertificate(); err != nil {
+			return err
+		}
+		keyFile, certFile = SSLKeyFile, SSLCertFile
+	}
+	cfgFile, err := ini.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+	cfgFile.Section("web").Key("ssl-key-file").SetValue(keyFile)
+	cfgFile.Section("web").Key("ssl-cert-file").SetValue(certFile)
+	return cfgFile.SaveTo(cfgPath)
+}
+
+// replaceTag replaces tags with the prefix by the tag keeping its place, empty tag removes them.
+func replaceTag(tags []string, prefix, tag string) []string {
+	list := []string{}
+	replaced := false
+	for _, t := range tags {
+		if !strings.HasPrefix(t, prefix) {
+			list = append(list, t)
+			continue
+		}
+		if !replaced && tag != "" {
+			list = append(list, tag)
+		}
+		replaced = true
+	}
+	if !replaced && tag != "" {
+		list = append(list, tag)
+	}
+	return list
+}
+
+// QueriesUpdate are changes of Query Analytics options of existing queries service, nil fields are left as is.
+type QueriesUpdate struct {
+	// QuerySource is slowlog or perfschema, MySQL only.
+	QuerySource    *string
+	ExampleQueries *bool
+	// Slow log options, MySQL only.
+	SlowLogRotation *bool
+	RetainSlowLogs  *int
+	// FilterOmit and FilterAllow replace filter rules, see plugin.ValidateFilters, empty clears them.
+	FilterOmit  []string
+	FilterAllow []string
+}
+
+// UpdateQueries changes Query Analytics options of existing queries service in place.
+// The QAN instance is kept, new config is pushed to the agent which restarts collecting with it.
+// Switching MySQL query source is refused if MySQL user lacks privileges the new source needs.
+func (a *Admin) UpdateQueries(ctx context.Context, svcType string, upd QueriesUpdate) (*OptionsUpdate, error) {
+	if svcType != plugin.MySQLQueries && svcType != plugin.MongoDBQueries {
+		return nil, errors.New(`bad service type.
+
+Service type takes the following values: mysql:queries, mongodb:queries.`)
+	}
+	name := strings.Split(svcType, ":")[0]
+	if name != plugin.NameMySQL && (upd.QuerySource != nil || upd.SlowLogRotation != nil || upd.RetainSlowLogs != nil || upd.FilterOmit != nil || upd.FilterAllow != nil) {
+		return nil, fmt.Errorf("only query examples can be updated for %s", svcType)
+	}
+	if upd.QuerySource != nil && *upd.QuerySource != "slowlog" && *upd.QuerySource != "perfschema" {
+		return nil, errors.New("query source must be slowlog or perfschema")
+	}
+	if upd.RetainSlowLogs != nil && *upd.RetainSlowLogs < 0 {
+		return nil, errors.New("number of slow logs to retain can't be negative")
+	}
+	if err := plugin.ValidateFilters(upd.FilterOmit); err != nil {
+		return nil, err
+	}
+	if err := plugin.ValidateFilters(upd.FilterAllow); err != nil {
+		return nil, err
+	}
+
+	consulSvc, err := a.getConsulService(svcType, a.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	if consulSvc == nil {
+		return nil, fmt.Errorf("%s %s: %w", svcType, a.ServiceName, ErrNoService)
+	}
+	key := fmt.Sprintf("%s/%s/%s/qan_%s_uuid", a.Config.ClientName, consulSvc.ID, a.ServiceName, name)
+	data, _, err := a.consulAPI.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("can't get key %s", key)
+	}
+	uuid := string(data.Value)
+
+	cfgPath := fmt.Sprintf("%s/config/qan-%s.conf", AgentBaseDir, uuid)
+	config, err := getProtoQAN(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	res := &OptionsUpdate{Before: queriesOptions(name, config)}
+
+	updated := *config
+	if upd.QuerySource != nil {
+		updated.CollectFrom = *upd.QuerySource
+	}
+	if upd.ExampleQueries != nil {
+		updated.ExampleQueries = upd.ExampleQueries
+	}
+	if upd.SlowLogRotation != nil {
+		updated.SlowLogRotation = upd.SlowLogRotation
+	}
+	if upd.RetainSlowLogs != nil {
+		updated.RetainSlowLogs = upd.RetainSlowLogs
+	}
+	if upd.FilterOmit != nil {
+		updated.FilterOmit = upd.FilterOmit
+		if len(upd.FilterOmit) == 0 {
+			updated.FilterOmit = nil
+		}
+	}
+	if upd.FilterAllow != nil {
+		updated.FilterAllow = upd.FilterAllow
+		if len(upd.FilterAllow) == 0 {
+			updated.FilterAllow = nil
+		}
+	}
+	res.After = queriesOptions(name, &updated)
+	if reflect.DeepEqual(config, &updated) {
+		return res, nil
+	}
+
+	if name == plugin.NameMySQL && (updated.CollectFrom != config.CollectFrom ||
+		boolValue(updated.SlowLogRotation) && !boolValue(config.SlowLogRotation)) {
+		res.Warnings, err = a.checkMySQLQueries(ctx, uuid, updated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Restart QAN of the instance on the agent with the new config.
+	agentID, err := getAgentID(fmt.Sprintf("%s/config/agent.conf", AgentBaseDir))
+	if err != nil {
+		return nil, err
+	}
+	if err := a.stopQAN(agentID, uuid); err != nil {
+		return nil, err
+	}
+	if err := a.startQAN(agentID, updated); err != nil {
+		// Try to get back collecting with the old config.
+		a.startQAN(agentID, *config)
+		return nil, err
+	}
+	res.Changed = true
+
+	// Agent saves the config it runs with, write it anyway so list shows the update right away.
+	bytes, _ := json.MarshalIndent(updated, "", "    ")
+	if err := ioutil.WriteFile(cfgPath, bytes, 0600); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// openMySQL opens MySQL connection, replaced in tests.
+var openMySQL = func(dsn string) (*sql.DB, error) {
+	return sql.Open("mysql", dsn)
+}
+
+// checkMySQLQueries checks MySQL user of the QAN instance can collect queries with the config.
+// Missing privileges are returned as error, slow log problems and failed checks as warnings.
+func (a *Admin) checkMySQLQueries(ctx context.Context, uuid string, config pc.QAN) ([]string, error) {
+	instance, err := a.readInstanceFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, uuid))
+	if err != nil {
+		return []string{fmt.Sprintf("cannot check MySQL privileges: %s", err)}, nil
+	}
+	db, err := openMySQL(instance.DSN)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot check MySQL privileges: %s", err)}, nil
+	}
+	defer db.Close()
+
+	g := mysql.Grants{QuerySource: config.CollectFrom, SlowLogRotation: boolValue(config.SlowLogRotation)}
+	missing, err := mysql.MissingPrivileges(ctx, db, g)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot check MySQL privileges: %s", err)}, nil
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf(`MySQL user lacks privileges required to collect queries from %s: %s.
+
+Grant them and run the update again, 'ssm-admin add mysql:queries --print-grants' prints the required grants.`,
+			config.CollectFrom, strings.Join(missing, ", "))
+	}
+
+	if config.CollectFrom != "slowlog" {
+		return nil, nil
+	}
+	problems, err := mysql.CheckSlowLog(ctx, db)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot check slow log settings: %s", err)}, nil
+	}
+	var warnings []string
+	for _, p := range problems {
+		warnings = append(warnings, "slow log "+p.String())
+	}
+	return warnings, nil
+}
+
+// queriesOptions returns Query Analytics options shown by list.
+func queriesOptions(name string, config *pc.QAN) []string {
+	opts := getQueriesOptions(config)
+	if name == plugin.NameMySQL {
+		opts = append(opts, getMySQLQueriesOptions(config)...)
+	}
+	return opts
+}
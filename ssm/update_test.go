@@ -0,0 +1,265 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package ssm
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	consul "github.com/hashicorp/consul/api"
+	service "github.com/percona/kardianos-service"
+	"github.com/shatteredsilicon/ssm-client/tests/fakeapi"
+	"github.com/shatteredsilicon/ssm/proto"
+	pc "github.com/shatteredsilicon/ssm/proto/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func TestReplaceTag(t *testing.T) {
+	tags := []string{"alias_db01", "scheme_https", "distro_MySQL"}
+	assert.Equal(t, []string{"alias_db01", "scheme_http", "distro_MySQL"}, replaceTag(tags, "scheme_", "scheme_http"))
+	assert.Equal(t, []string{"alias_db01", "distro_MySQL"}, replaceTag(tags, "scheme_", ""))
+	assert.Equal(t, []string{"alias_db01", "scheme_https", "distro_MySQL", "cluster_c1"}, replaceTag(tags, "cluster_", "cluster_c1"))
+}
+
+func TestAdmin_UpdateMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-update")
+	require.NoError(t, err)
+	defer func(baseDir string, newService func(service.Interface, *service.Config) (service.Service, error)) {
+		SSMBaseDir, NewService = baseDir, newService
+	}(SSMBaseDir, NewService)
+	SSMBaseDir = dir
+	restarted := []string{}
+	NewService = func(i service.Interface, c *service.Config) (service.Service, error) {
+		restarted = append(restarted, c.Name)
+		return &dummyService{}, nil
+	}
+
+	cfgPath := filepath.Join(dir, "mysqld_exporter-db02.conf")
+	require.NoError(t, ioutil.WriteFile(cfgPath, []byte(`[web]
+listen-address = 127.0.0.1:42003
+ssl-key-file = server.key
+ssl-cert-file = server.crt
+
+[collect]
+info_schema.processlist = 1
+`), 0600))
+
+	fake := &fakeConsul{
+		node: "client1",
+		services: map[string]*consul.AgentService{
+			"mysql:metrics@db02": {ID: "mysql:metrics@db02", Service: "mysql:metrics", Port: 42003,
+				Tags: []string{"alias_db02", "scheme_https"}},
+			"mongodb:metrics": {ID: "mongodb:metrics", Service: "mongodb:metrics", Port: 42004,
+				Tags: []string{"alias_rs1", "scheme_https", "cluster_old"}},
+		},
+		kv: map[string][]byte{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	admin := &Admin{Config: &Config{ClientName: "client1", ClientAddress: "127.0.0.1"}}
+	admin.consulAPI, err = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+
+	t.Run("mysql", func(t *testing.T) {
+		restarted = restarted[:0]
+		admin.ServiceName = "db02"
+		disableSSL := true
+		res, err := admin.UpdateMetrics("mysql:metrics", MetricsUpdate{
+			DisableSSL:    &disableSSL,
+			CustomOptions: map[string]string{"processlist": "OFF"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{}, res.Before)
+		assert.Equal(t, []string{"scheme=http", "processlist=OFF"}, res.After)
+		assert.Equal(t, "ssm-mysql-metrics@db02", res.Restarted)
+		assert.Equal(t, []string{"ssm-mysql-metrics@db02"}, restarted)
+		assert.Equal(t, []string{"alias_db02", "scheme_http"}, fake.services["mysql:metrics@db02"].Tags)
+		assert.Equal(t, 42003, fake.services["mysql:metrics@db02"].Port)
+
+		cfg, err := ini.Load(cfgPath)
+		require.NoError(t, err)
+		assert.Equal(t, "", cfg.Section("web").Key("ssl-key-file").String())
+		assert.Equal(t, "0", cfg.Section("collect").Key("info_schema.processlist").String())
+
+		// Nothing changes the second time.
+		restarted = restarted[:0]
+		res, err = admin.UpdateMetrics("mysql:metrics", MetricsUpdate{CustomOptions: map[string]string{"processlist": "OFF"}})
+		require.NoError(t, err)
+		assert.Equal(t, res.Before, res.After)
+		assert.Empty(t, res.Restarted)
+		assert.Empty(t, restarted)
+
+		_, err = admin.UpdateMetrics("mysql:metrics", MetricsUpdate{CustomOptions: map[string]string{"foo": "OFF"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("mongodb", func(t *testing.T) {
+		admin.ServiceName = "rs1"
+		cluster := "new"
+		res, err := admin.UpdateMetrics("mongodb:metrics", MetricsUpdate{Cluster: &cluster})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cluster=old"}, res.Before)
+		assert.Equal(t, []string{"cluster=new"}, res.After)
+
+		_, err = admin.UpdateMetrics("mongodb:metrics", MetricsUpdate{CustomOptions: map[string]string{"processlist": "OFF"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		admin.ServiceName = "db03"
+		_, err := admin.UpdateMetrics("mysql:metrics", MetricsUpdate{})
+		assert.True(t, errors.Is(err, ErrNoService), "%v", err)
+		_, err = admin.UpdateMetrics("mysql:queries", MetricsUpdate{})
+		assert.Error(t, err)
+	})
+}
+
+func TestAdmin_UpdateQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-update")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(baseDir string, open func(string) (*sql.DB, error)) {
+		AgentBaseDir, openMySQL = baseDir, open
+	}(AgentBaseDir, openMySQL)
+	AgentBaseDir = dir
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "config"), 0700))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "instance"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config", "agent.conf"), []byte(`{"UUID":"agent1"}`), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "instance", "abc.json"),
+		[]byte(`{"Subsystem":"mysql","UUID":"abc","Name":"db01","DSN":"ssm:pass@tcp(127.0.0.1:3306)/"}`), 0600))
+	cfgPath := filepath.Join(dir, "config", "qan-abc.conf")
+	require.NoError(t, ioutil.WriteFile(cfgPath, []byte(`{
+    "UUID": "abc",
+    "CollectFrom": "slowlog",
+    "Interval": 60,
+    "ExampleQueries": true,
+    "SlowLogRotation": true,
+    "RetainSlowLogs": 1
+}`), 0600))
+
+	cmds := []proto.Cmd{}
+	api := fakeapi.New()
+	api.Append("/qan-api/agents/agent1/cmd", func(w http.ResponseWriter, r *http.Request) {
+		var cmd proto.Cmd
+		json.NewDecoder(r.Body).Decode(&cmd)
+		cmds = append(cmds, cmd)
+	})
+	defer api.Close()
+	_, host, port := api.Start()
+
+	fake := &fakeConsul{
+		node: "client1",
+		services: map[string]*consul.AgentService{
+			"mysql:queries": {ID: "mysql:queries", Service: "mysql:queries", Tags: []string{"alias_db01"}},
+		},
+		kv: map[string][]byte{"client1/mysql:queries/db01/qan_mysql_uuid": []byte("abc")},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	admin := &Admin{Config: &Config{ClientName: "client1"}, ServiceName: "db01"}
+	admin.consulAPI, err = consul.NewClient(&consul.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	require.NoError(t, err)
+	admin.qanAPI = NewAPI(&tls.Config{InsecureSkipVerify: true}, 1*time.Second, false)
+	admin.serverURL = fmt.Sprintf("http://%s:%s", host, port)
+
+	// Privileges of MySQL user are checked with the DSN of the QAN instance.
+	grants := []string{"GRANT SELECT, PROCESS, SUPER, RELOAD ON *.* TO `ssm`@`localhost`"}
+	openMySQL = func(dsn string) (*sql.DB, error) {
+		assert.Equal(t, "ssm:pass@tcp(127.0.0.1:3306)/", dsn)
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		mock.ExpectQuery("SELECT @@GLOBAL.version").WillReturnRows(sqlmock.NewRows([]string{"@@GLOBAL.version"}).AddRow("8.0.36"))
+		rows := sqlmock.NewRows([]string{"Grants for ssm@localhost"})
+		for _, grant := range grants {
+			rows.AddRow(grant)
+		}
+		mock.ExpectQuery("SHOW GRANTS").WillReturnRows(rows)
+		return db, nil
+	}
+
+	ctx := context.Background()
+	source, examples, retain := "perfschema", false, 3
+	_, err = admin.UpdateQueries(ctx, "mysql:queries", QueriesUpdate{QuerySource: &source})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "UPDATE ON performance_schema.setup_consumers")
+	assert.Empty(t, cmds)
+
+	grants = append(grants, "GRANT UPDATE, DROP ON `performance_schema`.* TO `ssm`@`localhost`")
+	res, err := admin.UpdateQueries(ctx, "mysql:queries", QueriesUpdate{
+		QuerySource:    &source,
+		ExampleQueries: &examples,
+		RetainSlowLogs: &retain,
+		FilterOmit:     []string{"SELECT 1"},
+		FilterAllow:    []string{"SELECT a, b FROM t", "SELECT 2"},
+	})
+	require.NoError(t, err)
+	assert.True(t, res.Changed)
+	assert.Equal(t, []string{"query_source=slowlog", "query_examples=true", "slow_log_rotation=true", "retain_slow_logs=1"}, res.Before)
+	assert.Equal(t, []string{"query_source=perfschema", "query_examples=false", `filter_omit="SELECT 1"`, `filter_allow="SELECT a, b FROM t";"SELECT 2"`}, res.After)
+
+	require.Len(t, cmds, 2)
+	assert.Equal(t, "StopTool", cmds[0].Cmd)
+	assert.Equal(t, "abc", string(cmds[0].Data))
+	assert.Equal(t, "StartTool", cmds[1].Cmd)
+	var started pc.QAN
+	require.NoError(t, json.Unmarshal(cmds[1].Data, &started))
+	assert.Equal(t, "abc", started.UUID)
+	assert.Equal(t, "perfschema", started.CollectFrom)
+	assert.Equal(t, []string{"SELECT 1"}, started.FilterOmit)
+	assert.Equal(t, []string{"SELECT a, b FROM t", "SELECT 2"}, started.FilterAllow)
+	// qan-agent reads the rules as lists of query text.
+	assert.Contains(t, string(cmds[1].Data), `"FilterOmit":["SELECT 1"],"FilterAllow":["SELECT a, b FROM t","SELECT 2"]`)
+
+	config, err := getProtoQAN(cfgPath)
+	require.NoError(t, err)
+	assert.Equal(t, 3, intValue(config.RetainSlowLogs))
+
+	// Nothing is sent if options are the same.
+	cmds = cmds[:0]
+	res, err = admin.UpdateQueries(ctx, "mysql:queries", QueriesUpdate{QuerySource: &source})
+	require.NoError(t, err)
+	assert.False(t, res.Changed)
+	assert.Empty(t, cmds)
+
+	_, err = admin.UpdateQueries(ctx, "mysql:queries", QueriesUpdate{FilterOmit: []string{"user:app"}})
+	assert.Error(t, err)
+	bad := "auto"
+	_, err = admin.UpdateQueries(ctx, "mysql:queries", QueriesUpdate{QuerySource: &bad})
+	assert.Error(t, err)
+	_, err = admin.UpdateQueries(ctx, "mongodb:queries", QueriesUpdate{RetainSlowLogs: &retain})
+	assert.Error(t, err)
+	_, err = admin.UpdateQueries(ctx, "mysql:metrics", QueriesUpdate{})
+	assert.Error(t, err)
+}
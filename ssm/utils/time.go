@@ -0,0 +1,68 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// TimeHelp describes time values ParseTime accepts, for flag usage.
+const TimeHelp = `"now", relative to now like "-2h" or "-7d", unix timestamp or "2006-01-02 15:04:05" (local time, RFC3339 too)`
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses absolute or relative to now time given by user.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "now" {
+		return now, nil
+	}
+
+	// Relative time: -2h, +30m, -7d.
+	if value[0] == '-' || value[0] == '+' {
+		d, err := model.ParseDuration(value[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %s", value, err)
+		}
+		if value[0] == '-' {
+			return now.Add(-time.Duration(d)), nil
+		}
+		return now.Add(time.Duration(d)), nil
+	}
+
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use %s", value, TimeHelp)
+}
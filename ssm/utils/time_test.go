@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	for value, expected := range map[string]time.Time{
+		"":                     now,
+		"now":                  now,
+		"-2h":                  now.Add(-2 * time.Hour),
+		"+30m":                 now.Add(30 * time.Minute),
+		"-7d":                  now.Add(-7 * 24 * time.Hour),
+		"1717243200":           time.Unix(1717243200, 0),
+		"2024-05-31 08:30:00":  time.Date(2024, 5, 31, 8, 30, 0, 0, time.Local),
+		"2024-05-31":           time.Date(2024, 5, 31, 0, 0, 0, 0, time.Local),
+		"2024-05-31T08:30:00Z": time.Date(2024, 5, 31, 8, 30, 0, 0, time.UTC),
+	} {
+		actual, err := ParseTime(value, now)
+		require.NoError(t, err, value)
+		assert.True(t, expected.Equal(actual), "%s: %s != %s", value, expected, actual)
+	}
+
+	for _, value := range []string{"yesterday", "-2x", "2024-13-01"} {
+		_, err := ParseTime(value, now)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultConnectTimeout is used if TransportConfig.ConnectTimeout is not set.
+	DefaultConnectTimeout = 5 * time.Second
+	// DefaultRetries is number of retries of idempotent requests by DefaultRetryPolicy.
+	DefaultRetries = 3
+)
+
+// TransportConfig configures HTTP transport to SSM server.
+type TransportConfig struct {
+	TLSConfig *tls.Config
+	// ProxyURL overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	ProxyURL string
+	// ConnectTimeout limits TCP connect and TLS handshake.
+	ConnectTimeout time.Duration
+	// ReadTimeout limits waiting for response headers once request is sent, 0 means no limit.
+	ReadTimeout time.Duration
+}
+
+// NewTransport returns *http.Transport for the config.
+func NewTransport(cfg TransportConfig) (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q.", cfg.ProxyURL)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       cfg.TLSConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}, nil
+}
+
+// RetryPolicy retries idempotent requests failed with network errors or
+// temporary server errors, waiting exponentially longer between attempts.
+type RetryPolicy struct {
+	Retries    int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is retry policy of requests to SSM server.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:    DefaultRetries,
+	MinBackoff: 500 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+}
+
+// Backoff returns delay before retry attempt, starting from 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// IsIdempotent checks if request with the method may be safely sent more than once.
+func IsIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// retryable checks if the request should be retried after the response or error.
+// Timed out requests are not retried as every attempt would wait the whole timeout again.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return false
+		}
+		return err != context.Canceled && err != context.DeadlineExceeded
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Do sends the request with the client, retrying it if it is idempotent.
+// Request body is restored from req.GetBody for every attempt.
+func (p RetryPolicy) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	retries := p.Retries
+	if !IsIdempotent(req.Method) || (req.Body != nil && req.GetBody == nil) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := client.Do(req)
+		if attempt >= retries || !retryable(resp, unwrapURLError(err)) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(p.Backoff(attempt + 1)):
+		}
+	}
+}
+
+// unwrapURLError returns error wrapped by *url.Error returned by http.Client.
+func unwrapURLError(err error) error {
+	if e, ok := err.(*url.Error); ok {
+		return e.Err
+	}
+	return err
+}
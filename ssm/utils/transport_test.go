@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransportProxy(t *testing.T) {
+	transport, err := NewTransport(TransportConfig{ProxyURL: "http://proxy.example.com:3128"})
+	require.NoError(t, err)
+	req, _ := http.NewRequest("GET", "https://ssm.example.com/qan-api/ping", nil)
+	proxy, err := transport.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "proxy.example.com:3128", proxy.Host)
+
+	_, err = NewTransport(TransportConfig{ProxyURL: "proxy.example.com"})
+	assert.Error(t, err)
+
+	os.Setenv("HTTPS_PROXY", "http://env-proxy.example.com:8080")
+	defer os.Unsetenv("HTTPS_PROXY")
+	transport, err = NewTransport(TransportConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConnectTimeout, transport.TLSHandshakeTimeout)
+	assert.NotNil(t, transport.Proxy)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Retries: 5, MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	assert.Equal(t, 100*time.Millisecond, p.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.Backoff(2))
+	assert.Equal(t, 400*time.Millisecond, p.Backoff(3))
+	assert.Equal(t, time.Second, p.Backoff(5))
+	assert.Equal(t, time.Second, p.Backoff(10))
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	var calls int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := RetryPolicy{Retries: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	client := &http.Client{}
+
+	req, _ := http.NewRequest("PUT", server.URL, bytes.NewReader([]byte("data")))
+	resp, err := p.Do(client, req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []string{"data", "data", "data"}, bodies)
+
+	// POST is not idempotent, it is sent once.
+	calls = 0
+	req, _ = http.NewRequest("POST", server.URL, bytes.NewReader([]byte("data")))
+	resp, err = p.Do(client, req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+
+	// Retries are limited.
+	calls = -10
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	resp, err = p.Do(client, req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, -6, calls)
+}
+
+func TestRetryPolicyDoTimeout(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	p := RetryPolicy{Retries: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+
+	// Client timeout is not retried.
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := p.Do(client, req)
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
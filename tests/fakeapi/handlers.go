@@ -236,8 +236,22 @@ func (f *FakeApi) AddAnnotation() {
 		switch r.Method {
 		case "POST":
 			w.WriteHeader(http.StatusOK)
+		case "GET":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"annotations":[{"id":1,"text":"Deploy v1.2","tags":["v1.2","` + r.URL.Query().Get("tags[]") + `"],"time":1717243200000},` +
+				`{"id":2,"text":"Maintenance","time":1717243200000,"time_end":1717248600000}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	f.Append("/managed/v0/annotations/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE" && r.URL.Path == "/managed/v0/annotations/1":
+			w.WriteHeader(http.StatusOK)
 		default:
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"annotation not found","code":5}`))
 		}
 	})
 }